@@ -0,0 +1,95 @@
+// Команда icsimport загружает события из файлов iCalendar напрямую в хранилище,
+// заданное config.yaml: wal или sqlite. Для wal сервер на время импорта нужно
+// остановить: иначе используйте POST /import_ics. Хранилище memory не подходит,
+// события в нем не переживут завершение команды.
+//
+//	go run ./cmd/icsimport -user 1 team.ics personal.ics
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	_ "time/tzdata" // база часовых поясов нужна в образе alpine без tzdata
+	"wb-calendar/config"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/ical"
+	"wb-calendar/internal/storage"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	userID := flag.Int("user", 0, "ID пользователя, которому принадлежат события")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -user ID FILE.ics... (- читает stdin)\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *userID <= 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	_ = godotenv.Load()
+	cfg := config.MustLoad()
+	if cfg.Storage.Type == "" || cfg.Storage.Type == storage.TypeMemory {
+		fmt.Fprintln(os.Stderr, "storage type memory is not persistent: set storage.type (STORAGE_TYPE) to wal or sqlite")
+		os.Exit(1)
+	}
+
+	repo, closer, err := storage.New(cfg.Storage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	service := calendar.NewService(repo)
+	failed := false
+	for _, path := range flag.Args() {
+		if err := importFile(service, *userID, path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		}
+	}
+
+	if err := closer.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close storage: %v\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// importFile импортирует один файл и печатает отчет в формате JSON
+func importFile(service *calendar.Service, userID int, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := ical.Import(service, userID, r)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(struct {
+		File string `json:"file"`
+		ical.Report
+	}{path, report}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+	return nil
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // база часовых поясов нужна в образе alpine без tzdata
 	"wb-calendar/config"
+	"wb-calendar/internal/apikey"
 	"wb-calendar/internal/calendar"
 	"wb-calendar/internal/handler"
+	"wb-calendar/internal/storage"
 	"wb-calendar/pkg/logger"
 
 	"github.com/joho/godotenv"
@@ -20,10 +28,60 @@ func main() {
 	cfg := config.MustLoad()
 	logger.Log.Infof("Server starting on %s", cfg.HTTPServer.Address)
 
-	service := calendar.NewService()
-	router := handler.InitRoute(service)
+	repo, closer, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Log.Fatalf("Failed to init storage: %v", err)
+	}
+	defer func() {
+		if err := closer.Close(); err != nil {
+			logger.Log.Errorf("Failed to close storage: %v", err)
+		}
+	}()
+
+	var keys *apikey.Store
+	if cfg.APIKeys.Path != "" {
+		if keys, err = apikey.Open(cfg.APIKeys.Path); err != nil {
+			logger.Log.Fatalf("Failed to open api keys: %v", err)
+		}
+		defer func() {
+			if err := keys.Close(); err != nil {
+				logger.Log.Errorf("Failed to close api keys: %v", err)
+			}
+		}()
+	}
+
+	service := calendar.NewService(repo)
+	router, err := handler.InitRoute(service, keys, cfg)
+	if err != nil {
+		logger.Log.Fatalf("Failed to init routes: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:         cfg.HTTPServer.Address,
+		Handler:      router,
+		ReadTimeout:  cfg.HTTPServer.Timeout,
+		WriteTimeout: cfg.HTTPServer.Timeout,
+		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
+	}
+
+	// Останавливаемся по сигналу, чтобы хранилище успело сбросить данные на диск
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log.Errorf("Failed to start server: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Log.Info("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.Timeout)
+	defer cancel()
 
-	if err := router.Run(cfg.HTTPServer.Address); err != nil {
-		logger.Log.Fatalf("Failed to start server: %v", err)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Errorf("Failed to shutdown server: %v", err)
 	}
 }
@@ -9,7 +9,12 @@ import (
 )
 
 type Config struct {
-	HTTPServer HTTPServer `yaml:"http_server"`
+	HTTPServer  HTTPServer  `yaml:"http_server"`
+	Auth        Auth        `yaml:"auth"`
+	Storage     Storage     `yaml:"storage"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	JWT         JWT         `yaml:"jwt"`
+	APIKeys     APIKeys     `yaml:"api_keys"`
 }
 
 type HTTPServer struct {
@@ -20,6 +25,64 @@ type HTTPServer struct {
 	Password    string        `yaml:"password" env:"HTTP_PASSWORD"`
 }
 
+// Auth задает общие настройки аутентификации. Без настроенных учетных данных,
+// ключей JWT или ключей API сервер не запускается, если Disabled не включен явно.
+type Auth struct {
+	Disabled bool `yaml:"disabled" env:"AUTH_DISABLED"`
+}
+
+// Storage задает бэкенд хранения событий
+type Storage struct {
+	Type   string `yaml:"type" env:"STORAGE_TYPE" env-default:"memory"`
+	WAL    WAL    `yaml:"wal"`
+	SQLite SQLite `yaml:"sqlite"`
+}
+
+// WAL задает журнал изменений для бэкенда wal
+type WAL struct {
+	Path      string        `yaml:"path" env:"WAL_PATH" env-default:"./data/events.wal"`
+	Fsync     string        `yaml:"fsync" env:"WAL_FSYNC" env-default:"always"`
+	BatchSize int           `yaml:"batch_size" env:"WAL_BATCH_SIZE" env-default:"64"`
+	Interval  time.Duration `yaml:"interval" env:"WAL_INTERVAL" env-default:"1s"`
+	Snapshot  Snapshot      `yaml:"snapshot"`
+}
+
+// SQLite задает файл базы для бэкенда sqlite
+type SQLite struct {
+	Path string `yaml:"path" env:"SQLITE_PATH" env-default:"./data/events.db"`
+}
+
+// Snapshot задает периодические снимки, после которых журнал сокращается
+type Snapshot struct {
+	Dir      string        `yaml:"dir" env:"SNAPSHOT_DIR" env-default:"./data/snapshots"`
+	Interval time.Duration `yaml:"interval" env:"SNAPSHOT_INTERVAL" env-default:"10m"`
+	EveryN   int           `yaml:"every_n" env:"SNAPSHOT_EVERY_N" env-default:"10000"`
+	Retain   int           `yaml:"retain" env:"SNAPSHOT_RETAIN" env-default:"2"`
+}
+
+// Idempotency задает, сколько хранится ответ на запрос с Idempotency-Key
+type Idempotency struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" env-default:"24h"`
+}
+
+// JWT задает проверку токенов Bearer. Подпись HS256 проверяется общим секретом,
+// RS256 — открытым ключом в PEM или ключами из локального файла JWKS.
+type JWT struct {
+	Secret        string        `yaml:"secret" env:"JWT_SECRET"`
+	PublicKeyFile string        `yaml:"public_key_file" env:"JWT_PUBLIC_KEY_FILE"`
+	JWKSFile      string        `yaml:"jwks_file" env:"JWT_JWKS_FILE"`
+	Issuer        string        `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience      string        `yaml:"audience" env:"JWT_AUDIENCE"`
+	UserClaim     string        `yaml:"user_claim" env:"JWT_USER_CLAIM" env-default:"sub"`
+	Leeway        time.Duration `yaml:"leeway" env:"JWT_LEEWAY" env-default:"30s"`
+}
+
+// APIKeys задает файл ключей API. Без пути ключи API отключены. Ключи требуют
+// учетных данных HTTP Basic: ими входит администратор, который управляет ключами.
+type APIKeys struct {
+	Path string `yaml:"path" env:"API_KEYS_PATH"`
+}
+
 func MustLoad() *Config {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
@@ -36,9 +99,9 @@ func MustLoad() *Config {
 	}
 
 	// Пароль и логин скрываем от пользователя
-	log.Printf("Loaded config from %s: {HTTPServer: {Address: %s, Timeout: %s, IdleTimeout: %s, User: %s}}",
+	log.Printf("Loaded config from %s: {HTTPServer: {Address: %s, Timeout: %s, IdleTimeout: %s, User: %s}, Storage: {Type: %s}}",
 		configPath, cfg.HTTPServer.Address, cfg.HTTPServer.Timeout,
-		cfg.HTTPServer.IdleTimeout, cfg.HTTPServer.User)
+		cfg.HTTPServer.IdleTimeout, cfg.HTTPServer.User, cfg.Storage.Type)
 
 	return &cfg
 }
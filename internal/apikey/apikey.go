@@ -0,0 +1,321 @@
+// Package apikey хранит долгоживущие ключи API для интеграций.
+// Ключ выдается один раз, на диске лежит только его хеш.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg"
+)
+
+// Scopes все области, которые можно выдать ключу. Их проверяет middleware.EnforceScopes.
+var Scopes = []string{middleware.ScopeRead, middleware.ScopeWrite}
+
+// tokenPrefix начало каждого ключа, чтобы его было легко узнать в логах и секретах
+const tokenPrefix = "wbc_"
+
+// touchInterval как часто время последнего использования ключа сохраняется на диск.
+// В памяти оно обновляется при каждом запросе.
+const touchInterval = time.Minute
+
+// Key описание ключа без секрета
+type Key struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     int        `json:"user_id"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// storedKey ключ в файле хранилища: описание и SHA-256 секрета
+type storedKey struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// Store ключи API в памяти с сохранением в JSON-файл
+type Store struct {
+	path  string
+	keys  map[string]*storedKey
+	saved map[string]time.Time
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+// Проверяем, что Store подходит как источник ключей для middleware.APIKeyAuth
+var _ middleware.APIKeySource = (*Store)(nil)
+
+// Open загружает ключи из файла path. Если файла нет, хранилище пустое,
+// файл создается при выдаче первого ключа.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		keys:  make(map[string]*storedKey),
+		saved: make(map[string]time.Time),
+		now:   time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apikey: read %s: %w", path, err)
+	}
+
+	var keys []storedKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("apikey: decode %s: %w", path, err)
+	}
+	for i := range keys {
+		key := &keys[i]
+		s.keys[key.ID] = key
+		if key.LastUsedAt != nil {
+			s.saved[key.ID] = *key.LastUsedAt
+		}
+	}
+
+	return s, nil
+}
+
+// Issue выдает ключ пользователю userID с областями scopes и возвращает
+// его описание и сам ключ. Ключ больше нигде не хранится в открытом виде.
+func (s *Store) Issue(name string, userID int, scopes []string) (Key, string, error) {
+	var v pkg.ValidationError
+	if strings.TrimSpace(name) == "" {
+		v.Add(pkg.Required("name", "name cannot be empty"))
+	}
+	if userID <= 0 {
+		v.Add(pkg.Invalid("user_id", "user_id must be positive"))
+	}
+	if len(scopes) == 0 {
+		v.Add(pkg.Required("scopes", "at least one scope is required"))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			v.Add(pkg.Invalid("scopes", "unknown scope "+scope+", expected one of "+strings.Join(Scopes, ", ")))
+		}
+	}
+	if err := v.Err(); err != nil {
+		return Key{}, "", err
+	}
+
+	id, secret, err := newSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	key := &storedKey{
+		Key: Key{
+			ID:        id,
+			Name:      name,
+			UserID:    userID,
+			Scopes:    slices.Compact(scopes),
+			CreatedAt: s.now().UTC(),
+		},
+		Hash: hashSecret(secret),
+	}
+
+	s.keys[id] = key
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return Key{}, "", err
+	}
+
+	return key.Key, tokenPrefix + id + "_" + secret, nil
+}
+
+// List возвращает ключи пользователя userID или все ключи при userID 0,
+// в порядке выдачи. Отозванные ключи тоже возвращаются.
+func (s *Store) List(userID int) []Key {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		if userID == 0 || key.UserID == userID {
+			keys = append(keys, key.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys
+}
+
+// Revoke отзывает ключ. Повторный отзыв ничего не меняет.
+func (s *Store) Revoke(id string) (Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, pkg.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return key.Key, nil
+	}
+
+	now := s.now().UTC()
+	key.RevokedAt = &now
+	if err := s.save(); err != nil {
+		key.RevokedAt = nil
+		return Key{}, err
+	}
+
+	return key.Key, nil
+}
+
+// Verify проверяет ключ и отмечает время его использования.
+// Для отозванного или неизвестного ключа возвращает false.
+func (s *Store) Verify(token string) (middleware.Principal, bool) {
+	id, secret, ok := parseToken(token)
+	if !ok {
+		return middleware.Principal{}, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[id]
+	// Хеш сравнивается и для неизвестного ID, чтобы время ответа не выдавало существующие ключи
+	want := strings.Repeat("0", sha256.Size*2)
+	if exists {
+		want = key.Hash
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(want)) != 1 || !exists || key.RevokedAt != nil {
+		return middleware.Principal{}, false
+	}
+
+	now := s.now().UTC()
+	key.LastUsedAt = &now
+	if now.Sub(s.saved[id]) >= touchInterval {
+		if err := s.save(); err != nil {
+			log.Printf("apikey: save last use of key %s: %v", id, err)
+		}
+	}
+
+	// Пустой список областей, а не nil: ключ без областей ничего не может
+	scopes := append([]string{}, key.Scopes...)
+	return middleware.Principal{Name: "api-key:" + key.Name, UserID: key.UserID, Scopes: scopes}, true
+}
+
+// Close сохраняет время последнего использования ключей, которое еще
+// не попало на диск из-за touchInterval
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, key := range s.keys {
+		if key.LastUsedAt != nil && !key.LastUsedAt.Equal(s.saved[id]) {
+			return s.save()
+		}
+	}
+
+	return nil
+}
+
+// save атомарно записывает ключи в файл: через временный файл и переименование.
+// Вызывается под блокировкой.
+func (s *Store) save() error {
+	keys := make([]*storedKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("apikey: encode keys: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("apikey: create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+"*.tmp")
+	if err != nil {
+		return fmt.Errorf("apikey: create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("apikey: write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("apikey: fsync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("apikey: close file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("apikey: rename file: %w", err)
+	}
+
+	for id, key := range s.keys {
+		if key.LastUsedAt != nil {
+			s.saved[id] = *key.LastUsedAt
+		}
+	}
+
+	return nil
+}
+
+// newSecret возвращает случайные ID ключа и секрет
+func newSecret() (string, string, error) {
+	var buf [8 + 32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", "", fmt.Errorf("apikey: read random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(buf[:8]), base64.RawURLEncoding.EncodeToString(buf[8:]), nil
+}
+
+// parseToken разбирает ключ вида wbc_<id>_<секрет>
+func parseToken(token string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+
+	return id, secret, true
+}
+
+// hashSecret возвращает SHA-256 секрета. Секрет случайный и длинный,
+// поэтому медленный хеш вроде bcrypt не нужен.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
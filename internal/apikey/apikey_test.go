@@ -0,0 +1,138 @@
+package apikey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/pkg"
+)
+
+func TestIssueAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	key, token, err := store.Issue("sync script", 1, []string{"events:write", "events:read", "events:read"})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !strings.HasPrefix(token, "wbc_"+key.ID+"_") || len(key.Scopes) != 2 {
+		t.Fatalf("unexpected key %+v, token %q", key, token)
+	}
+
+	principal, ok := store.Verify(token)
+	if !ok || principal.UserID != 1 || !principal.HasScope("events:write") {
+		t.Fatalf("expected principal of user 1, got %+v, %v", principal, ok)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "wrong secret", token: token[:len(token)-1] + "x"},
+		{name: "unknown id", token: "wbc_0000000000000000_" + token[len(token)-43:]},
+		{name: "no prefix", token: strings.TrimPrefix(token, "wbc_")},
+		{name: "empty", token: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := store.Verify(tt.token); ok {
+				t.Fatalf("expected %q to be rejected", tt.token)
+			}
+		})
+	}
+
+	// На диске только хеш секрета
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), token[len(token)-43:]) || !strings.Contains(string(data), `"hash"`) {
+		t.Fatalf("expected only the hash at rest, got %s", data)
+	}
+
+	// Ключ переживает перезапуск, отозванный ключ больше не принимается
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if _, ok := store.Verify(token); !ok {
+		t.Fatal("expected key to survive reopen")
+	}
+	if _, err := store.Revoke(key.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, ok := store.Verify(token); ok {
+		t.Fatal("expected revoked key to be rejected")
+	}
+	if _, err := store.Revoke("missing"); !errors.Is(err, pkg.ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	store, _ = Open(path)
+	if keys := store.List(1); len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Fatalf("expected revoked key after reopen, got %+v", keys)
+	}
+}
+
+func TestIssueValidation(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "api_keys.json"))
+
+	tests := []struct {
+		name   string
+		key    string
+		userID int
+		scopes []string
+	}{
+		{name: "no name", userID: 1, scopes: []string{"events:read"}},
+		{name: "no user", key: "script", scopes: []string{"events:read"}},
+		{name: "no scopes", key: "script", userID: 1},
+		{name: "unknown scope", key: "script", userID: 1, scopes: []string{"events:admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.Issue(tt.key, tt.userID, tt.scopes); !errors.Is(err, pkg.ErrValidation) {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+		})
+	}
+	if keys := store.List(0); len(keys) != 0 {
+		t.Fatalf("expected no keys, got %+v", keys)
+	}
+}
+
+func TestLastUsed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	store, _ := Open(path)
+	now := time.Date(2025, 8, 11, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	key, token, _ := store.Issue("script", 1, []string{"events:read"})
+	if key.LastUsedAt != nil {
+		t.Fatalf("expected new key to be unused, got %v", key.LastUsedAt)
+	}
+
+	store.Verify(token)
+	now = now.Add(10 * time.Second)
+	store.Verify(token)
+
+	// В памяти время последнего использования точное, на диск попадает не чаще touchInterval
+	if keys := store.List(1); !keys[0].LastUsedAt.Equal(now) {
+		t.Fatalf("expected last use at %v, got %v", now, keys[0].LastUsedAt)
+	}
+	reopened, _ := Open(path)
+	if keys := reopened.List(1); keys[0].LastUsedAt == nil || !keys[0].LastUsedAt.Equal(now.Add(-10*time.Second)) {
+		t.Fatalf("expected first use to be saved, got %v", keys[0].LastUsedAt)
+	}
+
+	// Close сохраняет и последнее использование
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reopened, _ = Open(path)
+	if keys := reopened.List(1); keys[0].LastUsedAt == nil || !keys[0].LastUsedAt.Equal(now) {
+		t.Fatalf("expected last use to be saved on close, got %v", keys[0].LastUsedAt)
+	}
+}
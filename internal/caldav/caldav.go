@@ -0,0 +1,353 @@
+// Package caldav реализует подмножество CalDAV (RFC 4791) поверх calendar.Service:
+// PROPFIND, REPORT calendar-query и calendar-multiget, GET/PUT/DELETE ресурсов .ics,
+// ETag и ctag. Этого достаточно для Apple Calendar, Thunderbird и DAVx5.
+//
+// Структура адресов относительно префикса:
+//
+//	/principals/{user_id}/               принципал пользователя
+//	/calendars/{user_id}/                домашняя коллекция календарей
+//	/calendars/{user_id}/events/         календарь событий
+//	/calendars/{user_id}/events/{uid}.ics событие вместе с измененными вхождениями
+package caldav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/ical"
+	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// CalendarName имя единственного календаря пользователя
+	CalendarName = "events"
+
+	// maxObjectSize максимальный размер ресурса .ics в запросе PUT
+	maxObjectSize = 1 << 20
+	// maxRequestSize максимальный размер тела PROPFIND и REPORT
+	maxRequestSize = 1 << 20
+)
+
+// methods поддерживаемые методы, они же значение заголовка Allow
+var methods = []string{"OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"}
+
+// Handler обрабатывает запросы CalDAV
+type Handler struct {
+	service *calendar.Service
+	prefix  string
+}
+
+// NewHandler создает обработчик CalDAV, доступный по префиксу prefix, например /caldav
+func NewHandler(service *calendar.Service, prefix string) *Handler {
+	return &Handler{service: service, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+// Register подключает обработчик к маршрутизатору вместе с /.well-known/caldav
+func (h *Handler) Register(r gin.IRoutes) {
+	for _, method := range methods {
+		r.Handle(method, h.prefix+"/*path", h.serve)
+	}
+
+	wellKnown := func(ctx *gin.Context) {
+		ctx.Redirect(http.StatusMovedPermanently, h.prefix+"/")
+	}
+	r.Handle("GET", "/.well-known/caldav", wellKnown)
+	r.Handle("PROPFIND", "/.well-known/caldav", wellKnown)
+}
+
+// kind вид ресурса CalDAV
+type kind int
+
+const (
+	kindRoot kind = iota
+	kindPrincipal
+	kindHome
+	kindCalendar
+	kindObject
+)
+
+// target ресурс, на который указывает путь запроса
+type target struct {
+	kind   kind
+	userID int
+	// name имя ресурса события без расширения .ics
+	name string
+}
+
+// parsePath разбирает путь относительно префикса
+func parsePath(path string) (target, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		return target{kind: kindRoot}, true
+	}
+	if len(parts) < 2 {
+		return target{}, false
+	}
+
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil || userID <= 0 {
+		return target{}, false
+	}
+
+	switch {
+	case parts[0] == "principals" && len(parts) == 2:
+		return target{kind: kindPrincipal, userID: userID}, true
+	case parts[0] != "calendars":
+		return target{}, false
+	case len(parts) == 2:
+		return target{kind: kindHome, userID: userID}, true
+	case parts[2] != CalendarName:
+		return target{}, false
+	case len(parts) == 3:
+		return target{kind: kindCalendar, userID: userID}, true
+	case len(parts) == 4 && strings.HasSuffix(parts[3], ".ics") && len(parts[3]) > len(".ics"):
+		return target{kind: kindObject, userID: userID, name: strings.TrimSuffix(parts[3], ".ics")}, true
+	}
+
+	return target{}, false
+}
+
+func (h *Handler) serve(ctx *gin.Context) {
+	ctx.Header("DAV", "1, 3, calendar-access")
+
+	t, ok := parsePath(ctx.Param("path"))
+	if !ok {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	// Клиент с токеном пользователя работает только со своим календарем
+	if principal, ok := middleware.PrincipalFrom(ctx); ok && principal.UserID != 0 && t.userID != 0 && t.userID != principal.UserID {
+		ctx.Status(http.StatusForbidden)
+		return
+	}
+
+	switch ctx.Request.Method {
+	case "OPTIONS":
+		ctx.Header("Allow", strings.Join(methods, ", "))
+		ctx.Status(http.StatusOK)
+	case "PROPFIND":
+		h.propfind(ctx, t)
+	case "REPORT":
+		h.report(ctx, t)
+	case "GET", "HEAD":
+		h.get(ctx, t)
+	case "PUT":
+		h.put(ctx, t)
+	case "DELETE":
+		h.delete(ctx, t)
+	}
+}
+
+// get отдает ресурс события или весь календарь в формате iCalendar
+func (h *Handler) get(ctx *gin.Context, t target) {
+	switch t.kind {
+	case kindCalendar:
+		events, err := h.service.ListEvents(t.userID, zeroTime, zeroTime)
+		if err != nil {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := ical.Encode(&buf, events, davStamp); err != nil {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		ctx.Data(http.StatusOK, contentType, buf.Bytes())
+	case kindObject:
+		obj, err := h.object(t.userID, t.name)
+		if err != nil {
+			h.writeLookupError(ctx, err)
+			return
+		}
+		ctx.Header("ETag", obj.etag)
+		if match := ctx.GetHeader("If-None-Match"); match != "" && etagMatches(match, obj.etag) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+		ctx.Data(http.StatusOK, contentType, obj.data)
+	default:
+		ctx.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+// put создает или заменяет событие ресурсом .ics. Ресурс должен содержать
+// одно событие: основной VEVENT и, возможно, его измененные вхождения.
+func (h *Handler) put(ctx *gin.Context, t target) {
+	if t.kind != kindObject {
+		ctx.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxObjectSize))
+	if err != nil {
+		ctx.String(http.StatusRequestEntityTooLarge, "resource is too large")
+		return
+	}
+
+	loc, err := h.service.Location(t.userID, "")
+	if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := ical.Decode(bytes.NewReader(body), loc)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	uid, err := validateObject(entries)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := h.object(t.userID, t.name)
+	if errors.Is(err, pkg.ErrEventNotFound) {
+		existing, err = h.object(t.userID, uid)
+	}
+	if err != nil && !errors.Is(err, pkg.ErrEventNotFound) {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if !checkPreconditions(ctx, existing) {
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	}
+	if existing != nil && existing.name != uid {
+		ctx.String(http.StatusForbidden, "UID of an existing resource cannot be changed")
+		return
+	}
+
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusNoContent
+		err = h.replace(t.userID, existing, entries, body)
+	} else {
+		err = h.create(t.userID, body)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, errInvalidObject):
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, pkg.ErrVersionMismatch), errors.Is(err, pkg.ErrEventNotFound):
+		// Ресурс изменился после проверки предусловий
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	default:
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if obj, err := h.object(t.userID, uid); err == nil {
+		ctx.Header("ETag", obj.etag)
+		ctx.Header("Location", h.objectHref(t.userID, obj.name))
+	}
+	ctx.Status(status)
+}
+
+// delete удаляет событие вместе с измененными вхождениями
+func (h *Handler) delete(ctx *gin.Context, t target) {
+	if t.kind != kindObject {
+		ctx.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	obj, err := h.object(t.userID, t.name)
+	if err != nil {
+		h.writeLookupError(ctx, err)
+		return
+	}
+	if !checkPreconditions(ctx, obj) {
+		ctx.Status(http.StatusPreconditionFailed)
+		return
+	}
+
+	for _, event := range obj.events {
+		if err := h.service.DeleteEvent(t.userID, event.ID, 0); err != nil && !errors.Is(err, pkg.ErrEventNotFound) {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		// Вместе с серией удалены и ее вхождения
+		if event.IsRecurring() {
+			break
+		}
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// checkPreconditions проверяет If-Match и If-None-Match для изменения ресурса
+func checkPreconditions(ctx *gin.Context, obj *object) bool {
+	if match := ctx.GetHeader("If-Match"); match != "" {
+		if obj == nil || !etagMatches(match, obj.etag) {
+			return false
+		}
+	}
+	if match := ctx.GetHeader("If-None-Match"); match != "" && obj != nil && etagMatches(match, obj.etag) {
+		return false
+	}
+
+	return true
+}
+
+// etagMatches проверяет значение If-Match или If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *Handler) writeLookupError(ctx *gin.Context, err error) {
+	if errors.Is(err, pkg.ErrEventNotFound) {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	ctx.Status(http.StatusInternalServerError)
+}
+
+func (h *Handler) principalHref(userID int) string {
+	return h.prefix + "/principals/" + strconv.Itoa(userID) + "/"
+}
+
+func (h *Handler) homeHref(userID int) string {
+	return h.prefix + "/calendars/" + strconv.Itoa(userID) + "/"
+}
+
+func (h *Handler) calendarHref(userID int) string {
+	return h.homeHref(userID) + CalendarName + "/"
+}
+
+func (h *Handler) objectHref(userID int, name string) string {
+	return h.calendarHref(userID) + url.PathEscape(name) + ".ics"
+}
+
+func (h *Handler) href(t target) string {
+	switch t.kind {
+	case kindPrincipal:
+		return h.principalHref(t.userID)
+	case kindHome:
+		return h.homeHref(t.userID)
+	case kindCalendar:
+		return h.calendarHref(t.userID)
+	case kindObject:
+		return h.objectHref(t.userID, t.name)
+	default:
+		return h.prefix + "/"
+	}
+}
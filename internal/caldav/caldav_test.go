@@ -0,0 +1,332 @@
+package caldav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/ical"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupTestRouter() (*gin.Engine, *calendar.Service) {
+	gin.SetMode(gin.TestMode)
+	service := calendar.NewService(calendar.NewCalendar())
+	router := gin.New()
+	NewHandler(service, "/caldav").Register(router)
+
+	return router, service
+}
+
+func do(router *gin.Engine, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+const standup = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:standup@example.com\r\nDTSTAMP:20250101T000000Z\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20250811T100000\r\nDTEND;TZID=Europe/Berlin:20250811T101500\r\n" +
+	"RRULE:FREQ=DAILY;COUNT=5\r\nSUMMARY:Standup\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:standup@example.com\r\nDTSTAMP:20250101T000000Z\r\n" +
+	"RECURRENCE-ID;TZID=Europe/Berlin:20250813T100000\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20250813T110000\r\nDTEND;TZID=Europe/Berlin:20250813T111500\r\n" +
+	"SUMMARY:Late standup\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+var ctagPattern = regexp.MustCompile(`<cs:getctag>([0-9a-f]+)</cs:getctag>`)
+
+func TestPropfindDiscovery(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	w := do(router, "PROPFIND", "/.well-known/caldav", "", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/caldav/" {
+		t.Fatalf("well-known: %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	body := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+		`<d:prop><d:current-user-principal/><c:calendar-home-set/><d:quota-used-bytes/></d:prop></d:propfind>`
+	w = do(router, "PROPFIND", "/caldav/principals/1/", body, map[string]string{"Depth": "0"})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("principal: status %d", w.Code)
+	}
+	for _, want := range []string{
+		"<d:current-user-principal><d:href>/caldav/principals/1/</d:href></d:current-user-principal>",
+		"<c:calendar-home-set><d:href>/caldav/calendars/1/</d:href></c:calendar-home-set>",
+		"<d:quota-used-bytes/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("principal response lacks %s:\n%s", want, w.Body.String())
+		}
+	}
+
+	w = do(router, "PROPFIND", "/caldav/calendars/1/", "", map[string]string{"Depth": "1"})
+	if !strings.Contains(w.Body.String(), "<d:href>/caldav/calendars/1/events/</d:href>") ||
+		!strings.Contains(w.Body.String(), "<c:calendar/>") {
+		t.Errorf("home listing lacks calendar:\n%s", w.Body.String())
+	}
+
+	if w := do(router, "PROPFIND", "/caldav/calendars/1/other/", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown collection: status %d", w.Code)
+	}
+}
+
+func TestObjectLifecycle(t *testing.T) {
+	router, service := setupTestRouter()
+	objectPath := "/caldav/calendars/1/events/standup@example.com.ics"
+
+	ctagOf := func() string {
+		w := do(router, "PROPFIND", "/caldav/calendars/1/events/", "", map[string]string{"Depth": "0"})
+		match := ctagPattern.FindStringSubmatch(w.Body.String())
+		if match == nil {
+			t.Fatalf("no ctag in:\n%s", w.Body.String())
+		}
+		return match[1]
+	}
+	emptyCtag := ctagOf()
+
+	w := do(router, "PUT", objectPath, standup, map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("create: no ETag")
+	}
+	if ctagOf() == emptyCtag {
+		t.Error("ctag did not change after create")
+	}
+
+	if w := do(router, "PUT", objectPath, standup, map[string]string{"If-None-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("create over existing: status %d", w.Code)
+	}
+
+	series, err := service.GetEventByUID(1, "standup@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := service.GetEventsInRange(1, time.Date(2025, 8, 13, 0, 0, 0, 0, time.UTC), time.Date(2025, 8, 14, 0, 0, 0, 0, time.UTC))
+	if len(events) != 1 || events[0].Title != "Late standup" {
+		t.Fatalf("override not applied: %+v", events)
+	}
+
+	w = do(router, "GET", objectPath, "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != etag {
+		t.Fatalf("get: status %d etag %q, want %q", w.Code, w.Header().Get("ETag"), etag)
+	}
+	if !strings.Contains(w.Body.String(), "RECURRENCE-ID;TZID=Europe/Berlin:20250813T100000") {
+		t.Errorf("get lacks override:\n%s", w.Body.String())
+	}
+	if w := do(router, "GET", objectPath, "", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("conditional get: status %d", w.Code)
+	}
+
+	renamed := strings.Replace(standup, "SUMMARY:Standup", "SUMMARY:Daily", 1)
+	if w := do(router, "PUT", objectPath, renamed, map[string]string{"If-Match": `"stale"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale update: status %d", w.Code)
+	}
+	w = do(router, "PUT", objectPath, renamed, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusNoContent || w.Header().Get("ETag") == etag {
+		t.Fatalf("update: status %d etag %q", w.Code, w.Header().Get("ETag"))
+	}
+	updated, err := service.GetEventByUID(1, "standup@example.com")
+	if err != nil || updated.ID != series.ID || updated.Title != "Daily" || len(updated.ExDates) != 1 {
+		t.Fatalf("update replaced series: %+v, %v", updated, err)
+	}
+	all, _ := service.ListEvents(1, time.Time{}, time.Time{})
+	if len(all) != 2 {
+		t.Errorf("update left %d stored events, want series and one override", len(all))
+	}
+
+	other := strings.Replace(standup, "standup@example.com", "other@example.com", -1)
+	if w := do(router, "PUT", objectPath, other, nil); w.Code != http.StatusForbidden {
+		t.Errorf("uid change: status %d", w.Code)
+	}
+
+	if w := do(router, "DELETE", objectPath, "", map[string]string{"If-Match": etag}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale delete: status %d", w.Code)
+	}
+	if w := do(router, "DELETE", objectPath, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", w.Code)
+	}
+	if all, _ := service.ListEvents(1, time.Time{}, time.Time{}); len(all) != 0 {
+		t.Errorf("delete left %d events", len(all))
+	}
+	if w := do(router, "GET", objectPath, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted: status %d", w.Code)
+	}
+	if ctagOf() != emptyCtag {
+		t.Error("ctag of an empty calendar changed")
+	}
+}
+
+func TestObjectWithoutUID(t *testing.T) {
+	router, service := setupTestRouter()
+	start := time.Date(2025, 8, 11, 10, 0, 0, 0, time.UTC)
+	event, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Local"})
+	service.CreateEvent(calendar.Event{UserID: 2, Start: start, End: start.Add(time.Hour), Title: "Foreign", UID: "foreign@example.com"})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"by id", "/caldav/calendars/1/events/" + string(event.ID) + "@" + ical.UIDDomain + ".ics", http.StatusOK},
+		{"unknown id", "/caldav/calendars/1/events/" + string(calendar.NewEventID()) + "@" + ical.UIDDomain + ".ics", http.StatusNotFound},
+		{"bare id", "/caldav/calendars/1/events/" + string(event.ID) + ".ics", http.StatusNotFound},
+		{"other user", "/caldav/calendars/1/events/foreign@example.com.ics", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "GET", tt.path, "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), "SUMMARY:Local") {
+				t.Errorf("unexpected body:\n%s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReplaceIsAtomic(t *testing.T) {
+	router, service := setupTestRouter()
+	objectPath := "/caldav/calendars/1/events/standup@example.com.ics"
+
+	w := do(router, "PUT", objectPath, standup, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+
+	// Второе вхождение не существует: ни переименование, ни удаление старого вхождения не применяются
+	broken := strings.Replace(standup, "SUMMARY:Standup", "SUMMARY:Daily", 1)
+	broken = strings.Replace(broken, "END:VCALENDAR\r\n", "BEGIN:VEVENT\r\nUID:standup@example.com\r\n"+
+		"RECURRENCE-ID;TZID=Europe/Berlin:20250813T103000\r\n"+
+		"DTSTART;TZID=Europe/Berlin:20250813T120000\r\nDTEND;TZID=Europe/Berlin:20250813T121500\r\n"+
+		"SUMMARY:Missing\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", 1)
+	if w := do(router, "PUT", objectPath, broken, map[string]string{"If-Match": etag}); w.Code != http.StatusBadRequest {
+		t.Fatalf("broken update: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(router, "GET", objectPath, "", nil); w.Header().Get("ETag") != etag {
+		t.Fatalf("broken update changed the resource:\n%s", w.Body.String())
+	}
+	series, _ := service.GetEventByUID(1, "standup@example.com")
+	if series.Title != "Standup" {
+		t.Fatalf("broken update renamed the series: %+v", series)
+	}
+}
+
+func TestPutInvalidObject(t *testing.T) {
+	router, _ := setupTestRouter()
+	objectPath := "/caldav/calendars/1/events/x.ics"
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not a calendar", "hello"},
+		{"no uid", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20250811T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"two events", "BEGIN:VCALENDAR\r\n" +
+			"BEGIN:VEVENT\r\nUID:a\r\nDTSTART:20250811T100000Z\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:b\r\nDTSTART:20250811T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"bad rrule", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:20250811T100000Z\r\nRRULE:FREQ=HOURLY\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(router, "PUT", objectPath, tt.body, nil); w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReports(t *testing.T) {
+	router, service := setupTestRouter()
+	base := "/caldav/calendars/1/events/"
+
+	if w := do(router, "PUT", base+"standup@example.com.ics", standup, nil); w.Code != http.StatusCreated {
+		t.Fatalf("put: status %d: %s", w.Code, w.Body.String())
+	}
+	// Событие без UID, созданное через HTTP API, тоже доступно как ресурс
+	created, err := service.CreateEvent(calendar.Event{
+		UserID: 1,
+		Start:  time.Date(2025, 9, 1, 9, 0, 0, 0, time.UTC),
+		End:    time.Date(2025, 9, 1, 10, 0, 0, 0, time.UTC),
+		Title:  "Review",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviewHref := base + ical.UID(created, nil) + ".ics"
+
+	w := do(router, "PROPFIND", base, "", map[string]string{"Depth": "1"})
+	for _, href := range []string{base + "standup@example.com.ics", reviewHref} {
+		if !strings.Contains(w.Body.String(), "<d:href>"+href+"</d:href>") {
+			t.Errorf("listing lacks %s:\n%s", href, w.Body.String())
+		}
+	}
+
+	multiget := `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+		`<d:prop><d:getetag/><c:calendar-data/></d:prop>` +
+		`<d:href>` + reviewHref + `</d:href><d:href>` + base + `missing.ics</d:href></c:calendar-multiget>`
+	w = do(router, "REPORT", base, multiget, map[string]string{"Depth": "1"})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("multiget: status %d", w.Code)
+	}
+	for _, want := range []string{"SUMMARY:Review", "<d:getetag>&#34;", base + "missing.ics</d:href><d:status>HTTP/1.1 404 Not Found"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("multiget lacks %s:\n%s", want, w.Body.String())
+		}
+	}
+
+	query := func(start, end string) string {
+		return `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` +
+			`<d:prop><d:getetag/></d:prop><c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">` +
+			`<c:time-range start="` + start + `" end="` + end + `"/></c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		want       []string
+		unwanted   []string
+	}{
+		{"series occurrence", "20250814T000000Z", "20250815T000000Z", []string{"standup@example.com.ics"}, []string{reviewHref}},
+		{"single event", "20250901T000000Z", "20250902T000000Z", []string{reviewHref}, []string{"standup@example.com.ics"}},
+		{"after series", "20250820T000000Z", "20250825T000000Z", nil, []string{"standup@example.com.ics", reviewHref}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(router, "REPORT", base, query(tt.start, tt.end), map[string]string{"Depth": "1"})
+			if w.Code != http.StatusMultiStatus {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			for _, href := range tt.want {
+				if !strings.Contains(w.Body.String(), href) {
+					t.Errorf("missing %s", href)
+				}
+			}
+			for _, href := range tt.unwanted {
+				if strings.Contains(w.Body.String(), href) {
+					t.Errorf("unexpected %s", href)
+				}
+			}
+		})
+	}
+
+	if w := do(router, "REPORT", base, query("bad", ""), nil); w.Code != http.StatusBadRequest {
+		t.Errorf("bad time-range: status %d", w.Code)
+	}
+}
@@ -0,0 +1,257 @@
+package caldav
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/ical"
+	"wb-calendar/pkg"
+)
+
+const contentType = "text/calendar; charset=utf-8"
+
+// davStamp значение DTSTAMP ресурсов. У событий нет времени изменения,
+// а тело ресурса должно быть побайтно стабильным, чтобы ETag оставался строгим.
+var davStamp = time.Unix(0, 0).UTC()
+
+var zeroTime time.Time
+
+var errInvalidObject = errors.New("invalid calendar object")
+
+// object ресурс .ics: событие вместе с его измененными вхождениями
+type object struct {
+	name string
+	// events основное событие первым, затем измененные вхождения
+	events []calendar.Event
+	data   []byte
+	etag   string
+}
+
+// master возвращает основное событие ресурса, если оно есть
+func (o *object) master() *calendar.Event {
+	if len(o.events) > 0 && !o.events[0].IsOverride() {
+		return &o.events[0]
+	}
+
+	return nil
+}
+
+// groupObjects собирает события в ресурсы: измененные вхождения попадают
+// в ресурс своей серии. Порядок ресурсов соответствует порядку событий.
+func groupObjects(events []calendar.Event) []*object {
+	var objects []*object
+	byID := make(map[calendar.EventID]*object)
+
+	for _, event := range events {
+		if !event.IsOverride() {
+			obj := &object{events: []calendar.Event{event}}
+			byID[event.ID] = obj
+			objects = append(objects, obj)
+		}
+	}
+	for _, event := range events {
+		if !event.IsOverride() {
+			continue
+		}
+		obj, ok := byID[event.SeriesID]
+		if !ok {
+			// Серия вне выборки: вхождение образует отдельный ресурс
+			obj = &object{}
+			byID[event.SeriesID] = obj
+			objects = append(objects, obj)
+		}
+		obj.events = append(obj.events, event)
+	}
+
+	for _, obj := range objects {
+		obj.name = ical.UID(obj.events[0], nil)
+	}
+
+	return objects
+}
+
+// render формирует тело ресурса и его ETag
+func (o *object) render() error {
+	var buf bytes.Buffer
+	if err := ical.Encode(&buf, o.events, davStamp); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	o.data = buf.Bytes()
+	o.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	return nil
+}
+
+// objects возвращает все ресурсы календаря пользователя
+func (h *Handler) objects(userID int) ([]*object, error) {
+	events, err := h.service.ListEvents(userID, zeroTime, zeroTime)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := groupObjects(events)
+	for _, obj := range objects {
+		if err := obj.render(); err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+// object возвращает ресурс пользователя по имени. Имя ресурса — UID основного
+// события, а для события без UID — его ID с доменом ical.UIDDomain, поэтому
+// ресурс находится по индексу UID и отрисовывается только он.
+func (h *Handler) object(userID int, name string) (*object, error) {
+	var events []calendar.Event
+	master, err := h.service.GetEventByUID(userID, name)
+	switch {
+	case err == nil:
+		events = append(events, master)
+	case errors.Is(err, pkg.ErrEventNotFound):
+		id, ok := strings.CutSuffix(name, "@"+ical.UIDDomain)
+		if !ok {
+			return nil, pkg.ErrEventNotFound
+		}
+		if master.ID, err = calendar.ParseEventID(id); err != nil {
+			return nil, pkg.ErrEventNotFound
+		}
+		// Серии может уже не быть, тогда ресурс образуют ее вхождения
+		event, err := h.service.GetUserEvent(userID, master.ID)
+		if err == nil {
+			events = append(events, event)
+		} else if !errors.Is(err, pkg.ErrEventNotFound) {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	overrides, err := h.service.GetOverrides(userID, master.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range overrides {
+		if event.IsOverride() {
+			events = append(events, event)
+		}
+	}
+
+	// Имя должно совпадать с тем, под которым ресурс виден в списке
+	if len(events) == 0 || ical.UID(events[0], nil) != name {
+		return nil, pkg.ErrEventNotFound
+	}
+
+	obj := &object{name: name, events: events}
+	if err := obj.render(); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// ctag меняется при любом изменении ресурсов календаря
+func ctag(objects []*object) string {
+	tags := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		tags = append(tags, obj.name+"\x00"+obj.etag)
+	}
+	sort.Strings(tags)
+
+	hash := sha256.New()
+	for _, tag := range tags {
+		hash.Write([]byte(tag))
+		hash.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// validateObject проверяет, что ресурс содержит ровно одно событие,
+// и возвращает его UID
+func validateObject(entries []ical.Entry) (string, error) {
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%w: no VEVENT", errInvalidObject)
+	}
+
+	uid := entries[0].Event.UID
+	masters := 0
+	for _, entry := range entries {
+		if entry.Err != nil {
+			return "", fmt.Errorf("%w: line %d: %v", errInvalidObject, entry.Line, entry.Err)
+		}
+		if entry.Event.UID == "" {
+			return "", fmt.Errorf("%w: line %d: UID is required", errInvalidObject, entry.Line)
+		}
+		if entry.Event.UID != uid {
+			return "", fmt.Errorf("%w: all VEVENTs must share one UID", errInvalidObject)
+		}
+		if entry.RecurrenceID == nil {
+			masters++
+		}
+	}
+	if masters != 1 {
+		return "", fmt.Errorf("%w: exactly one VEVENT without RECURRENCE-ID is required", errInvalidObject)
+	}
+
+	return uid, nil
+}
+
+// create создает событие из проверенного ресурса
+func (h *Handler) create(userID int, body []byte) error {
+	report, err := ical.Import(h.service, userID, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidObject, err)
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%w: line %d: %s", errInvalidObject, report.Errors[0].Line, report.Errors[0].Error)
+	}
+
+	return nil
+}
+
+// replace одним изменением заменяет содержимое существующего ресурса, сохраняя
+// ID серии. Хранилище сверяет версии событий, по которым проверялись
+// предусловия запроса, поэтому конкурирующее изменение не затирается.
+func (h *Handler) replace(userID int, existing *object, entries []ical.Entry, body []byte) error {
+	master := existing.master()
+	if master == nil {
+		// Остались только вхождения удаленной серии: ресурс создается заново
+		ops := make([]calendar.BatchOp, 0, len(existing.events))
+		for _, event := range existing.events {
+			ops = append(ops, calendar.BatchOp{Type: calendar.BatchDelete, Event: event})
+		}
+		if _, err := h.service.ApplyBatch(ops, calendar.BatchAtomic); err != nil {
+			return err
+		}
+		return h.create(userID, body)
+	}
+
+	var updated calendar.Event
+	var overrides []calendar.Event
+	for _, entry := range entries {
+		if entry.RecurrenceID == nil {
+			updated = entry.Event
+			continue
+		}
+		override := entry.Event
+		override.OccurrenceStart = entry.RecurrenceID
+		overrides = append(overrides, override)
+	}
+
+	updated.ID, updated.UserID, updated.Version = master.ID, userID, master.Version
+	err := h.service.ReplaceSeries(updated, overrides)
+	if err != nil && !errors.Is(err, pkg.ErrVersionMismatch) && !errors.Is(err, pkg.ErrEventNotFound) {
+		return fmt.Errorf("%w: %v", errInvalidObject, err)
+	}
+
+	return err
+}
@@ -0,0 +1,456 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Пространства имен XML
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// prefixes префиксы пространств имен в ответах
+var prefixes = map[string]string{nsDAV: "d", nsCalDAV: "c", nsCS: "cs"}
+
+var (
+	propResourceType     = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName      = xml.Name{Space: nsDAV, Local: "displayname"}
+	propCurrentPrincipal = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL     = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propOwner            = xml.Name{Space: nsDAV, Local: "owner"}
+	propReportSet        = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propETag             = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType      = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propContentLength    = xml.Name{Space: nsDAV, Local: "getcontentlength"}
+	propHomeSet          = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propComponentSet     = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData     = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	propCTag             = xml.Name{Space: nsCS, Local: "getctag"}
+)
+
+// allProps свойства, возвращаемые на allprop. calendar-data отдается
+// только по явному запросу.
+var allProps = []xml.Name{
+	propResourceType, propDisplayName, propCurrentPrincipal, propPrincipalURL, propOwner,
+	propReportSet, propETag, propContentType, propContentLength, propHomeSet, propComponentSet, propCTag,
+}
+
+// propList список запрошенных свойств
+type propList struct {
+	Props []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (p *propList) names() []xml.Name {
+	names := make([]xml.Name, 0, len(p.Props))
+	for _, prop := range p.Props {
+		names = append(names, prop.XMLName)
+	}
+
+	return names
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *propList `xml:"DAV: prop"`
+}
+
+// reportRequest тело calendar-query или calendar-multiget
+type reportRequest struct {
+	XMLName xml.Name
+	Prop    *propList `xml:"DAV: prop"`
+	Hrefs   []string  `xml:"DAV: href"`
+	Filter  *struct {
+		CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type compFilter struct {
+	Name        string       `xml:"name,attr"`
+	TimeRange   *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	CompFilters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// resource ресурс, свойства которого попадают в ответ multistatus
+type resource struct {
+	target
+	object *object
+	// objects ресурсы календаря, нужны для ctag
+	objects []*object
+}
+
+// propfind отдает свойства ресурса и, при Depth: 1, его дочерних ресурсов
+func (h *Handler) propfind(ctx *gin.Context, t target) {
+	var req propfindRequest
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxRequestSize))
+	if err != nil {
+		ctx.Status(http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			ctx.String(http.StatusBadRequest, "invalid propfind body")
+			return
+		}
+	}
+
+	names := allProps
+	if req.Prop != nil {
+		names = req.Prop.names()
+	}
+
+	resources, err := h.resources(t, ctx.GetHeader("Depth") != "0")
+	if errors.Is(err, errNotFound) {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	ms := newMultistatus()
+	for _, res := range resources {
+		if req.PropName != nil {
+			ms.propNames(h.href(res.target), h.props(res))
+			continue
+		}
+		ms.response(h.href(res.target), h.props(res), names, req.Prop == nil)
+	}
+	ms.write(ctx)
+}
+
+var errNotFound = errors.New("resource not found")
+
+// resources возвращает ресурс t и, если children, его дочерние ресурсы
+func (h *Handler) resources(t target, children bool) ([]resource, error) {
+	res := resource{target: t}
+	switch t.kind {
+	case kindCalendar:
+		objects, err := h.objects(t.userID)
+		if err != nil {
+			return nil, err
+		}
+		res.objects = objects
+		result := []resource{res}
+		if children {
+			for _, obj := range objects {
+				result = append(result, resource{
+					target: target{kind: kindObject, userID: t.userID, name: obj.name},
+					object: obj,
+				})
+			}
+		}
+		return result, nil
+	case kindObject:
+		obj, err := h.object(t.userID, t.name)
+		if err != nil {
+			return nil, errNotFound
+		}
+		res.object = obj
+		return []resource{res}, nil
+	case kindHome:
+		result := []resource{res}
+		if children {
+			calendarRes, err := h.resources(target{kind: kindCalendar, userID: t.userID}, false)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, calendarRes...)
+		}
+		return result, nil
+	default:
+		return []resource{res}, nil
+	}
+}
+
+// props возвращает значения свойств ресурса во внутреннем XML
+func (h *Handler) props(res resource) map[xml.Name]string {
+	props := map[xml.Name]string{}
+	principal := "<d:href>" + escape(h.principalHref(res.userID)) + "</d:href>"
+
+	switch res.kind {
+	case kindRoot:
+		props[propResourceType] = "<d:collection/>"
+	case kindPrincipal:
+		props[propResourceType] = "<d:collection/><d:principal/>"
+		props[propDisplayName] = "User " + strconv.Itoa(res.userID)
+		props[propCurrentPrincipal] = principal
+		props[propPrincipalURL] = principal
+		props[propHomeSet] = "<d:href>" + escape(h.homeHref(res.userID)) + "</d:href>"
+	case kindHome:
+		props[propResourceType] = "<d:collection/>"
+		props[propCurrentPrincipal] = principal
+		props[propOwner] = principal
+	case kindCalendar:
+		props[propResourceType] = "<d:collection/><c:calendar/>"
+		props[propDisplayName] = "Events"
+		props[propCurrentPrincipal] = principal
+		props[propOwner] = principal
+		props[propComponentSet] = `<c:comp name="VEVENT"/>`
+		props[propReportSet] = "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>"
+		props[propCTag] = ctag(res.objects)
+	case kindObject:
+		props[propResourceType] = ""
+		props[propCurrentPrincipal] = principal
+		props[propETag] = escape(res.object.etag)
+		props[propContentType] = contentType + "; component=vevent"
+		props[propContentLength] = strconv.Itoa(len(res.object.data))
+		props[propCalendarData] = escape(string(res.object.data))
+	}
+
+	return props
+}
+
+// report обрабатывает calendar-query и calendar-multiget на календаре
+func (h *Handler) report(ctx *gin.Context, t target) {
+	if t.kind != kindCalendar {
+		ctx.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req reportRequest
+	if err := xml.NewDecoder(io.LimitReader(ctx.Request.Body, maxRequestSize)).Decode(&req); err != nil {
+		ctx.String(http.StatusBadRequest, "invalid report body")
+		return
+	}
+
+	names := []xml.Name{propETag}
+	if req.Prop != nil {
+		names = req.Prop.names()
+	}
+
+	ms := newMultistatus()
+	switch req.XMLName {
+	case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+		objects, err := h.objects(t.userID)
+		if err != nil {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		for _, href := range req.Hrefs {
+			obj := h.objectByHref(objects, t.userID, href)
+			if obj == nil {
+				ms.missing(href)
+				continue
+			}
+			res := resource{target: target{kind: kindObject, userID: t.userID, name: obj.name}, object: obj}
+			ms.response(h.href(res.target), h.props(res), names, false)
+		}
+	case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+		from, to, err := queryRange(req)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		objects, err := h.queryObjects(t.userID, from, to)
+		if err != nil {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		for _, obj := range objects {
+			res := resource{target: target{kind: kindObject, userID: t.userID, name: obj.name}, object: obj}
+			ms.response(h.href(res.target), h.props(res), names, false)
+		}
+	default:
+		ctx.String(http.StatusForbidden, "unsupported report")
+		return
+	}
+
+	ms.write(ctx)
+}
+
+// objectByHref находит ресурс по ссылке из calendar-multiget
+func (h *Handler) objectByHref(objects []*object, userID int, href string) *object {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	t, ok := parsePath(strings.TrimPrefix(href, h.prefix))
+	if !ok || t.kind != kindObject || t.userID != userID {
+		return nil
+	}
+	for _, obj := range objects {
+		if obj.name == t.name {
+			return obj
+		}
+	}
+
+	return nil
+}
+
+// queryObjects возвращает ресурсы, у которых есть событие или вхождение в [from, to)
+func (h *Handler) queryObjects(userID int, from, to time.Time) ([]*object, error) {
+	objects, err := h.objects(userID)
+	if err != nil || (from.IsZero() && to.IsZero()) {
+		return objects, err
+	}
+
+	events, err := h.service.ListEvents(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	matched := make(map[string]bool)
+	for _, obj := range groupObjects(events) {
+		matched[obj.name] = true
+	}
+
+	result := objects[:0]
+	for _, obj := range objects {
+		if matched[obj.name] {
+			result = append(result, obj)
+		}
+	}
+
+	return result, nil
+}
+
+// queryRange извлекает time-range из фильтра VCALENDAR/VEVENT
+func queryRange(req reportRequest) (time.Time, time.Time, error) {
+	if req.Filter == nil {
+		return zeroTime, zeroTime, nil
+	}
+
+	var tr *timeRange
+	for _, filter := range req.Filter.CompFilter.CompFilters {
+		if filter.Name == "VEVENT" {
+			tr = filter.TimeRange
+		}
+	}
+	if tr == nil {
+		return zeroTime, zeroTime, nil
+	}
+
+	var from, to time.Time
+	var err error
+	if tr.Start != "" {
+		if from, err = time.Parse(timeRangeLayout, tr.Start); err != nil {
+			return from, to, errors.New("invalid time-range start")
+		}
+	}
+	if tr.End != "" {
+		if to, err = time.Parse(timeRangeLayout, tr.End); err != nil {
+			return from, to, errors.New("invalid time-range end")
+		}
+	}
+
+	return from, to, nil
+}
+
+const timeRangeLayout = "20060102T150405Z"
+
+// multistatus собирает ответ 207 Multi-Status
+type multistatus struct {
+	buf bytes.Buffer
+}
+
+func newMultistatus() *multistatus {
+	ms := &multistatus{}
+	ms.buf.WriteString(xml.Header)
+	ms.buf.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	return ms
+}
+
+// response добавляет ресурс с запрошенными свойствами. Неизвестные свойства
+// возвращаются со статусом 404, при allprop они пропускаются.
+func (ms *multistatus) response(href string, props map[xml.Name]string, names []xml.Name, allprop bool) {
+	var found, missing bytes.Buffer
+	for _, name := range names {
+		value, ok := props[name]
+		if !ok {
+			if !allprop {
+				writeProp(&missing, name, "")
+			}
+			continue
+		}
+		writeProp(&found, name, value)
+	}
+
+	ms.buf.WriteString("<d:response><d:href>" + escape(href) + "</d:href>")
+	if found.Len() > 0 {
+		ms.propstat(found.String(), http.StatusOK)
+	}
+	if missing.Len() > 0 {
+		ms.propstat(missing.String(), http.StatusNotFound)
+	}
+	ms.buf.WriteString("</d:response>")
+}
+
+// propNames добавляет ресурс с именами его свойств
+func (ms *multistatus) propNames(href string, props map[xml.Name]string) {
+	var names bytes.Buffer
+	for _, name := range allProps {
+		if _, ok := props[name]; ok {
+			writeProp(&names, name, "")
+		}
+	}
+
+	ms.buf.WriteString("<d:response><d:href>" + escape(href) + "</d:href>")
+	ms.propstat(names.String(), http.StatusOK)
+	ms.buf.WriteString("</d:response>")
+}
+
+// missing добавляет несуществующий ресурс
+func (ms *multistatus) missing(href string) {
+	ms.buf.WriteString("<d:response><d:href>" + escape(href) + "</d:href>")
+	ms.buf.WriteString("<d:status>" + statusLine(http.StatusNotFound) + "</d:status></d:response>")
+}
+
+func (ms *multistatus) propstat(props string, status int) {
+	ms.buf.WriteString("<d:propstat><d:prop>" + props + "</d:prop>")
+	ms.buf.WriteString("<d:status>" + statusLine(status) + "</d:status></d:propstat>")
+}
+
+func (ms *multistatus) write(ctx *gin.Context) {
+	ms.buf.WriteString("</d:multistatus>")
+	ctx.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", ms.buf.Bytes())
+}
+
+func writeProp(buf *bytes.Buffer, name xml.Name, value string) {
+	tag := name.Local
+	attr := ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		attr = ` xmlns:x="` + escape(name.Space) + `"`
+	}
+
+	if value == "" {
+		buf.WriteString("<" + tag + attr + "/>")
+		return
+	}
+	buf.WriteString("<" + tag + attr + ">" + value + "</" + tag + ">")
+}
+
+func statusLine(status int) string {
+	return "HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status)
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+
+	return buf.String()
+}
@@ -0,0 +1,316 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"wb-calendar/pkg"
+)
+
+// BatchOpType тип операции пакетного изменения
+type BatchOpType string
+
+const (
+	BatchCreate BatchOpType = "create"
+	BatchUpdate BatchOpType = "update"
+	BatchDelete BatchOpType = "delete"
+)
+
+// BatchMode режим выполнения пакета операций
+type BatchMode string
+
+const (
+	// BatchAtomic применяет все операции или ни одной
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort применяет успешные операции и сообщает об ошибках остальных
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// ParseBatchMode разбирает режим пакета. Пустое значение означает BatchAtomic.
+func ParseBatchMode(value string) (BatchMode, error) {
+	switch BatchMode(value) {
+	case "", BatchAtomic:
+		return BatchAtomic, nil
+	case BatchBestEffort:
+		return BatchBestEffort, nil
+	default:
+		return "", pkg.Invalid("mode", "mode must be one of atomic, best_effort")
+	}
+}
+
+// BatchOp операция пакета. Для create Event — новое событие, для update —
+// новые значения полей события Event.ID, для delete используются только
+// Event.ID и Event.Version. Изменять можно только события пользователя
+// Event.UserID, чужие считаются отсутствующими.
+type BatchOp struct {
+	Type  BatchOpType
+	Event Event
+}
+
+// BatchResult результат одной операции пакета: итоговое событие или ошибка.
+// Для delete Event содержит удаленное событие.
+type BatchResult struct {
+	Event Event
+	Err   error
+}
+
+// BatchError ошибка операции Index, из-за которой атомарный пакет не применен
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyBatch проверяет операции и выполняет их в хранилище по порядку.
+// В режиме BatchAtomic любая ошибка отменяет весь пакет и возвращается
+// как *BatchError с номером операции; в режиме BatchBestEffort ошибки
+// возвращаются в результатах соответствующих операций.
+func (s *Service) ApplyBatch(ops []BatchOp, mode BatchMode) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	prepared := make([]BatchOp, 0, len(ops))
+	indexes := make([]int, 0, len(ops))
+
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case BatchCreate:
+			op.Event, err = s.prepareCreate(op.Event)
+		case BatchUpdate:
+			op.Event, err = s.prepareUpdate(op.Event)
+		}
+		if err != nil {
+			if mode == BatchAtomic {
+				return nil, &BatchError{Index: i, Err: err}
+			}
+			results[i].Err = err
+			continue
+		}
+
+		prepared = append(prepared, op)
+		indexes = append(indexes, i)
+	}
+
+	stored, err := s.repo.ApplyBatch(prepared, mode == BatchAtomic)
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		return nil, &BatchError{Index: indexes[batchErr.Index], Err: batchErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range stored {
+		if result.Err == nil {
+			result.Event = inTimeZone(result.Event)
+		}
+		results[indexes[j]] = result
+	}
+
+	return results, nil
+}
+
+// ApplyBatch выполняет операции по порядку под одной блокировкой календаря,
+// каждая следующая операция видит результат предыдущих.
+//
+// В атомарном режиме изменения применяются в памяти сразу и откатываются
+// при первой ошибке, которая возвращается как *BatchError; в журнал пакет
+// попадает одной записью OpBatch только после успеха всех операций.
+// В режиме best effort каждая операция записывается отдельно,
+// а ошибки возвращаются в результатах.
+func (c *Calendar) ApplyBatch(ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	results := make([]BatchResult, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			rec, event, err := c.batchRecord(op)
+			if err == nil {
+				err = c.commit(rec)
+			}
+			results[i] = BatchResult{Event: event, Err: err}
+		}
+		return results, nil
+	}
+
+	undo := &batchUndo{events: make(map[EventID]*Event)}
+	records := make([]Record, 0, len(ops))
+	for i, op := range ops {
+		rec, event, err := c.batchRecord(op)
+		if err != nil {
+			c.rollback(undo)
+			return nil, &BatchError{Index: i, Err: err}
+		}
+
+		c.remember(undo, rec)
+		c.apply(rec)
+		records = append(records, rec)
+		results[i] = BatchResult{Event: event}
+	}
+
+	if c.journal != nil && len(records) > 0 {
+		if err := c.journal.Append(Record{Op: OpBatch, Records: records}); err != nil {
+			c.rollback(undo)
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// batchRecord готовит запись журнала для операции пакета. Вызывается под блокировкой.
+func (c *Calendar) batchRecord(op BatchOp) (Record, Event, error) {
+	switch op.Type {
+	case BatchCreate:
+		return c.createRecord(op.Event)
+	case BatchUpdate:
+		return c.updateRecord(op.Event)
+	case BatchDelete:
+		event := c.events[op.Event.ID]
+		rec, err := c.deleteRecord(op.Event.ID, op.Event.UserID, op.Event.Version)
+		return rec, event, err
+	default:
+		return Record{}, Event{}, pkg.Invalid("op", "op must be one of create, update, delete")
+	}
+}
+
+// createRecord готовит запись о создании события. Вызывается под блокировкой.
+func (c *Calendar) createRecord(event Event) (Record, Event, error) {
+	if event.UID != "" {
+		if _, exists := c.uids[uidKey{event.UserID, event.UID}]; exists {
+			return Record{}, Event{}, pkg.ErrDuplicateUID
+		}
+	}
+
+	event.ID = NewEventID()
+	event.Version = 1
+
+	return Record{Op: OpPut, Event: event}, event, nil
+}
+
+// updateRecord готовит запись об изменении времени и названия события.
+// Вызывается под блокировкой.
+func (c *Calendar) updateRecord(update Event) (Record, Event, error) {
+	event, err := c.ownedEvent(update.ID, update.UserID, update.Version)
+	if err != nil {
+		return Record{}, Event{}, err
+	}
+
+	updated, err := ApplyUpdate(event, update)
+	if err != nil {
+		return Record{}, Event{}, err
+	}
+	updated.Version++
+
+	return c.putRecord(event, updated), updated, nil
+}
+
+// putRecord готовит запись о сохранении измененного события old. Если изменилось
+// расписание серии, ее измененные вхождения удаляются той же записью.
+// Вызывается под блокировкой.
+func (c *Calendar) putRecord(old, updated Event) Record {
+	rec := Record{Op: OpPut, Event: updated}
+	if !ScheduleChanged(old, updated) {
+		return rec
+	}
+
+	overrides := c.overrideRecords(old)
+	if len(overrides) == 0 {
+		return rec
+	}
+
+	return Record{Op: OpBatch, Records: append([]Record{rec}, overrides...)}
+}
+
+// deleteRecord готовит запись об удалении события, а для серии — и ее
+// измененных вхождений. Вызывается под блокировкой.
+func (c *Calendar) deleteRecord(id EventID, userID, version int) (Record, error) {
+	event, err := c.ownedEvent(id, userID, version)
+	if err != nil {
+		return Record{}, err
+	}
+	if !event.IsRecurring() {
+		return Record{Op: OpDelete, ID: id}, nil
+	}
+
+	records := append([]Record{{Op: OpDelete, ID: id}}, c.overrideRecords(event)...)
+	return Record{Op: OpBatch, Records: records}, nil
+}
+
+// overrideRecords готовит записи об удалении измененных вхождений серии.
+// Вызывается под блокировкой.
+func (c *Calendar) overrideRecords(series Event) []Record {
+	var records []Record
+	for _, id := range c.overrideIDs(series.ID) {
+		if c.events[id].UserID == series.UserID {
+			records = append(records, Record{Op: OpDelete, ID: id})
+		}
+	}
+
+	return records
+}
+
+// ownedEvent возвращает событие id пользователя userID заданной версии.
+// Событие другого пользователя считается отсутствующим. Вызывается под блокировкой.
+func (c *Calendar) ownedEvent(id EventID, userID, version int) (Event, error) {
+	event, exists := c.events[id]
+	if !exists || event.UserID != userID {
+		return Event{}, pkg.ErrEventNotFound
+	}
+	if err := event.CheckVersion(version); err != nil {
+		return Event{}, err
+	}
+
+	return event, nil
+}
+
+// batchUndo состояние событий до начала атомарного пакета.
+// nil означает, что события не было. order хранит ID в порядке
+// первого изменения, откат идет в обратном порядке.
+type batchUndo struct {
+	events map[EventID]*Event
+	order  []EventID
+}
+
+// remember сохраняет в undo состояние событий, которые изменит rec,
+// если они еще не сохранены. Вызывается под блокировкой.
+func (c *Calendar) remember(undo *batchUndo, rec Record) {
+	id := rec.ID
+	if rec.Op == OpPut {
+		id = rec.Event.ID
+	}
+	for _, nested := range rec.Records {
+		c.remember(undo, nested)
+	}
+	if rec.Op == OpBatch {
+		return
+	}
+
+	if _, saved := undo.events[id]; !saved {
+		if event, exists := c.events[id]; exists {
+			undo.events[id] = &event
+		} else {
+			undo.events[id] = nil
+		}
+		undo.order = append(undo.order, id)
+	}
+}
+
+// rollback возвращает события к состоянию undo в порядке, обратном изменениям,
+// чтобы индекс UID вернулся к исходному событию. Вызывается под блокировкой.
+func (c *Calendar) rollback(undo *batchUndo) {
+	for i := len(undo.order) - 1; i >= 0; i-- {
+		id := undo.order[i]
+		if event := undo.events[id]; event == nil {
+			c.apply(Record{Op: OpDelete, ID: id})
+		} else {
+			c.apply(Record{Op: OpPut, Event: *event})
+		}
+	}
+}
@@ -1,116 +1,246 @@
 package calendar
 
 import (
+	"slices"
 	"sync"
 	"time"
 	"wb-calendar/pkg"
 )
 
 type Calendar struct {
-	events map[int]Event
-	nextID int
-	mutex  sync.RWMutex
+	events    map[EventID]Event
+	index     userIndex
+	uids      map[uidKey]EventID
+	overrides map[EventID]map[EventID]struct{}
+	timeZones map[int]string
+	journal   Journal
+	mutex     sync.RWMutex
 }
 
 func NewCalendar() *Calendar {
 	return &Calendar{
-		events: make(map[int]Event),
-		nextID: 1,
-		mutex:  sync.RWMutex{},
+		events:    make(map[EventID]Event),
+		index:     make(userIndex),
+		uids:      make(map[uidKey]EventID),
+		overrides: make(map[EventID]map[EventID]struct{}),
+		timeZones: make(map[int]string),
+		mutex:     sync.RWMutex{},
 	}
 }
 
-// CreateEvent создает новое событие
-func (c *Calendar) CreateEvent(userID int, date time.Time, title string) (Event, error) {
+// CreateEvent создает новое событие. ID присваивается календарем.
+func (c *Calendar) CreateEvent(event Event) (Event, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	event := Event{
-		ID:     c.nextID,
-		UserID: userID,
-		Date:   date,
-		Title:  title,
+	rec, created, err := c.createRecord(event)
+	if err != nil {
+		return Event{}, err
+	}
+	if err := c.commit(rec); err != nil {
+		return Event{}, err
 	}
 
-	c.events[event.ID] = event
-	c.nextID++
+	return created, nil
+}
 
-	return event, nil
+// UpdateEvent обновляет время и название существующего события пользователя update.UserID.
+// Если update.Version не 0, событие должно иметь эту версию.
+func (c *Calendar) UpdateEvent(update Event) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	rec, _, err := c.updateRecord(update)
+	if err != nil {
+		return err
+	}
+
+	return c.commit(rec)
 }
 
-// UpdateEvent обновляет существующее событие
-func (c *Calendar) UpdateEvent(id int, date time.Time, title string) error {
+// PatchEvent применяет патч к событию пользователя userID под блокировкой
+// календаря, поэтому одновременные изменения разных полей не затирают друг друга
+func (c *Calendar) PatchEvent(userID int, id EventID, patch EventPatch) (Event, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	event, exists := c.events[id]
-	if !exists {
-		return pkg.ErrEventNotFound
+	event, err := c.ownedEvent(id, userID, 0)
+	if err != nil {
+		return Event{}, err
+	}
+
+	patched, err := patch.Apply(event)
+	if err != nil {
+		return Event{}, err
+	}
+	patched.Version++
+
+	if err := c.commit(c.putRecord(event, patched)); err != nil {
+		return Event{}, err
+	}
+
+	return patched, nil
+}
+
+// DeleteEvent удаляет событие пользователя userID. Вместе с серией удаляются
+// ее измененные вхождения. Если version не 0, событие должно иметь эту версию.
+func (c *Calendar) DeleteEvent(userID int, id EventID, version int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	rec, err := c.deleteRecord(id, userID, version)
+	if err != nil {
+		return err
+	}
+
+	return c.commit(rec)
+}
+
+// UpdateSeries заменяет правило повторения и отмененные вхождения серии и,
+// если detached задан, в том же изменении создает отделенное от серии
+// событие: измененное вхождение или продолжение разделенной серии
+func (c *Calendar) UpdateSeries(series Event, detached *Event) (Event, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	event, err := c.ownedEvent(series.ID, series.UserID, series.Version)
+	if err != nil {
+		return Event{}, err
 	}
 
-	event.Date = date
-	event.Title = title
+	event.RRule = series.RRule
+	event.ExDates = series.ExDates
+	event.Version++
+	records := []Record{{Op: OpPut, Event: event}}
+
+	var created Event
+	if detached != nil {
+		created = *detached
+		created.ID = NewEventID()
+		created.Version = 1
+		records = append(records, Record{Op: OpPut, Event: created})
+	}
 
-	c.events[id] = event
+	if err := c.commit(Record{Op: OpBatch, Records: records}); err != nil {
+		return Event{}, err
+	}
 
-	return nil
+	return created, nil
 }
 
-// DeleteEvent удаляет событие
-func (c *Calendar) DeleteEvent(id int) error {
+// ReplaceSeries заменяет событие по правилам ApplyUpdate вместе с отмененными
+// вхождениями, удаляет его измененные вхождения и создает overrides —
+// все одной записью журнала. Если series.Version не 0, событие должно иметь эту версию.
+func (c *Calendar) ReplaceSeries(series Event, overrides []Event) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if _, exists := c.events[id]; !exists {
-		return pkg.ErrEventNotFound
+	event, err := c.ownedEvent(series.ID, series.UserID, series.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := ApplyUpdate(event, series)
+	if err != nil {
+		return err
+	}
+	updated.ExDates = series.ExDates
+	updated.Version++
+
+	records := append([]Record{{Op: OpPut, Event: updated}}, c.overrideRecords(event)...)
+	for _, override := range overrides {
+		override.ID = NewEventID()
+		override.Version = 1
+		records = append(records, Record{Op: OpPut, Event: override})
 	}
 
-	delete(c.events, id)
-	return nil
+	return c.commit(Record{Op: OpBatch, Records: records})
 }
 
-// GetEventsForDay возвращает события на день
-func (c *Calendar) GetEventsForDay(userID int, day time.Time) []Event {
+// GetEvent возвращает событие по ID
+func (c *Calendar) GetEvent(id EventID) (Event, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	var result []Event
+	event, exists := c.events[id]
+	if !exists {
+		return Event{}, pkg.ErrEventNotFound
+	}
 
-	for _, event := range c.events {
-		if event.UserID == userID && isSameDay(event.Date, day) {
-			result = append(result, event)
-		}
+	return event, nil
+}
+
+// GetEventByUID возвращает событие пользователя по UID
+func (c *Calendar) GetEventByUID(userID int, uid string) (Event, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	id, exists := c.uids[uidKey{userID, uid}]
+	if !exists || uid == "" {
+		return Event{}, pkg.ErrEventNotFound
 	}
 
-	return result
+	return c.events[id], nil
 }
 
-// GetEventsForWeek возвращает события на неделю
-func (c *Calendar) GetEventsForWeek(userID int, day time.Time) []Event {
+// GetOverrides возвращает измененные вхождения серии seriesID пользователя userID
+func (c *Calendar) GetOverrides(userID int, seriesID EventID) ([]Event, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
 	var result []Event
-	year, week := day.ISOWeek()
-
-	for _, event := range c.events {
-		y, w := event.Date.ISOWeek()
-		if event.UserID == userID && y == year && w == week {
+	for _, id := range c.overrideIDs(seriesID) {
+		if event := c.events[id]; event.UserID == userID {
 			result = append(result, event)
 		}
 	}
 
-	return result
+	return result, nil
 }
 
-// GetEventsForMonth возвращает события на месяц
-func (c *Calendar) GetEventsForMonth(userID int, day time.Time) []Event {
+// GetEventsInRange возвращает события пользователя, пересекающиеся с полуинтервалом
+// [from, to), упорядоченные по началу, и повторяющиеся серии, начавшиеся до to
+func (c *Calendar) GetEventsInRange(userID int, from, to time.Time) ([]Event, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
+	return c.listEvents(userID, from, to), nil
+}
+
+// GetTimeZone возвращает часовой пояс пользователя по умолчанию
+func (c *Calendar) GetTimeZone(userID int) (string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.timeZones[userID], nil
+}
+
+// SetTimeZone задает часовой пояс пользователя по умолчанию
+func (c *Calendar) SetTimeZone(userID int, timeZone string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.commit(Record{Op: OpSetTimeZone, UserID: userID, TimeZone: timeZone})
+}
+
+// overrideIDs возвращает упорядоченные ID измененных вхождений серии.
+// Вызывается под блокировкой.
+func (c *Calendar) overrideIDs(seriesID EventID) []EventID {
+	ids := make([]EventID, 0, len(c.overrides[seriesID]))
+	for id := range c.overrides[seriesID] {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, EventID.Compare)
+
+	return ids
+}
+
+// listEvents выбирает из индекса события, пересекающиеся с [from, to),
+// и серии, начавшиеся до to. Вызывается под блокировкой.
+func (c *Calendar) listEvents(userID int, from, to time.Time) []Event {
 	var result []Event
-	for _, event := range c.events {
-		if event.UserID == userID && event.Date.Year() == day.Year() && event.Date.Month() == day.Month() {
+	for _, id := range c.index.candidateIDs(userID, from, to) {
+		if event := c.events[id]; event.IsRecurring() || event.Overlaps(from, to) {
 			result = append(result, event)
 		}
 	}
@@ -118,7 +248,8 @@ func (c *Calendar) GetEventsForMonth(userID int, day time.Time) []Event {
 	return result
 }
 
-// isSameDay проверяет, что две даты относятся к одному дню
-func isSameDay(t1, t2 time.Time) bool {
-	return t1.Year() == t2.Year() && t1.YearDay() == t2.YearDay()
+// uidKey ключ поиска события по UID
+type uidKey struct {
+	userID int
+	uid    string
 }
@@ -1,10 +1,20 @@
 package calendar
 
 import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
 	"testing"
 	"time"
+	"wb-calendar/pkg"
 )
 
+// newEvent создает событие без длительности
+func newEvent(userID int, start time.Time, title string) Event {
+	return Event{UserID: userID, Start: start, End: start, Title: title}
+}
+
 func TestNewCalendar(t *testing.T) {
 	cal := NewCalendar()
 	if cal == nil {
@@ -13,9 +23,6 @@ func TestNewCalendar(t *testing.T) {
 	if cal.events == nil {
 		t.Fatal("events map is nil")
 	}
-	if cal.nextID != 1 {
-		t.Fatalf("expected nextID to be 1, got %d", cal.nextID)
-	}
 }
 
 func TestCreateEvent(t *testing.T) {
@@ -24,19 +31,19 @@ func TestCreateEvent(t *testing.T) {
 	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
 	title := "Christmas"
 
-	event, err := cal.CreateEvent(userID, date, title)
+	event, err := cal.CreateEvent(newEvent(userID, date, title))
 	if err != nil {
 		t.Fatalf("CreateEvent failed: %v", err)
 	}
 
-	if event.ID != 1 {
-		t.Fatalf("expected event ID to be 1, got %d", event.ID)
+	if !isULID(string(event.ID)) {
+		t.Fatalf("expected ULID event ID, got %q", event.ID)
 	}
 	if event.UserID != userID {
 		t.Fatalf("expected user ID to be %d, got %d", userID, event.UserID)
 	}
-	if !event.Date.Equal(date) {
-		t.Fatalf("expected date to be %v, got %v", date, event.Date)
+	if !event.Start.Equal(date) {
+		t.Fatalf("expected date to be %v, got %v", date, event.Start)
 	}
 	if event.Title != title {
 		t.Fatalf("expected title to be %s, got %s", title, event.Title)
@@ -46,11 +53,6 @@ func TestCreateEvent(t *testing.T) {
 	if len(cal.events) != 1 {
 		t.Fatalf("expected 1 event in calendar, got %d", len(cal.events))
 	}
-
-	// Проверяем, что nextID увеличился
-	if cal.nextID != 2 {
-		t.Fatalf("expected nextID to be 2, got %d", cal.nextID)
-	}
 }
 
 func TestUpdateEvent(t *testing.T) {
@@ -60,20 +62,20 @@ func TestUpdateEvent(t *testing.T) {
 	title := "Christmas"
 
 	// Создаем событие
-	event, _ := cal.CreateEvent(userID, date, title)
+	event, _ := cal.CreateEvent(newEvent(userID, date, title))
 
 	// Обновляем событие
 	newDate := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)
 	newTitle := "Boxing Day"
-	err := cal.UpdateEvent(event.ID, newDate, newTitle)
+	err := cal.UpdateEvent(Event{ID: event.ID, UserID: 1, Start: newDate, End: newDate, Title: newTitle})
 	if err != nil {
 		t.Fatalf("UpdateEvent failed: %v", err)
 	}
 
 	// Проверяем, что событие обновлено
 	updatedEvent := cal.events[event.ID]
-	if !updatedEvent.Date.Equal(newDate) {
-		t.Fatalf("expected updated date to be %v, got %v", newDate, updatedEvent.Date)
+	if !updatedEvent.Start.Equal(newDate) {
+		t.Fatalf("expected updated date to be %v, got %v", newDate, updatedEvent.Start)
 	}
 	if updatedEvent.Title != newTitle {
 		t.Fatalf("expected updated title to be %s, got %s", newTitle, updatedEvent.Title)
@@ -86,12 +88,294 @@ func TestUpdateEventNotFound(t *testing.T) {
 	title := "Christmas"
 
 	// Пытаемся обновить несуществующее событие
-	err := cal.UpdateEvent(999, date, title)
+	err := cal.UpdateEvent(Event{ID: "999", UserID: 1, Start: date, End: date, Title: title})
 	if err == nil {
 		t.Fatal("expected error when updating non-existent event")
 	}
 }
 
+func TestPatchEvent(t *testing.T) {
+	cal := NewCalendar()
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	event, _ := cal.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Christmas", RRule: "FREQ=YEARLY"})
+
+	title := "Christmas dinner"
+	patched, err := cal.PatchEvent(1, event.ID, EventPatch{Title: &title})
+	if err != nil {
+		t.Fatalf("PatchEvent failed: %v", err)
+	}
+	if patched.Title != title || !patched.Start.Equal(start) || patched.Duration() != time.Hour || patched.RRule != "FREQ=YEARLY" {
+		t.Fatalf("patch changed more than title: %+v", patched)
+	}
+
+	// Сдвиг начала сохраняет длительность, пустое правило отменяет повторение
+	newStart := start.Add(2 * time.Hour)
+	noRule := ""
+	patched, err = cal.PatchEvent(1, event.ID, EventPatch{Start: &newStart, RRule: &noRule})
+	if err != nil {
+		t.Fatalf("PatchEvent failed: %v", err)
+	}
+	if !patched.End.Equal(newStart.Add(time.Hour)) || patched.RRule != "" || patched.Title != title {
+		t.Fatalf("unexpected event after patch: %+v", patched)
+	}
+
+	before := start
+	if _, err := cal.PatchEvent(1, event.ID, EventPatch{End: &before}); !errors.Is(err, pkg.ErrEndBeforeStart) {
+		t.Fatalf("expected ErrEndBeforeStart, got %v", err)
+	}
+	if _, err := cal.PatchEvent(1, "999", EventPatch{Title: &title}); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+	if got := cal.events[event.ID]; !got.Start.Equal(newStart) {
+		t.Fatalf("rejected patch was stored: %+v", got)
+	}
+}
+
+func TestPatchEventConcurrentFields(t *testing.T) {
+	cal := NewCalendar()
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	event, _ := cal.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Christmas"})
+
+	// Патчи разных полей не должны затирать друг друга
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			title := "Boxing Day"
+			cal.PatchEvent(1, event.ID, EventPatch{Title: &title})
+		}()
+		go func() {
+			defer wg.Done()
+			zone := "Europe/Moscow"
+			cal.PatchEvent(1, event.ID, EventPatch{TimeZone: &zone})
+		}()
+	}
+	wg.Wait()
+
+	got := cal.events[event.ID]
+	if got.Title != "Boxing Day" || got.TimeZone != "Europe/Moscow" {
+		t.Fatalf("lost update: %+v", got)
+	}
+}
+
+func TestEventVersions(t *testing.T) {
+	cal := NewCalendar()
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	event, _ := cal.CreateEvent(newEvent(1, start, "Christmas"))
+	if event.Version != 1 {
+		t.Fatalf("expected version 1, got %d", event.Version)
+	}
+
+	if err := cal.UpdateEvent(Event{ID: event.ID, UserID: 1, Start: start, End: start, Title: "Boxing Day", Version: 1}); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+	if got := cal.events[event.ID]; got.Version != 2 || got.Title != "Boxing Day" {
+		t.Fatalf("expected version 2 after update, got %+v", got)
+	}
+
+	// Изменения с устаревшей версией отклоняются и ничего не меняют
+	title := "Stale"
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"update", func() error {
+			return cal.UpdateEvent(Event{ID: event.ID, UserID: 1, Start: start, End: start, Title: title, Version: 1})
+		}},
+		{"patch", func() error {
+			_, err := cal.PatchEvent(1, event.ID, EventPatch{Title: &title, Version: 1})
+			return err
+		}},
+		{"update series", func() error {
+			_, err := cal.UpdateSeries(Event{ID: event.ID, UserID: 1, RRule: "FREQ=DAILY", Version: 1}, nil)
+			return err
+		}},
+		{"delete", func() error { return cal.DeleteEvent(1, event.ID, 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, pkg.ErrVersionMismatch) {
+				t.Fatalf("expected ErrVersionMismatch, got %v", err)
+			}
+		})
+	}
+	if got := cal.events[event.ID]; got.Version != 2 || got.Title != "Boxing Day" || got.RRule != "" {
+		t.Fatalf("stale change was applied: %+v", got)
+	}
+
+	patched, err := cal.PatchEvent(1, event.ID, EventPatch{Title: &title, Version: 2})
+	if err != nil || patched.Version != 3 {
+		t.Fatalf("expected version 3 after patch, got %+v, %v", patched, err)
+	}
+	if err := cal.DeleteEvent(1, event.ID, 3); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+}
+
+func TestMutationsCheckOwner(t *testing.T) {
+	cal := NewCalendar()
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+	event, _ := cal.CreateEvent(newEvent(1, start, "Christmas"))
+
+	// Событие другого пользователя для изменений не существует, даже с верной версией
+	title := "Mine"
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"update", func() error {
+			return cal.UpdateEvent(Event{ID: event.ID, UserID: 2, Start: start, End: start, Title: title, Version: 1})
+		}},
+		{"patch", func() error {
+			_, err := cal.PatchEvent(2, event.ID, EventPatch{Title: &title, Version: 1})
+			return err
+		}},
+		{"update series", func() error {
+			_, err := cal.UpdateSeries(Event{ID: event.ID, UserID: 2, RRule: "FREQ=DAILY"}, nil)
+			return err
+		}},
+		{"delete", func() error { return cal.DeleteEvent(2, event.ID, 1) }},
+		{"delete without user", func() error { return cal.DeleteEvent(0, event.ID, 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, pkg.ErrEventNotFound) {
+				t.Fatalf("expected ErrEventNotFound, got %v", err)
+			}
+		})
+	}
+	if got := cal.events[event.ID]; got.Version != 1 || got.Title != "Christmas" || got.RRule != "" {
+		t.Fatalf("change of another user was applied: %+v", got)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		atomic        bool
+		ops           func(existing Event) []BatchOp
+		expectedError error
+		expectedIndex int
+		expectedCount int
+		expectedTitle string
+	}{
+		{
+			name:   "atomic",
+			atomic: true,
+			ops: func(existing Event) []BatchOp {
+				return []BatchOp{
+					{Type: BatchCreate, Event: newEvent(1, start, "First")},
+					{Type: BatchUpdate, Event: Event{ID: existing.ID, UserID: 1, Start: start, End: start, Title: "Updated"}},
+				}
+			},
+			expectedCount: 2,
+			expectedTitle: "Updated",
+		},
+		{
+			name:   "atomic rolls back",
+			atomic: true,
+			ops: func(existing Event) []BatchOp {
+				return []BatchOp{
+					{Type: BatchCreate, Event: newEvent(1, start, "First")},
+					{Type: BatchUpdate, Event: Event{ID: existing.ID, UserID: 1, Start: start, End: start, Title: "Updated"}},
+					{Type: BatchDelete, Event: Event{ID: existing.ID, UserID: 1}},
+					{Type: BatchDelete, Event: Event{ID: "999", UserID: 1}},
+				}
+			},
+			expectedError: pkg.ErrEventNotFound,
+			expectedIndex: 3,
+			expectedCount: 1,
+			expectedTitle: "Existing",
+		},
+		{
+			name:   "atomic checks owner and version",
+			atomic: true,
+			ops: func(existing Event) []BatchOp {
+				return []BatchOp{{Type: BatchUpdate, Event: Event{ID: existing.ID, UserID: 2, Start: start, End: start, Title: "Mine"}}}
+			},
+			expectedError: pkg.ErrEventNotFound,
+			expectedCount: 1,
+			expectedTitle: "Existing",
+		},
+		{
+			name: "best effort",
+			ops: func(existing Event) []BatchOp {
+				return []BatchOp{
+					{Type: BatchUpdate, Event: Event{ID: existing.ID, UserID: 1, Start: start, End: start, Title: "Stale", Version: 7}},
+					{Type: BatchCreate, Event: newEvent(1, start, "First")},
+					{Type: BatchDelete, Event: Event{ID: "999", UserID: 1}},
+				}
+			},
+			expectedCount: 2,
+			expectedTitle: "Existing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := NewCalendar()
+			existing, _ := cal.CreateEvent(newEvent(1, start, "Existing"))
+
+			results, err := cal.ApplyBatch(tt.ops(existing), tt.atomic)
+			var batchErr *BatchError
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) || !errors.As(err, &batchErr) || batchErr.Index != tt.expectedIndex {
+					t.Fatalf("expected %v at operation %d, got %v", tt.expectedError, tt.expectedIndex, err)
+				}
+			} else if err != nil {
+				t.Fatalf("ApplyBatch failed: %v", err)
+			}
+
+			if len(cal.events) != tt.expectedCount {
+				t.Fatalf("expected %d events, got %d", tt.expectedCount, len(cal.events))
+			}
+			if got := cal.events[existing.ID]; got.Title != tt.expectedTitle {
+				t.Fatalf("expected title %q, got %q", tt.expectedTitle, got.Title)
+			}
+
+			if !tt.atomic {
+				if !errors.Is(results[0].Err, pkg.ErrVersionMismatch) || results[1].Err != nil || !errors.Is(results[2].Err, pkg.ErrEventNotFound) {
+					t.Fatalf("unexpected results %+v", results)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyBatchRollbackKeepsUID(t *testing.T) {
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+
+	// Откат не должен зависеть от порядка обхода, поэтому повторяем несколько раз
+	for i := 0; i < 10; i++ {
+		cal := NewCalendar()
+		event := newEvent(1, start, "Existing")
+		event.UID = "uid-1"
+		existing, _ := cal.CreateEvent(event)
+
+		replacement := newEvent(1, start, "Replacement")
+		replacement.UID = "uid-1"
+		_, err := cal.ApplyBatch([]BatchOp{
+			{Type: BatchDelete, Event: Event{ID: existing.ID, UserID: 1}},
+			{Type: BatchCreate, Event: replacement},
+			{Type: BatchDelete, Event: Event{ID: "999", UserID: 1}},
+		}, true)
+		if !errors.Is(err, pkg.ErrEventNotFound) {
+			t.Fatalf("expected ErrEventNotFound, got %v", err)
+		}
+
+		got, err := cal.GetEventByUID(1, "uid-1")
+		if err != nil || got.ID != existing.ID {
+			t.Fatalf("expected UID to point to %s, got %+v, %v", existing.ID, got, err)
+		}
+		if len(cal.events) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(cal.events))
+		}
+	}
+}
+
 func TestDeleteEvent(t *testing.T) {
 	cal := NewCalendar()
 	userID := 1
@@ -99,7 +383,7 @@ func TestDeleteEvent(t *testing.T) {
 	title := "Christmas"
 
 	// Создаем событие
-	event, _ := cal.CreateEvent(userID, date, title)
+	event, _ := cal.CreateEvent(newEvent(userID, date, title))
 
 	// Проверяем, что событие существует
 	if len(cal.events) != 1 {
@@ -107,7 +391,7 @@ func TestDeleteEvent(t *testing.T) {
 	}
 
 	// Удаляем событие
-	err := cal.DeleteEvent(event.ID)
+	err := cal.DeleteEvent(1, event.ID, 0)
 	if err != nil {
 		t.Fatalf("DeleteEvent failed: %v", err)
 	}
@@ -122,24 +406,24 @@ func TestDeleteEventNotFound(t *testing.T) {
 	cal := NewCalendar()
 
 	// Пытаемся удалить несуществующее событие
-	err := cal.DeleteEvent(999)
+	err := cal.DeleteEvent(1, "999", 0)
 	if err == nil {
 		t.Fatal("expected error when deleting non-existent event")
 	}
 }
 
 func TestGetEventsForDay(t *testing.T) {
-	cal := NewCalendar()
+	service := NewService(NewCalendar())
 	userID := 1
 	date1 := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
 	date2 := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)
 
 	// Создаем два события на разные дни
-	cal.CreateEvent(userID, date1, "Christmas")
-	cal.CreateEvent(userID, date2, "Boxing Day")
+	service.CreateEvent(newEvent(userID, date1, "Christmas"))
+	service.CreateEvent(newEvent(userID, date2, "Boxing Day"))
 
 	// Получаем события на 25 декабря
-	events := cal.GetEventsForDay(userID, date1)
+	events, _ := service.GetEventsForDay(userID, date1)
 	if len(events) != 1 {
 		t.Fatalf("expected 1 event for day, got %d", len(events))
 	}
@@ -149,7 +433,7 @@ func TestGetEventsForDay(t *testing.T) {
 }
 
 func TestGetEventsForWeek(t *testing.T) {
-	cal := NewCalendar()
+	service := NewService(NewCalendar())
 	userID := 1
 
 	// Создаем события на разные дни одной недели
@@ -157,19 +441,19 @@ func TestGetEventsForWeek(t *testing.T) {
 	date2 := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC) // Вторник
 	date3 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)   // Другая неделя
 
-	cal.CreateEvent(userID, date1, "Christmas")
-	cal.CreateEvent(userID, date2, "Boxing Day")
-	cal.CreateEvent(userID, date3, "New Year")
+	service.CreateEvent(newEvent(userID, date1, "Christmas"))
+	service.CreateEvent(newEvent(userID, date2, "Boxing Day"))
+	service.CreateEvent(newEvent(userID, date3, "New Year"))
 
 	// Получаем события на неделю 25 декабря
-	events := cal.GetEventsForWeek(userID, date1)
+	events, _ := service.GetEventsForWeek(userID, date1)
 	if len(events) != 2 {
 		t.Fatalf("expected 2 events for week, got %d", len(events))
 	}
 }
 
 func TestGetEventsForMonth(t *testing.T) {
-	cal := NewCalendar()
+	service := NewService(NewCalendar())
 	userID := 1
 
 	// Создаем события на разные месяцы
@@ -177,31 +461,17 @@ func TestGetEventsForMonth(t *testing.T) {
 	date2 := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)
 	date3 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	cal.CreateEvent(userID, date1, "Christmas")
-	cal.CreateEvent(userID, date2, "Boxing Day")
-	cal.CreateEvent(userID, date3, "New Year")
+	service.CreateEvent(newEvent(userID, date1, "Christmas"))
+	service.CreateEvent(newEvent(userID, date2, "Boxing Day"))
+	service.CreateEvent(newEvent(userID, date3, "New Year"))
 
 	// Получаем события на декабрь 2023
-	events := cal.GetEventsForMonth(userID, date1)
+	events, _ := service.GetEventsForMonth(userID, date1)
 	if len(events) != 2 {
 		t.Fatalf("expected 2 events for month, got %d", len(events))
 	}
 }
 
-func TestIsSameDay(t *testing.T) {
-	date1 := time.Date(2023, 12, 25, 10, 30, 0, 0, time.UTC)
-	date2 := time.Date(2023, 12, 25, 15, 45, 0, 0, time.UTC)
-	date3 := time.Date(2023, 12, 26, 10, 30, 0, 0, time.UTC)
-
-	if !isSameDay(date1, date2) {
-		t.Fatal("expected dates to be the same day")
-	}
-
-	if isSameDay(date1, date3) {
-		t.Fatal("expected dates to be different days")
-	}
-}
-
 func TestCalendarConcurrency(t *testing.T) {
 	cal := NewCalendar()
 	userID := 1
@@ -213,7 +483,7 @@ func TestCalendarConcurrency(t *testing.T) {
 
 	for i := 0; i < 10; i++ {
 		go func(id int) {
-			cal.CreateEvent(userID+id, date, title)
+			cal.CreateEvent(newEvent(userID+id, date, title))
 			done <- true
 		}(i)
 	}
@@ -228,3 +498,935 @@ func TestCalendarConcurrency(t *testing.T) {
 		t.Fatalf("expected 10 events, got %d", len(cal.events))
 	}
 }
+
+func TestServiceGetEventsForPeriods(t *testing.T) {
+	service := NewService(NewCalendar())
+	userID := 1
+
+	service.CreateEvent(newEvent(userID, time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC), "Christmas"))
+	service.CreateEvent(newEvent(userID, time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), "New Year's Eve"))
+	service.CreateEvent(newEvent(userID, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "New Year"))
+	service.CreateEvent(newEvent(userID+1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Other user"))
+
+	day := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	events, err := service.GetEventsForDay(userID, day)
+	if err != nil {
+		t.Fatalf("GetEventsForDay failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event for day, got %d", len(events))
+	}
+
+	// Неделя с 25 по 31 декабря включительно
+	events, err = service.GetEventsForWeek(userID, day)
+	if err != nil {
+		t.Fatalf("GetEventsForWeek failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events for week, got %d", len(events))
+	}
+
+	events, err = service.GetEventsForMonth(userID, day)
+	if err != nil {
+		t.Fatalf("GetEventsForMonth failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events for month, got %d", len(events))
+	}
+}
+
+func TestIndexFollowsUpdatesAndDeletes(t *testing.T) {
+	cal := NewCalendar()
+	userID := 1
+	date1 := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+	date2 := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)
+
+	service := NewService(cal)
+
+	event, _ := cal.CreateEvent(newEvent(userID, date1, "Christmas"))
+	cal.UpdateEvent(Event{ID: event.ID, UserID: 1, Start: date2, End: date2, Title: "Boxing Day"})
+
+	if events, _ := service.GetEventsForDay(userID, date1); len(events) != 0 {
+		t.Fatalf("expected no events on old date after update, got %d", len(events))
+	}
+	if events, _ := service.GetEventsForDay(userID, date2); len(events) != 1 {
+		t.Fatalf("expected 1 event on new date after update, got %d", len(events))
+	}
+
+	cal.DeleteEvent(1, event.ID, 0)
+	if events, _ := service.GetEventsForDay(userID, date2); len(events) != 0 {
+		t.Fatalf("expected no events after delete, got %d", len(events))
+	}
+	if len(cal.index) != 0 {
+		t.Fatalf("expected empty index after delete, got %d users", len(cal.index))
+	}
+}
+
+func TestIndexMaxDuration(t *testing.T) {
+	cal := NewCalendar()
+	start := time.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	cal.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Call"})
+	long, _ := cal.CreateEvent(Event{UserID: 1, Start: start, End: start.AddDate(0, 3, 0), Title: "Project"})
+	if got := cal.index[1].maxDuration; got != long.Duration() {
+		t.Fatalf("expected max duration %v, got %v", long.Duration(), got)
+	}
+
+	// Укороченное, а затем удаленное длинное событие больше не расширяет поиск
+	cal.UpdateEvent(Event{ID: long.ID, UserID: 1, Start: start, End: start.Add(2 * time.Hour), Title: "Project"})
+	if got := cal.index[1].maxDuration; got != 2*time.Hour {
+		t.Fatalf("expected max duration 2h after update, got %v", got)
+	}
+	cal.DeleteEvent(1, long.ID, 0)
+	if got := cal.index[1].maxDuration; got != time.Hour {
+		t.Fatalf("expected max duration 1h after delete, got %v", got)
+	}
+}
+
+func TestGetEventsInRangeSortedByDate(t *testing.T) {
+	cal := NewCalendar()
+	userID := 1
+	from := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	cal.CreateEvent(newEvent(userID, from.AddDate(0, 0, 20), "Third"))
+	cal.CreateEvent(newEvent(userID, from.AddDate(0, 0, 5), "First"))
+	cal.CreateEvent(newEvent(userID, from.AddDate(0, 0, 10), "Second"))
+
+	events, _ := cal.GetEventsInRange(userID, from, from.AddDate(0, 1, 0))
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	for i, title := range []string{"First", "Second", "Third"} {
+		if events[i].Title != title {
+			t.Fatalf("expected event %d to be %s, got %s", i, title, events[i].Title)
+		}
+	}
+}
+
+func TestGetEventsOverlappingWindow(t *testing.T) {
+	service := NewService(NewCalendar())
+	userID := 1
+
+	// Конференция с 24 по 27 декабря и встреча, заканчивающаяся ровно в полночь
+	service.CreateEvent(Event{
+		UserID: userID,
+		Start:  time.Date(2023, 12, 24, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2023, 12, 28, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+		Title:  "Conference",
+	})
+	service.CreateEvent(Event{
+		UserID: userID,
+		Start:  time.Date(2023, 12, 25, 23, 0, 0, 0, time.UTC),
+		End:    time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC),
+		Title:  "Late call",
+	})
+
+	events, _ := service.GetEventsForDay(userID, time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC))
+	if len(events) != 1 || events[0].Title != "Conference" {
+		t.Fatalf("expected only the conference on Dec 26, got %+v", events)
+	}
+
+	events, _ = service.GetEventsForDay(userID, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC))
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events on Dec 25, got %d", len(events))
+	}
+
+	// Неделя 1-7 января не пересекается с конференцией
+	events, _ = service.GetEventsForWeek(userID, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if len(events) != 0 {
+		t.Fatalf("expected no events in the next week, got %d", len(events))
+	}
+}
+
+func TestServiceRejectsEndBeforeStart(t *testing.T) {
+	service := NewService(NewCalendar())
+	start := time.Date(2023, 12, 25, 15, 0, 0, 0, time.UTC)
+
+	_, err := service.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(-time.Hour), Title: "Backwards"})
+	if !errors.Is(err, pkg.ErrEndBeforeStart) {
+		t.Fatalf("expected ErrEndBeforeStart, got %v", err)
+	}
+}
+
+func TestEventUnmarshalLegacyDate(t *testing.T) {
+	var event Event
+	data := []byte(`{"id":1,"user_id":1,"date":"2023-12-25T00:00:00Z","title":"Christmas"}`)
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if !event.AllDay {
+		t.Fatal("expected legacy event to be all-day")
+	}
+	if !event.Start.Equal(time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)) || !event.End.Equal(time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected legacy event times: %v - %v", event.Start, event.End)
+	}
+	if event.ID != "1" {
+		t.Fatalf("expected legacy numeric ID to become \"1\", got %q", event.ID)
+	}
+}
+
+func TestEventID(t *testing.T) {
+	// ID одной миллисекунды строго возрастают
+	var g ulidGenerator
+	now := time.Date(2025, 8, 11, 10, 0, 0, 0, time.UTC)
+	first, second := g.next(now), g.next(now)
+	if !isULID(string(first)) || first.Compare(second) >= 0 {
+		t.Fatalf("expected increasing ULIDs, got %q, %q", first, second)
+	}
+	if later := g.next(now.Add(time.Millisecond)); later.Compare(second) <= 0 {
+		t.Fatalf("expected ULID of the next millisecond after %q, got %q", second, later)
+	}
+
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: string(first)},
+		{value: "42"},
+		{value: "", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "042", wantErr: true},
+		{value: "01ARZ3NDEKTSV4RRFFQ69G5FAU", wantErr: true},
+		{value: "81ARZ3NDEKTSV4RRFFQ69G5FAV", wantErr: true},
+		{value: "01arz3ndektsv4rrffq69g5fav", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseEventID(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("ParseEventID(%q): expected error %v, got %v", tt.value, tt.wantErr, err)
+		}
+	}
+
+	// Прежние целые ID идут раньше ULID и сравниваются как числа
+	ids := []EventID{first, "10", "9", second}
+	slices.SortFunc(ids, EventID.Compare)
+	if !slices.Equal(ids, []EventID{"9", "10", first, second}) {
+		t.Fatalf("unexpected order: %v", ids)
+	}
+
+	var decoded struct {
+		ID     EventID `json:"id"`
+		Series EventID `json:"series_id"`
+	}
+	if err := json.Unmarshal([]byte(`{"id": 7, "series_id": "`+string(first)+`"}`), &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.ID != "7" || decoded.Series != first {
+		t.Fatalf("unexpected decoded IDs: %+v", decoded)
+	}
+}
+
+func TestApplyLegacyRecords(t *testing.T) {
+	// Записи журнала до перехода на ULID: целые ID и счетчик next_id
+	lines := []string{
+		`{"op":"put","event":{"id":1,"user_id":1,"start":"2024-01-01T10:00:00Z","end":"2024-01-01T11:00:00Z","title":"Stand-up","rrule":"FREQ=DAILY","version":1},"next_id":2}`,
+		`{"op":"put","event":{"id":2,"user_id":1,"start":"2024-01-02T12:00:00Z","end":"2024-01-02T13:00:00Z","title":"Moved","series_id":1,"occurrence_start":"2024-01-02T10:00:00Z","version":1},"next_id":3}`,
+		`{"op":"put","event":{"id":3,"user_id":1,"start":"2024-01-03T10:00:00Z","end":"2024-01-03T11:00:00Z","title":"Deleted","version":1},"next_id":4}`,
+		`{"op":"delete","id":3,"next_id":4}`,
+	}
+
+	cal := NewCalendar()
+	for _, line := range lines {
+		var rec Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		cal.Apply(rec)
+	}
+
+	if _, err := cal.GetEvent("3"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected deleted legacy event, got %v", err)
+	}
+	override, err := cal.GetEvent("2")
+	if err != nil || override.SeriesID != "1" {
+		t.Fatalf("expected override of series 1, got %+v, %v", override, err)
+	}
+
+	// Удаление серии по прежнему ID удаляет и ее измененное вхождение
+	if err := cal.DeleteEvent(1, "1", 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if _, err := cal.GetEvent("2"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected override to be deleted with the series, got %v", err)
+	}
+}
+
+const (
+	benchEvents = 1_000_000
+	benchUsers  = 1_000
+)
+
+var (
+	benchOnce     sync.Once
+	benchCalendar *Calendar
+)
+
+// benchmarkCalendar строит календарь с 1M событий: 1000 пользователей по 1000 событий за ~3 года
+func benchmarkCalendar() *Calendar {
+	benchOnce.Do(func() {
+		benchCalendar = NewCalendar()
+		start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		for i := 0; i < benchEvents; i++ {
+			date := start.Add(time.Duration(i/benchUsers) * 26 * time.Hour)
+			benchCalendar.CreateEvent(newEvent(i%benchUsers+1, date, "Event"))
+		}
+	})
+
+	return benchCalendar
+}
+
+// scanEventsForDay поиск полным перебором, как до появления индекса
+func scanEventsForDay(c *Calendar, userID int, day time.Time) []Event {
+	from, to := dayBounds(day)
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var result []Event
+	for _, event := range c.events {
+		if event.UserID == userID && !event.Start.Before(from) && event.Start.Before(to) {
+			result = append(result, event)
+		}
+	}
+
+	return result
+}
+
+func BenchmarkGetEventsForDay(b *testing.B) {
+	service := NewService(benchmarkCalendar())
+	from, to := dayBounds(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		service.GetEventsInRange(i%benchUsers+1, from, to)
+	}
+}
+
+func BenchmarkGetEventsForDayFullScan(b *testing.B) {
+	cal := benchmarkCalendar()
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		scanEventsForDay(cal, i%benchUsers+1, day)
+	}
+}
+
+func BenchmarkGetEventsForWeek(b *testing.B) {
+	service := NewService(benchmarkCalendar())
+	from, to := weekBounds(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		service.GetEventsInRange(i%benchUsers+1, from, to)
+	}
+}
+
+func BenchmarkGetEventsForMonth(b *testing.B) {
+	service := NewService(benchmarkCalendar())
+	from, to := monthBounds(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		service.GetEventsInRange(i%benchUsers+1, from, to)
+	}
+}
+
+func BenchmarkCreateEvent(b *testing.B) {
+	cal := NewCalendar()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < b.N; i++ {
+		cal.CreateEvent(newEvent(i%benchUsers+1, start.Add(time.Duration(i)*time.Minute), "Event"))
+	}
+}
+
+func TestServiceTimeZones(t *testing.T) {
+	service := NewService(NewCalendar())
+	userID := 1
+
+	if err := service.SetTimeZone(userID, "Mars/Olympus"); !errors.Is(err, pkg.ErrInvalidTimeZone) {
+		t.Fatalf("expected ErrInvalidTimeZone, got %v", err)
+	}
+	if err := service.SetTimeZone(userID, "Asia/Vladivostok"); err != nil {
+		t.Fatalf("SetTimeZone failed: %v", err)
+	}
+
+	// 20:00 UTC 25 декабря — это уже 06:00 26 декабря во Владивостоке
+	start := time.Date(2023, 12, 25, 20, 0, 0, 0, time.UTC)
+	event, err := service.CreateEvent(newEvent(userID, start, "Stand-up"))
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if event.TimeZone != "Asia/Vladivostok" {
+		t.Fatalf("expected user time zone on event, got %q", event.TimeZone)
+	}
+	if event.Start.Day() != 26 || event.Start.Hour() != 6 {
+		t.Fatalf("expected start in event time zone, got %v", event.Start)
+	}
+
+	loc, _ := service.Location(userID, "")
+	events, _ := service.GetEventsForDay(userID, time.Date(2023, 12, 26, 0, 0, 0, 0, loc))
+	if len(events) != 1 {
+		t.Fatalf("expected event on Dec 26 in user's zone, got %d", len(events))
+	}
+
+	loc, _ = service.Location(userID, "UTC")
+	events, _ = service.GetEventsForDay(userID, time.Date(2023, 12, 26, 0, 0, 0, 0, loc))
+	if len(events) != 0 {
+		t.Fatalf("expected no events on Dec 26 in UTC, got %d", len(events))
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	cal := NewCalendar()
+	event, _ := cal.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas"))
+	cal.SetTimeZone(1, "Europe/Moscow")
+
+	var state State
+	cal.Checkpoint(func(s State) error {
+		state = s
+		return nil
+	})
+
+	restored := NewCalendar()
+	restored.Restore(state)
+
+	if _, err := restored.GetEvent(event.ID); err != nil {
+		t.Fatalf("expected event after restore: %v", err)
+	}
+	if timeZone, _ := restored.GetTimeZone(1); timeZone != "Europe/Moscow" {
+		t.Fatalf("expected time zone after restore, got %q", timeZone)
+	}
+}
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		want    string
+		wantErr bool
+	}{
+		{name: "weekly", rule: "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", want: "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10"},
+		{name: "prefix and case", rule: "RRULE:freq=monthly;byday=-1fr", want: "FREQ=MONTHLY;BYDAY=-1FR"},
+		{name: "interval one dropped", rule: "FREQ=DAILY;INTERVAL=1;UNTIL=20240131T000000Z", want: "FREQ=DAILY;UNTIL=20240131T000000Z"},
+		{name: "until date", rule: "FREQ=DAILY;UNTIL=20240131", want: "FREQ=DAILY;UNTIL=20240131"},
+		{name: "missing freq", rule: "COUNT=3", wantErr: true},
+		{name: "unknown freq", rule: "FREQ=HOURLY", wantErr: true},
+		{name: "count and until", rule: "FREQ=DAILY;COUNT=3;UNTIL=20240131", wantErr: true},
+		{name: "bad weekday", rule: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{name: "ordinal with weekly", rule: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{name: "bad month day", rule: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{name: "unsupported part", rule: "FREQ=YEARLY;BYMONTH=2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule)
+			if tt.wantErr {
+				if !errors.Is(err, pkg.ErrInvalidRecurrence) {
+					t.Fatalf("expected ErrInvalidRecurrence, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRRule failed: %v", err)
+			}
+			if got := rule.String(); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecurringOccurrences(t *testing.T) {
+	berlin, _ := LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name  string
+		start time.Time
+		rule  string
+		from  time.Time
+		to    time.Time
+		want  []string
+	}{
+		{
+			name:  "weekly by day with count",
+			start: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=3",
+			from:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-01-01T10:00:00Z", "2024-01-03T10:00:00Z", "2024-01-08T10:00:00Z"},
+		},
+		{
+			name:  "daily interval until",
+			start: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+			rule:  "FREQ=DAILY;INTERVAL=2;UNTIL=20240107",
+			from:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-01-01T09:00:00Z", "2024-01-03T09:00:00Z", "2024-01-05T09:00:00Z", "2024-01-07T09:00:00Z"},
+		},
+		{
+			name:  "last day of month",
+			start: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			from:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-02-29T12:00:00Z", "2024-03-31T12:00:00Z", "2024-04-30T12:00:00Z"},
+		},
+		{
+			name:  "monthly skips short months",
+			start: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			rule:  "FREQ=MONTHLY",
+			from:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-01-31T12:00:00Z", "2024-03-31T12:00:00Z", "2024-05-31T12:00:00Z"},
+		},
+		{
+			name:  "second tuesday",
+			start: time.Date(2024, 1, 9, 15, 0, 0, 0, time.UTC),
+			rule:  "FREQ=MONTHLY;BYDAY=2TU;COUNT=3",
+			from:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-01-09T15:00:00Z", "2024-02-13T15:00:00Z", "2024-03-12T15:00:00Z"},
+		},
+		{
+			name:  "leap day yearly",
+			start: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			rule:  "FREQ=YEARLY",
+			from:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-02-29T00:00:00Z", "2028-02-29T00:00:00Z"},
+		},
+		{
+			name:  "far window without count",
+			start: time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC),
+			rule:  "FREQ=WEEKLY;INTERVAL=2",
+			from:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2030-01-07T10:00:00Z"},
+		},
+		{
+			name:  "wall clock kept across DST",
+			start: time.Date(2024, 3, 29, 9, 0, 0, 0, berlin),
+			rule:  "FREQ=DAILY;COUNT=3",
+			from:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			to:    time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2024-03-29T09:00:00+01:00", "2024-03-30T09:00:00+01:00", "2024-03-31T09:00:00+02:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := Event{ID: "7", UserID: 1, Start: tt.start, End: tt.start.Add(time.Hour), Title: "Series", RRule: tt.rule}
+
+			occurrences := expandOccurrences([]Event{series}, tt.from, tt.to)
+			if len(occurrences) != len(tt.want) {
+				t.Fatalf("expected %d occurrences, got %d: %v", len(tt.want), len(occurrences), occurrences)
+			}
+			for i, occurrence := range occurrences {
+				if got := occurrence.Start.Format(time.RFC3339); got != tt.want[i] {
+					t.Errorf("occurrence %d: expected %s, got %s", i, tt.want[i], got)
+				}
+				if occurrence.SeriesID != series.ID || occurrence.OccurrenceStart == nil || !occurrence.OccurrenceStart.Equal(occurrence.Start) {
+					t.Errorf("occurrence %d: expected series reference, got %+v", i, occurrence)
+				}
+				if occurrence.Duration() != time.Hour {
+					t.Errorf("occurrence %d: expected 1h duration, got %v", i, occurrence.Duration())
+				}
+			}
+		})
+	}
+}
+
+func TestServiceExpandsRecurringEvents(t *testing.T) {
+	cal := NewCalendar()
+	service := NewService(cal)
+	userID := 1
+
+	if _, err := service.CreateEvent(Event{UserID: userID, Title: "Broken", RRule: "FREQ=SOMETIMES"}); !errors.Is(err, pkg.ErrInvalidRecurrence) {
+		t.Fatalf("expected ErrInvalidRecurrence, got %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series, err := service.CreateEvent(Event{
+		UserID: userID,
+		Start:  start,
+		End:    start.Add(15 * time.Minute),
+		Title:  "Stand-up",
+		RRule:  "freq=weekly;byday=mo,we,fr",
+	})
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if series.RRule != "FREQ=WEEKLY;BYDAY=MO,WE,FR" {
+		t.Fatalf("expected normalized rule, got %q", series.RRule)
+	}
+	single, _ := service.CreateEvent(newEvent(userID, time.Date(2024, 6, 12, 12, 0, 0, 0, time.UTC), "Lunch"))
+
+	events, err := service.GetEventsForWeek(userID, time.Date(2024, 6, 12, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetEventsForWeek failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 3 occurrences and 1 event, got %d", len(events))
+	}
+	if events[1].SeriesID != series.ID || events[1].Start.Day() != 12 {
+		t.Fatalf("expected Wednesday occurrence second, got %+v", events[1])
+	}
+	if events[2].ID != single.ID || events[2].SeriesID != "" {
+		t.Fatalf("expected single event after Wednesday stand-up, got %+v", events[2])
+	}
+
+	if got, _ := service.GetEventsForDay(userID, time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)); len(got) != 1 || got[0].SeriesID != series.ID {
+		t.Fatalf("expected Friday occurrence, got %v", got)
+	}
+
+	// Серия без правила становится одиночным событием
+	series.RRule = ""
+	if err := service.UpdateEvent(series); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+	if events, _ := service.GetEventsForWeek(userID, time.Date(2024, 6, 12, 0, 0, 0, 0, time.UTC)); len(events) != 1 {
+		t.Fatalf("expected only the single event after removing rule, got %d", len(events))
+	}
+}
+
+func TestRecurringExceptions(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 10, 0, 0, 0, time.UTC) }
+	january := func(service *Service) []Event {
+		events, _ := service.GetEventsInRange(1, day(1), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+		return events
+	}
+	starts := func(events []Event) []int {
+		var result []int
+		for _, event := range events {
+			result = append(result, event.Start.Day())
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		rule   string
+		change func(*Service, Event) error
+		want   []int
+	}{
+		{
+			name: "delete this",
+			rule: "FREQ=WEEKLY;COUNT=4",
+			change: func(s *Service, series Event) error {
+				return s.DeleteOccurrences(1, series.ID, 0, day(8), ScopeThis)
+			},
+			want: []int{1, 15, 22},
+		},
+		{
+			name: "move this",
+			rule: "FREQ=WEEKLY;COUNT=4",
+			change: func(s *Service, series Event) error {
+				moved := Event{ID: series.ID, UserID: series.UserID, Start: day(9), End: day(9).Add(time.Hour), Title: "Moved"}
+				override, err := s.UpdateOccurrences(moved, day(8), ScopeThis)
+				if err != nil {
+					return err
+				}
+				if override.ID == series.ID || override.SeriesID != series.ID || !override.OccurrenceStart.Equal(day(8)) {
+					t.Errorf("expected override linked to series, got %+v", override)
+				}
+				return nil
+			},
+			want: []int{1, 9, 15, 22},
+		},
+		{
+			name: "delete following with count",
+			rule: "FREQ=WEEKLY;COUNT=4",
+			change: func(s *Service, series Event) error {
+				return s.DeleteOccurrences(1, series.ID, 0, day(15), ScopeFollowing)
+			},
+			want: []int{1, 8},
+		},
+		{
+			name: "update following splits series",
+			rule: "FREQ=WEEKLY;COUNT=4",
+			change: func(s *Service, series Event) error {
+				later := Event{ID: series.ID, UserID: series.UserID, Start: day(15).Add(time.Hour), End: day(15).Add(2 * time.Hour), Title: "Later", RRule: series.RRule}
+				tail, err := s.UpdateOccurrences(later, day(15), ScopeFollowing)
+				if err != nil {
+					return err
+				}
+				if tail.ID == series.ID || tail.RRule != "FREQ=WEEKLY;COUNT=2" {
+					t.Errorf("expected new series with remaining count, got %+v", tail)
+				}
+				if head, _ := s.GetEvent(series.ID); head.RRule != "FREQ=WEEKLY;COUNT=2" {
+					t.Errorf("expected truncated series, got %q", head.RRule)
+				}
+				return nil
+			},
+			want: []int{1, 8, 15, 22},
+		},
+		{
+			name: "delete following without count",
+			rule: "FREQ=WEEKLY",
+			change: func(s *Service, series Event) error {
+				return s.DeleteOccurrences(1, series.ID, 0, day(22), ScopeFollowing)
+			},
+			want: []int{1, 8, 15},
+		},
+		{
+			name: "following from first occurrence deletes series",
+			rule: "FREQ=WEEKLY",
+			change: func(s *Service, series Event) error {
+				return s.DeleteOccurrences(1, series.ID, 0, day(1), ScopeFollowing)
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(NewCalendar())
+			series, err := service.CreateEvent(Event{UserID: 1, Start: day(1), End: day(1).Add(time.Hour), Title: "Weekly", RRule: tt.rule})
+			if err != nil {
+				t.Fatalf("CreateEvent failed: %v", err)
+			}
+
+			if err := tt.change(service, series); err != nil {
+				t.Fatalf("change failed: %v", err)
+			}
+
+			got := starts(january(service))
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected occurrences on %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected occurrences on %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRecurringExceptionErrors(t *testing.T) {
+	cal := NewCalendar()
+	service := NewService(cal)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series, _ := service.CreateEvent(Event{UserID: 1, Start: start, End: start, Title: "Weekly", RRule: "FREQ=WEEKLY"})
+
+	if _, err := ParseScope("some"); !errors.Is(err, pkg.ErrInvalidScope) {
+		t.Fatalf("expected ErrInvalidScope, got %v", err)
+	}
+	if err := service.DeleteOccurrences(1, series.ID, 0, start.Add(time.Hour), ScopeThis); !errors.Is(err, pkg.ErrOccurrenceNotFound) {
+		t.Fatalf("expected ErrOccurrenceNotFound for time outside the rule, got %v", err)
+	}
+
+	service.DeleteOccurrences(1, series.ID, 0, start.AddDate(0, 0, 7), ScopeThis)
+	if err := service.DeleteOccurrences(1, series.ID, 0, start.AddDate(0, 0, 7), ScopeThis); !errors.Is(err, pkg.ErrOccurrenceNotFound) {
+		t.Fatalf("expected ErrOccurrenceNotFound for cancelled occurrence, got %v", err)
+	}
+
+	// Удаление серии удаляет и ее измененные вхождения
+	override, err := service.UpdateOccurrences(Event{ID: series.ID, UserID: 1, Start: start.AddDate(0, 0, 15), End: start.AddDate(0, 0, 15), Title: "Moved"}, start.AddDate(0, 0, 14), ScopeThis)
+	if err != nil {
+		t.Fatalf("UpdateOccurrences failed: %v", err)
+	}
+	if err := service.DeleteEvent(1, series.ID, 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if _, err := cal.GetEvent(override.ID); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected override to be deleted with series, got %v", err)
+	}
+}
+
+func TestSeriesScheduleChange(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+
+	tests := []struct {
+		name          string
+		change        func(*Service, Event) error
+		keepOverrides bool
+	}{
+		{
+			name: "rename keeps exceptions",
+			change: func(s *Service, series Event) error {
+				return s.UpdateEvent(Event{ID: series.ID, UserID: 1, Start: series.Start, End: series.End, Title: "Renamed", RRule: series.RRule})
+			},
+			keepOverrides: true,
+		},
+		{
+			name: "clear rule",
+			change: func(s *Service, series Event) error {
+				return s.UpdateEvent(Event{ID: series.ID, UserID: 1, Start: series.Start, End: series.End, Title: "Once"})
+			},
+		},
+		{
+			name: "move start",
+			change: func(s *Service, series Event) error {
+				return s.UpdateEvent(Event{ID: series.ID, UserID: 1, Start: series.Start.Add(time.Hour), End: series.End.Add(time.Hour), Title: "Later", RRule: series.RRule})
+			},
+		},
+		{
+			name: "patch rule",
+			change: func(s *Service, series Event) error {
+				rule := "FREQ=DAILY"
+				_, err := s.PatchEvent(1, series.ID, EventPatch{RRule: &rule})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := NewCalendar()
+			service := NewService(cal)
+			series, _ := service.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Weekly", RRule: "FREQ=WEEKLY"})
+			service.DeleteOccurrences(1, series.ID, 0, week(1), ScopeThis)
+			override, err := service.UpdateOccurrences(Event{ID: series.ID, UserID: 1, Start: week(2).Add(time.Hour), End: week(2).Add(2 * time.Hour), Title: "Moved"}, week(2), ScopeThis)
+			if err != nil {
+				t.Fatalf("UpdateOccurrences failed: %v", err)
+			}
+
+			if err := tt.change(service, series); err != nil {
+				t.Fatalf("change failed: %v", err)
+			}
+
+			got, _ := cal.GetEvent(series.ID)
+			_, overrideErr := cal.GetEvent(override.ID)
+			if tt.keepOverrides {
+				if len(got.ExDates) != 2 || overrideErr != nil {
+					t.Fatalf("expected exceptions to be kept, got exdates %v, override error %v", got.ExDates, overrideErr)
+				}
+				return
+			}
+			if len(got.ExDates) != 0 || !errors.Is(overrideErr, pkg.ErrEventNotFound) {
+				t.Fatalf("expected exceptions to be dropped, got exdates %v, override error %v", got.ExDates, overrideErr)
+			}
+		})
+	}
+
+	// Измененное вхождение не может стать серией ни заменой, ни патчем
+	service := NewService(NewCalendar())
+	series, _ := service.CreateEvent(Event{UserID: 1, Start: start, End: start, Title: "Weekly", RRule: "FREQ=WEEKLY"})
+	override, _ := service.UpdateOccurrences(Event{ID: series.ID, UserID: 1, Start: week(1), End: week(1), Title: "Moved"}, week(1), ScopeThis)
+	err := service.UpdateEvent(Event{ID: override.ID, UserID: 1, Start: week(1), End: week(1), Title: "Moved", RRule: "FREQ=DAILY"})
+	if !errors.Is(err, pkg.ErrInvalidRecurrence) {
+		t.Fatalf("expected ErrInvalidRecurrence for update, got %v", err)
+	}
+	rule := "FREQ=DAILY"
+	if _, err := service.PatchEvent(1, override.ID, EventPatch{RRule: &rule}); !errors.Is(err, pkg.ErrInvalidRecurrence) {
+		t.Fatalf("expected ErrInvalidRecurrence for patch, got %v", err)
+	}
+}
+
+func TestReplaceSeries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+	moved := func(at time.Time) Event {
+		return Event{Start: at.Add(time.Hour), End: at.Add(2 * time.Hour), Title: "Moved", OccurrenceStart: &at}
+	}
+
+	tests := []struct {
+		name          string
+		version       func(series Event) int
+		overrides     []Event
+		expectedError error
+	}{
+		{
+			name:      "replaces exceptions",
+			version:   func(series Event) int { return series.Version },
+			overrides: []Event{moved(week(3)), moved(week(4))},
+		},
+		{
+			name:          "stale version",
+			version:       func(series Event) int { return series.Version - 1 },
+			overrides:     []Event{moved(week(3))},
+			expectedError: pkg.ErrVersionMismatch,
+		},
+		{
+			name:          "not an occurrence",
+			version:       func(series Event) int { return series.Version },
+			overrides:     []Event{moved(week(3)), moved(week(5).Add(time.Minute))},
+			expectedError: pkg.ErrOccurrenceNotFound,
+		},
+		{
+			name:          "duplicate occurrence",
+			version:       func(series Event) int { return series.Version },
+			overrides:     []Event{moved(week(3)), moved(week(3))},
+			expectedError: pkg.ErrOccurrenceNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal := NewCalendar()
+			service := NewService(cal)
+			series, _ := service.CreateEvent(Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Weekly", RRule: "FREQ=WEEKLY"})
+			old, _ := service.UpdateOccurrences(Event{ID: series.ID, UserID: 1, Start: week(1), End: week(1).Add(time.Hour), Title: "Old"}, week(1), ScopeThis)
+			series, _ = service.GetEvent(series.ID)
+
+			err := service.ReplaceSeries(Event{
+				ID:      series.ID,
+				UserID:  1,
+				Start:   start,
+				End:     start.Add(time.Hour),
+				Title:   "Renamed",
+				RRule:   "FREQ=WEEKLY",
+				ExDates: []time.Time{week(2), week(3)},
+				Version: tt.version(series),
+			}, tt.overrides)
+
+			got, _ := cal.GetEvent(series.ID)
+			overrides, _ := service.GetOverrides(1, series.ID)
+			if tt.expectedError != nil {
+				// Ничего не изменилось
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("expected %v, got %v", tt.expectedError, err)
+				}
+				if got.Title != "Weekly" || len(overrides) != 1 || overrides[0].ID != old.ID {
+					t.Fatalf("expected series to be untouched, got %+v and overrides %+v", got, overrides)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReplaceSeries failed: %v", err)
+			}
+
+			// Старое измененное вхождение удалено, отмененными стали week(2) и новые вхождения
+			if got.Title != "Renamed" || !slices.EqualFunc(got.ExDates, []time.Time{week(2), week(3), week(4)}, time.Time.Equal) {
+				t.Fatalf("unexpected series %+v", got)
+			}
+			if len(overrides) != 2 || !overrides[0].Start.Equal(week(3).Add(time.Hour)) || overrides[1].SeriesID != series.ID {
+				t.Fatalf("unexpected overrides %+v", overrides)
+			}
+			if _, err := cal.GetEvent(old.ID); !errors.Is(err, pkg.ErrEventNotFound) {
+				t.Fatalf("expected old override to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEventUID(t *testing.T) {
+	cal := NewCalendar()
+	event := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Imported")
+	event.UID = "a@example.com"
+
+	created, _ := cal.CreateEvent(event)
+	if _, err := cal.CreateEvent(event); !errors.Is(err, pkg.ErrDuplicateUID) {
+		t.Fatalf("expected ErrDuplicateUID, got %v", err)
+	}
+	if got, err := cal.GetEventByUID(1, "a@example.com"); err != nil || got.ID != created.ID {
+		t.Fatalf("expected event by UID, got %+v, %v", got, err)
+	}
+	if _, err := cal.GetEventByUID(2, "a@example.com"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected UID to be scoped to the user, got %v", err)
+	}
+
+	cal.DeleteEvent(1, created.ID, 0)
+	if _, err := cal.GetEventByUID(1, "a@example.com"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected UID to be released after delete, got %v", err)
+	}
+}
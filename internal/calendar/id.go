@@ -0,0 +1,145 @@
+package calendar
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// EventID идентификатор события. Новые события получают ULID: 26 символов
+// base32 Crockford, первые 10 из которых кодируют время создания в миллисекундах,
+// поэтому ID упорядочены по времени создания и не выдают число событий.
+//
+// События, созданные до перехода на ULID, сохраняют прежние целые ID
+// в десятичной записи, например "42". Из JSON ID читается и строкой, и числом,
+// поэтому старые журналы, снимки и запросы устаревших маршрутов продолжают работать.
+type EventID string
+
+// UnmarshalJSON принимает строку или целое число. 0 означает пустой ID.
+func (id *EventID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = EventID(s)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = ""
+	if n != 0 {
+		*id = EventID(strconv.FormatInt(n, 10))
+	}
+
+	return nil
+}
+
+// Compare сравнивает ID в порядке создания событий: -1, 0 или +1.
+// Прежние целые ID без ведущих нулей короче ULID и сравниваются по длине,
+// а затем посимвольно, поэтому идут по возрастанию и раньше всех ULID.
+func (id EventID) Compare(other EventID) int {
+	if len(id) != len(other) {
+		return cmp.Compare(len(id), len(other))
+	}
+
+	return strings.Compare(string(id), string(other))
+}
+
+// ParseEventID проверяет ID события из запроса: ULID или прежний целый ID
+func ParseEventID(value string) (EventID, error) {
+	if isULID(value) {
+		return EventID(value), nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 && strconv.FormatInt(n, 10) == value {
+		return EventID(value), nil
+	}
+
+	return "", pkg.Invalid("id", "invalid id")
+}
+
+// crockford алфавит base32 Crockford, используемый в ULID
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLen длина строковой записи ULID
+const ulidLen = 26
+
+func isULID(value string) bool {
+	if len(value) != ulidLen || value[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(crockford, value[i]) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ulidGenerator выдает монотонные ULID: в пределах одной миллисекунды
+// случайная часть увеличивается на 1, поэтому ID строго возрастают
+type ulidGenerator struct {
+	mu     sync.Mutex
+	lastMs uint64
+	// hi и lo 80 бит случайной части: старшие 16 и младшие 64 бита
+	hi uint16
+	lo uint64
+}
+
+var idGenerator ulidGenerator
+
+// NewEventID возвращает новый ULID
+func NewEventID() EventID {
+	return idGenerator.next(time.Now())
+}
+
+func (g *ulidGenerator) next(now time.Time) EventID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(now.UnixMilli())
+	if ms > g.lastMs {
+		var entropy [10]byte
+		if _, err := rand.Read(entropy[:]); err != nil {
+			panic("calendar: read random bytes: " + err.Error())
+		}
+		g.lastMs = ms
+		g.hi = binary.BigEndian.Uint16(entropy[:2])
+		g.lo = binary.BigEndian.Uint64(entropy[2:])
+	} else {
+		// Часы не ушли вперед: продолжаем последовательность той же миллисекунды
+		g.lo++
+		if g.lo == 0 {
+			g.hi++
+		}
+	}
+
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], g.lastMs<<16|uint64(g.hi))
+	binary.BigEndian.PutUint64(data[8:], g.lo)
+
+	return EventID(encodeULID(data))
+}
+
+// encodeULID кодирует 128 бит в 26 символов base32 Crockford
+func encodeULID(data [16]byte) string {
+	hi := binary.BigEndian.Uint64(data[:8])
+	lo := binary.BigEndian.Uint64(data[8:])
+
+	var out [ulidLen]byte
+	// 26 символов по 5 бит кодируют 130 бит: старшие 2 бита всегда нули
+	for i := ulidLen - 1; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(out[:])
+}
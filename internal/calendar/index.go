@@ -0,0 +1,131 @@
+package calendar
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/btree"
+)
+
+// indexDegree степень B-дерева пользовательского индекса
+const indexDegree = 32
+
+// indexKey ключ индекса: начало события, затем ID для уникальности
+type indexKey struct {
+	start time.Time
+	id    EventID
+}
+
+func lessIndexKey(a, b indexKey) bool {
+	if !a.start.Equal(b.start) {
+		return a.start.Before(b.start)
+	}
+	return a.id.Compare(b.id) < 0
+}
+
+// userEvents события одного пользователя, упорядоченные по началу
+type userEvents struct {
+	tree *btree.BTreeG[indexKey]
+	// maxDuration самая большая длительность события пользователя. Событие,
+	// пересекающее интервал, начинается не раньше from - maxDuration.
+	maxDuration time.Duration
+	// durations число событий каждой длительности, чтобы пересчитать
+	// maxDuration, когда удаляется или укорачивается самое длинное событие
+	durations map[time.Duration]int
+	// recurring повторяющиеся серии пользователя. Вхождения серии могут попасть
+	// в любой интервал после ее начала, поэтому они проверяются при каждом поиске.
+	recurring map[EventID]time.Time
+}
+
+// userIndex упорядоченный по времени индекс событий каждого пользователя.
+// Поиск по диапазону дат стоит O(log n + k) вместо полного перебора.
+type userIndex map[int]*userEvents
+
+// insert добавляет событие в индекс
+func (idx userIndex) insert(event Event) {
+	user, ok := idx[event.UserID]
+	if !ok {
+		user = &userEvents{
+			tree:      btree.NewG(indexDegree, lessIndexKey),
+			durations: make(map[time.Duration]int),
+			recurring: make(map[EventID]time.Time),
+		}
+		idx[event.UserID] = user
+	}
+
+	if event.IsRecurring() {
+		user.recurring[event.ID] = event.Start
+		return
+	}
+
+	user.tree.ReplaceOrInsert(indexKey{start: event.Start, id: event.ID})
+	d := event.Duration()
+	user.durations[d]++
+	if d > user.maxDuration {
+		user.maxDuration = d
+	}
+}
+
+// remove удаляет событие из индекса
+func (idx userIndex) remove(event Event) {
+	user, ok := idx[event.UserID]
+	if !ok {
+		return
+	}
+
+	if event.IsRecurring() {
+		delete(user.recurring, event.ID)
+	} else if _, found := user.tree.Delete(indexKey{start: event.Start, id: event.ID}); found {
+		user.forgetDuration(event.Duration())
+	}
+	if user.tree.Len() == 0 && len(user.recurring) == 0 {
+		delete(idx, event.UserID)
+	}
+}
+
+// forgetDuration убирает длительность удаленного события и, если это было
+// последнее самое длинное событие, находит новый максимум
+func (user *userEvents) forgetDuration(d time.Duration) {
+	if user.durations[d]--; user.durations[d] > 0 {
+		return
+	}
+	delete(user.durations, d)
+	if d < user.maxDuration {
+		return
+	}
+
+	user.maxDuration = 0
+	for d := range user.durations {
+		user.maxDuration = max(user.maxDuration, d)
+	}
+}
+
+// candidateIDs возвращает ID событий пользователя, которые могут пересекаться
+// с [from, to), в порядке возрастания начала, а затем ID серий, начавшихся
+// до to. Точную проверку делает вызывающий.
+func (idx userIndex) candidateIDs(userID int, from, to time.Time) []EventID {
+	user, ok := idx[userID]
+	if !ok || !from.Before(to) {
+		return nil
+	}
+
+	var ids []EventID
+	user.tree.AscendRange(
+		indexKey{start: from.Add(-user.maxDuration)},
+		indexKey{start: to},
+		func(key indexKey) bool {
+			ids = append(ids, key.id)
+			return true
+		},
+	)
+
+	series := make([]EventID, 0, len(user.recurring))
+	for id, start := range user.recurring {
+		if start.Before(to) {
+			series = append(series, id)
+		}
+	}
+	slices.SortFunc(series, EventID.Compare)
+
+	return append(ids, series...)
+}
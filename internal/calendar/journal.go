@@ -0,0 +1,113 @@
+package calendar
+
+// Op тип изменения, записываемого в журнал
+type Op string
+
+const (
+	OpPut         Op = "put"
+	OpDelete      Op = "delete"
+	OpSetTimeZone Op = "set_time_zone"
+	// OpBatch объединяет несколько изменений, которые применяются вместе
+	OpBatch Op = "batch"
+)
+
+// Record описывает одно изменение календаря.
+// Для OpPut хранится итоговое состояние события целиком, поэтому
+// повторное применение записи не меняет результат.
+type Record struct {
+	Op       Op       `json:"op"`
+	Event    Event    `json:"event"`
+	ID       EventID  `json:"id,omitempty"`
+	UserID   int      `json:"user_id,omitempty"`
+	TimeZone string   `json:"time_zone,omitempty"`
+	Records  []Record `json:"records,omitempty"`
+}
+
+// Journal принимает изменения до того, как они будут применены в памяти.
+// Если Append вернул ошибку, изменение не применяется.
+type Journal interface {
+	Append(rec Record) error
+}
+
+// SetJournal подключает журнал, в который будут записываться все изменения
+func (c *Calendar) SetJournal(journal Journal) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.journal = journal
+}
+
+// Apply применяет запись журнала без повторной записи в журнал.
+// Используется при восстановлении состояния после перезапуска.
+func (c *Calendar) Apply(rec Record) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.apply(rec)
+	return nil
+}
+
+// commit записывает изменение в журнал и применяет его. Вызывается под блокировкой.
+func (c *Calendar) commit(rec Record) error {
+	if c.journal != nil {
+		if err := c.journal.Append(rec); err != nil {
+			return err
+		}
+	}
+
+	c.apply(rec)
+	return nil
+}
+
+// apply применяет изменение к состоянию в памяти. Вызывается под блокировкой.
+func (c *Calendar) apply(rec Record) {
+	switch rec.Op {
+	case OpPut:
+		if old, exists := c.events[rec.Event.ID]; exists {
+			c.unlink(old)
+		}
+		c.events[rec.Event.ID] = rec.Event
+		c.link(rec.Event)
+	case OpDelete:
+		if old, exists := c.events[rec.ID]; exists {
+			c.unlink(old)
+			delete(c.events, rec.ID)
+		}
+	case OpSetTimeZone:
+		c.timeZones[rec.UserID] = rec.TimeZone
+	case OpBatch:
+		for _, nested := range rec.Records {
+			c.apply(nested)
+		}
+	}
+}
+
+// link добавляет событие в индексы. Вызывается под блокировкой.
+func (c *Calendar) link(event Event) {
+	c.index.insert(event)
+	if event.UID != "" {
+		c.uids[uidKey{event.UserID, event.UID}] = event.ID
+	}
+	if event.SeriesID != "" {
+		if c.overrides[event.SeriesID] == nil {
+			c.overrides[event.SeriesID] = make(map[EventID]struct{})
+		}
+		c.overrides[event.SeriesID][event.ID] = struct{}{}
+	}
+}
+
+// unlink удаляет событие из индексов. UID удаляется, только если
+// он все еще указывает на это событие. Вызывается под блокировкой.
+func (c *Calendar) unlink(event Event) {
+	c.index.remove(event)
+	key := uidKey{event.UserID, event.UID}
+	if event.UID != "" && c.uids[key] == event.ID {
+		delete(c.uids, key)
+	}
+	if event.SeriesID != "" {
+		delete(c.overrides[event.SeriesID], event.ID)
+		if len(c.overrides[event.SeriesID]) == 0 {
+			delete(c.overrides, event.SeriesID)
+		}
+	}
+}
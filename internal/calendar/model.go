@@ -1,10 +1,102 @@
 package calendar
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+	"wb-calendar/pkg"
+)
 
+// Event событие календаря. TimeZone — часовой пояс события в формате IANA,
+// например Europe/Moscow; в нем отображаются Start и End.
+//
+// Событие с RRule — серия: хранится одной записью, а в выборках по датам
+// разворачивается во вхождения. У вхождения SeriesID равен ID серии,
+// а OccurrenceStart — началу вхождения по правилу (RECURRENCE-ID).
+// ExDates — начала отмененных вхождений серии (EXDATE).
+//
+// UID — глобальный идентификатор события из iCalendar, уникальный для пользователя.
+// Задается при импорте и сохраняется при экспорте.
+//
+// Измененное вхождение хранится отдельным событием со своим ID, у которого
+// SeriesID и OccurrenceStart указывают на замененное вхождение серии.
+//
+// Version увеличивается хранилищем при каждом изменении события и начинается с 1.
 type Event struct {
-	ID     int       `json:"id"`
-	UserID int       `json:"user_id"`
-	Date   time.Time `json:"date"`
-	Title  string    `json:"title"`
+	ID              EventID     `json:"id"`
+	UserID          int         `json:"user_id"`
+	Start           time.Time   `json:"start"`
+	End             time.Time   `json:"end"`
+	AllDay          bool        `json:"all_day"`
+	TimeZone        string      `json:"time_zone"`
+	Title           string      `json:"title"`
+	UID             string      `json:"uid,omitempty"`
+	RRule           string      `json:"rrule,omitempty"`
+	ExDates         []time.Time `json:"exdates,omitempty"`
+	SeriesID        EventID     `json:"series_id,omitempty"`
+	OccurrenceStart *time.Time  `json:"occurrence_start,omitempty"`
+	Version         int         `json:"version"`
+}
+
+// IsOverride проверяет, является ли событие измененным вхождением серии
+func (e Event) IsOverride() bool {
+	return e.SeriesID != "" && !e.IsRecurring()
+}
+
+// IsRecurring проверяет, является ли событие повторяющейся серией
+func (e Event) IsRecurring() bool {
+	return e.RRule != ""
+}
+
+// Duration возвращает длительность события
+func (e Event) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// Overlaps проверяет, пересекается ли событие с полуинтервалом [from, to).
+// Событие нулевой длительности пересекается с интервалом, если начинается в нем.
+func (e Event) Overlaps(from, to time.Time) bool {
+	if !e.Start.Before(to) {
+		return false
+	}
+	if e.End.Equal(e.Start) {
+		return !e.Start.Before(from)
+	}
+	return e.End.After(from)
+}
+
+// UnmarshalJSON читает также события, сохраненные до появления start/end:
+// единственная дата date превращается в событие на весь день.
+// События, сохраненные до появления версий, получают версию 1.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	var aux struct {
+		plain
+		Date *time.Time `json:"date"`
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*e = Event(aux.plain)
+	if aux.Date != nil && e.Start.IsZero() {
+		e.Start = *aux.Date
+		e.End = aux.Date.AddDate(0, 0, 1)
+		e.AllDay = true
+	}
+	if e.ID != "" && e.Version == 0 {
+		e.Version = 1
+	}
+
+	return nil
+}
+
+// CheckVersion проверяет, что событие имеет ожидаемую версию.
+// Нулевая ожидаемая версия означает изменение без проверки.
+func (e Event) CheckVersion(version int) error {
+	if version != 0 && version != e.Version {
+		return pkg.ErrVersionMismatch
+	}
+
+	return nil
 }
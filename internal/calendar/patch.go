@@ -0,0 +1,102 @@
+package calendar
+
+import (
+	"time"
+	"wb-calendar/pkg"
+)
+
+// EventPatch набор изменяемых полей события. Поля со значением nil не меняются.
+// Если задан только Start, событие сдвигается с сохранением длительности.
+// Version — ожидаемая версия события, 0 — без проверки.
+type EventPatch struct {
+	Start    *time.Time
+	End      *time.Time
+	AllDay   *bool
+	TimeZone *string
+	Title    *string
+	RRule    *string
+	Version  int
+}
+
+// IsEmpty сообщает, что патч ничего не меняет
+func (p EventPatch) IsEmpty() bool {
+	return p.Start == nil && p.End == nil && p.AllDay == nil && p.TimeZone == nil && p.Title == nil && p.RRule == nil
+}
+
+// Apply применяет патч к событию и проверяет результат так же, как Service.UpdateEvent.
+// Версию не меняет: ее увеличивает хранилище при сохранении.
+func (p EventPatch) Apply(event Event) (Event, error) {
+	if err := event.CheckVersion(p.Version); err != nil {
+		return Event{}, err
+	}
+	original := event
+	duration := event.Duration()
+
+	if p.Start != nil {
+		event.Start = *p.Start
+		event.End = event.Start.Add(duration)
+	}
+	if p.End != nil {
+		event.End = *p.End
+	}
+	if p.AllDay != nil {
+		event.AllDay = *p.AllDay
+	}
+	if p.TimeZone != nil {
+		if _, err := LoadLocation(*p.TimeZone); err != nil {
+			return Event{}, err
+		}
+		event.TimeZone = *p.TimeZone
+	}
+	if p.Title != nil {
+		if *p.Title == "" {
+			return Event{}, pkg.Required("title", "title cannot be empty")
+		}
+		event.Title = *p.Title
+	}
+	if p.RRule != nil {
+		if *p.RRule != "" && event.IsOverride() {
+			return Event{}, pkg.ErrInvalidRecurrence.WithMessage("modified occurrence cannot repeat")
+		}
+		event.RRule = *p.RRule
+		if event.RRule != "" {
+			rule, err := ParseRRule(event.RRule)
+			if err != nil {
+				return Event{}, err
+			}
+			event.RRule = rule.String()
+		}
+	}
+
+	if err := validateTimes(event); err != nil {
+		return Event{}, err
+	}
+	if ScheduleChanged(original, event) {
+		event.ExDates = nil
+	}
+
+	return inTimeZone(event), nil
+}
+
+// ApplyUpdate заменяет время, название и правило повторения события значениями
+// update, как при Service.UpdateEvent. Измененное вхождение не может стать серией.
+// Если расписание серии изменилось, отмененные вхождения сбрасываются.
+// Версию не меняет: ее увеличивает хранилище при сохранении.
+func ApplyUpdate(event, update Event) (Event, error) {
+	if update.RRule != "" && event.IsOverride() {
+		return Event{}, pkg.ErrInvalidRecurrence.WithMessage("modified occurrence cannot repeat")
+	}
+
+	updated := event
+	updated.Start = update.Start
+	updated.End = update.End
+	updated.AllDay = update.AllDay
+	updated.TimeZone = update.TimeZone
+	updated.Title = update.Title
+	updated.RRule = update.RRule
+	if ScheduleChanged(event, updated) {
+		updated.ExDates = nil
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,24 @@
+package calendar
+
+import "time"
+
+// dayBounds возвращает границы дня [начало, начало следующего дня)
+func dayBounds(day time.Time) (time.Time, time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// weekBounds возвращает границы ISO-недели (с понедельника), содержащей day
+func weekBounds(day time.Time) (time.Time, time.Time) {
+	start, _ := dayBounds(day)
+	// В ISO-неделе понедельник первый, воскресенье последнее
+	offset := (int(start.Weekday()) + 6) % 7
+	start = start.AddDate(0, 0, -offset)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// monthBounds возвращает границы месяца, содержащего day
+func monthBounds(day time.Time) (time.Time, time.Time) {
+	start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	return start, start.AddDate(0, 1, 0)
+}
@@ -0,0 +1,374 @@
+package calendar
+
+import (
+	"log"
+	"slices"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// expandOccurrences разворачивает повторяющиеся серии во вхождения,
+// пересекающиеся с [from, to). Обычные события возвращаются как есть.
+// Вхождения считаются в часовом поясе, который несет Start серии.
+func expandOccurrences(events []Event, from, to time.Time) []Event {
+	result := make([]Event, 0, len(events))
+	for _, event := range events {
+		if !event.IsRecurring() {
+			result = append(result, event)
+			continue
+		}
+
+		rule, err := ParseRRule(event.RRule)
+		if err != nil {
+			// Правило проверяется при сохранении, сюда попадают лишь поврежденные данные
+			log.Printf("skip series %s: %v", event.ID, err)
+			continue
+		}
+
+		rule.iterate(event.Start, from.Add(-event.Duration()), to, func(start time.Time) bool {
+			if !start.Before(to) {
+				return false
+			}
+			if isExcluded(event, start) {
+				return true
+			}
+			if occurrence := occurrenceAt(event, start); occurrence.Overlaps(from, to) {
+				result = append(result, occurrence)
+			}
+			return true
+		})
+	}
+
+	return result
+}
+
+// occurrenceAt возвращает вхождение серии, начинающееся в start.
+// Событие на весь день сохраняет длительность в календарных днях.
+func occurrenceAt(series Event, start time.Time) Event {
+	occurrence := series
+	occurrence.SeriesID = series.ID
+	occurrence.OccurrenceStart = &start
+	occurrence.Start = start
+
+	if series.AllDay {
+		days := int(series.Duration().Round(24*time.Hour) / (24 * time.Hour))
+		occurrence.End = start.AddDate(0, 0, days)
+	} else {
+		occurrence.End = start.Add(series.Duration())
+	}
+
+	return occurrence
+}
+
+// hasOccurrence проверяет, есть ли у серии вхождение, пересекающееся с [from, to)
+func hasOccurrence(series Event, from, to time.Time) bool {
+	rule, err := ParseRRule(series.RRule)
+	if err != nil {
+		return false
+	}
+
+	found := false
+	rule.iterate(series.Start, from.Add(-series.Duration()), to, func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		found = !isExcluded(series, start) && occurrenceAt(series, start).Overlaps(from, to)
+		return !found
+	})
+
+	return found
+}
+
+// isExcluded проверяет, отменено ли вхождение серии, начинающееся в start
+func isExcluded(series Event, start time.Time) bool {
+	for _, exDate := range series.ExDates {
+		if exDate.Equal(start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// occursAt проверяет, что у серии есть неотмененное вхождение, начинающееся в at
+func occursAt(series Event, rule *RRule, at time.Time) bool {
+	found := false
+	rule.iterate(series.Start, at, at, func(start time.Time) bool {
+		found = start.Equal(at)
+		return start.Before(at)
+	})
+
+	return found && !isExcluded(series, at)
+}
+
+// truncateSeries обрывает серию перед вхождением at и возвращает ее вместе
+// с числом оставшихся в ней вхождений. COUNT учитывает и отмененные вхождения.
+func truncateSeries(series Event, rule *RRule, at time.Time) (Event, int) {
+	before := 0
+	rule.iterate(series.Start, series.Start, at, func(start time.Time) bool {
+		if !start.Before(at) {
+			return false
+		}
+		before++
+		return true
+	})
+
+	truncated := *rule
+	if truncated.Count > 0 {
+		truncated.Count = before
+	} else {
+		truncated.Until = at.Add(-time.Second).UTC()
+		truncated.untilUTC, truncated.untilDate = true, false
+	}
+	series.RRule = truncated.String()
+
+	var exDates []time.Time
+	for _, exDate := range series.ExDates {
+		if exDate.Before(at) {
+			exDates = append(exDates, exDate)
+		}
+	}
+	series.ExDates = exDates
+
+	return series, before
+}
+
+// Scope область изменения повторяющегося события
+type Scope string
+
+const (
+	// ScopeAll вся серия
+	ScopeAll Scope = "all"
+	// ScopeThis одно вхождение
+	ScopeThis Scope = "this"
+	// ScopeFollowing вхождение и все последующие
+	ScopeFollowing Scope = "following"
+)
+
+// ScheduleChanged сообщает, что изменение серии old сдвигает ее вхождения:
+// меняется начало или правило повторения. Отмененные и измененные вхождения
+// относятся к прежнему расписанию, поэтому хранилище удаляет их вместе с изменением.
+func ScheduleChanged(old, updated Event) bool {
+	return old.IsRecurring() && (!old.Start.Equal(updated.Start) || old.RRule != updated.RRule)
+}
+
+// ParseScope разбирает область изменения. Пустое значение означает всю серию.
+func ParseScope(value string) (Scope, error) {
+	switch Scope(value) {
+	case "", ScopeAll:
+		return ScopeAll, nil
+	case ScopeThis, ScopeFollowing:
+		return Scope(value), nil
+	default:
+		return "", pkg.ErrInvalidScope
+	}
+}
+
+// UpdateOccurrences изменяет вхождение серии event.ID, начинающееся в occurrence,
+// в заданной области и возвращает итоговое событие. Для ScopeThis создается
+// измененное вхождение, для ScopeFollowing серия разделяется на две.
+// Обычное событие обновляется целиком при любой области. Изменять можно
+// только серии пользователя event.UserID. Если event.Version не 0,
+// серия должна иметь эту версию.
+func (s *Service) UpdateOccurrences(event Event, occurrence time.Time, scope Scope) (Event, error) {
+	series, rule, err := s.seriesAt(event.UserID, event.ID, event.Version, occurrence, scope)
+	if err != nil {
+		return Event{}, err
+	}
+	if rule == nil || (scope == ScopeFollowing && occurrence.Equal(series.Start)) {
+		if err := s.UpdateEvent(event); err != nil {
+			return Event{}, err
+		}
+		return s.GetEvent(event.ID)
+	}
+
+	if err := validateTimes(event); err != nil {
+		return Event{}, err
+	}
+	if event.TimeZone == "" {
+		event.TimeZone = series.TimeZone
+	}
+	if _, err := LoadLocation(event.TimeZone); err != nil {
+		return Event{}, err
+	}
+
+	var detached Event
+	if scope == ScopeThis {
+		series.ExDates = append(slices.Clip(series.ExDates), occurrence)
+		detached = Event{
+			UserID:          series.UserID,
+			Start:           event.Start,
+			End:             event.End,
+			AllDay:          event.AllDay,
+			TimeZone:        event.TimeZone,
+			Title:           event.Title,
+			SeriesID:        series.ID,
+			OccurrenceStart: &occurrence,
+		}
+	} else {
+		detached = event
+		detached.ID = ""
+		detached.UserID = series.UserID
+		if err := normalizeRRule(&detached); err != nil {
+			return Event{}, err
+		}
+
+		truncated, before := truncateSeries(series, rule, occurrence)
+		detached = continueSeries(series, rule, detached, occurrence, before)
+		series = truncated
+	}
+
+	detached = inTimeZone(detached)
+	created, err := s.repo.UpdateSeries(series, &detached)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(created), nil
+}
+
+// DeleteOccurrences удаляет вхождение серии id пользователя userID, начинающееся
+// в occurrence, в заданной области. Обычное событие удаляется целиком при любой
+// области. Если version не 0, серия должна иметь эту версию.
+func (s *Service) DeleteOccurrences(userID int, id EventID, version int, occurrence time.Time, scope Scope) error {
+	series, rule, err := s.seriesAt(userID, id, version, occurrence, scope)
+	if err != nil {
+		return err
+	}
+	if rule == nil || (scope == ScopeFollowing && occurrence.Equal(series.Start)) {
+		return s.repo.DeleteEvent(userID, id, series.Version)
+	}
+
+	if scope == ScopeThis {
+		series.ExDates = append(slices.Clip(series.ExDates), occurrence)
+	} else {
+		series, _ = truncateSeries(series, rule, occurrence)
+	}
+
+	_, err = s.repo.UpdateSeries(series, nil)
+	return err
+}
+
+// seriesAt загружает событие пользователя userID ожидаемой версии и проверяет,
+// что occurrence — его вхождение.
+// Для обычного события и области ScopeAll правило не возвращается.
+// Хранилище еще раз сверяет версию загруженной серии при сохранении,
+// поэтому изменение, сделанное между чтением и записью, не потеряется.
+func (s *Service) seriesAt(userID int, id EventID, version int, occurrence time.Time, scope Scope) (Event, *RRule, error) {
+	series, err := s.GetUserEvent(userID, id)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if err := series.CheckVersion(version); err != nil {
+		return Event{}, nil, err
+	}
+	if scope == ScopeAll || !series.IsRecurring() {
+		return series, nil, nil
+	}
+
+	rule, err := ParseRRule(series.RRule)
+	if err != nil {
+		return Event{}, nil, err
+	}
+	if !occursAt(series, rule, occurrence) {
+		return Event{}, nil, pkg.ErrOccurrenceNotFound
+	}
+
+	return series, rule, nil
+}
+
+// continueSeries готовит продолжение серии, отделенное от вхождения at.
+// Если правило не изменилось, продолжение получает оставшийся COUNT,
+// а отмененные вхождения переносятся со сдвигом начала.
+func continueSeries(series Event, rule *RRule, next Event, at time.Time, before int) Event {
+	if next.RRule == rule.String() && rule.Count > 0 {
+		remaining := *rule
+		remaining.Count = rule.Count - before
+		next.RRule = remaining.String()
+	}
+
+	shift := next.Start.Sub(at)
+	next.ExDates = nil
+	for _, exDate := range series.ExDates {
+		if !exDate.Before(at) {
+			next.ExDates = append(next.ExDates, exDate.Add(shift))
+		}
+	}
+
+	return next
+}
+
+// ReplaceSeries одним изменением заменяет событие series.ID пользователя series.UserID
+// и все его измененные вхождения на overrides. У каждого вхождения задан
+// OccurrenceStart — начало заменяемого вхождения серии, которое становится
+// отмененным. Если series.Version не 0, событие должно иметь эту версию.
+func (s *Service) ReplaceSeries(series Event, overrides []Event) error {
+	series, err := s.prepareUpdate(series)
+	if err != nil {
+		return err
+	}
+	if len(overrides) == 0 {
+		return s.repo.ReplaceSeries(series, nil)
+	}
+	if !series.IsRecurring() {
+		return pkg.ErrOccurrenceNotFound
+	}
+
+	rule, err := ParseRRule(series.RRule)
+	if err != nil {
+		return err
+	}
+
+	var exDates []time.Time
+	for _, exDate := range series.ExDates {
+		if !slices.ContainsFunc(overrides, func(override Event) bool {
+			return override.OccurrenceStart != nil && override.OccurrenceStart.Equal(exDate)
+		}) {
+			exDates = append(exDates, exDate)
+		}
+	}
+	series.ExDates = exDates
+
+	detached := make([]Event, 0, len(overrides))
+	for _, override := range overrides {
+		occurrence := override.OccurrenceStart
+		if occurrence == nil || !occursAt(series, rule, *occurrence) {
+			return pkg.ErrOccurrenceNotFound
+		}
+		if err := validateTimes(override); err != nil {
+			return err
+		}
+		if override.TimeZone == "" {
+			override.TimeZone = series.TimeZone
+		}
+		if _, err := LoadLocation(override.TimeZone); err != nil {
+			return err
+		}
+
+		series.ExDates = append(series.ExDates, *occurrence)
+		detached = append(detached, inTimeZone(Event{
+			UserID:          series.UserID,
+			Start:           override.Start,
+			End:             override.End,
+			AllDay:          override.AllDay,
+			TimeZone:        override.TimeZone,
+			Title:           override.Title,
+			SeriesID:        series.ID,
+			OccurrenceStart: occurrence,
+		}))
+	}
+
+	return s.repo.ReplaceSeries(series, detached)
+}
+
+// SetExDates заменяет отмененные вхождения серии id пользователя userID
+func (s *Service) SetExDates(userID int, id EventID, exDates []time.Time) error {
+	series, err := s.GetUserEvent(userID, id)
+	if err != nil {
+		return err
+	}
+
+	series.ExDates = exDates
+	_, err = s.repo.UpdateSeries(series, nil)
+	return err
+}
@@ -0,0 +1,61 @@
+package calendar
+
+import "time"
+
+// EventRepository описывает хранилище событий, от которого зависит Service.
+// Методы изменения принимают пользователя, от имени которого выполняется
+// изменение; событие другого пользователя для них не существует
+// и возвращается pkg.ErrEventNotFound.
+type EventRepository interface {
+	// CreateEvent сохраняет новое событие и возвращает его с присвоенным ID.
+	// Если у пользователя уже есть событие с тем же UID, возвращается pkg.ErrDuplicateUID.
+	CreateEvent(event Event) (Event, error)
+	// UpdateEvent обновляет время и название события с ID event.ID пользователя event.UserID
+	// по правилам ApplyUpdate. Если изменилось расписание серии (см. ScheduleChanged),
+	// ее измененные вхождения удаляются в том же изменении. Если event.Version не 0, версия сверяется с сохраненной, при расхождении
+	// возвращается pkg.ErrVersionMismatch. Так же сверяют версию остальные методы изменения.
+	UpdateEvent(event Event) error
+	// PatchEvent атомарно применяет патч к текущему состоянию события пользователя
+	// userID и возвращает результат. Измененные вхождения удаляются, как в UpdateEvent.
+	PatchEvent(userID int, id EventID, patch EventPatch) (Event, error)
+	// DeleteEvent удаляет событие пользователя userID версии version,
+	// а для серии — и ее измененные вхождения
+	DeleteEvent(userID int, id EventID, version int) error
+	// UpdateSeries атомарно заменяет RRule и ExDates серии пользователя series.UserID
+	// версии series.Version и создает detached, если он задан
+	UpdateSeries(series Event, detached *Event) (Event, error)
+	// ReplaceSeries атомарно обновляет событие пользователя series.UserID версии
+	// series.Version по правилам ApplyUpdate, заменяет его ExDates на series.ExDates,
+	// удаляет его измененные вхождения и создает overrides
+	ReplaceSeries(series Event, overrides []Event) error
+	// ApplyBatch выполняет операции по порядку как одно обращение к хранилищу.
+	// В атомарном режиме при ошибке не применяется ни одна операция и возвращается
+	// *BatchError, иначе ошибки операций возвращаются в результатах.
+	ApplyBatch(ops []BatchOp, atomic bool) ([]BatchResult, error)
+	// GetEvent возвращает событие по ID
+	GetEvent(id EventID) (Event, error)
+	// GetEventByUID возвращает событие пользователя по UID
+	GetEventByUID(userID int, uid string) (Event, error)
+	// GetOverrides возвращает измененные вхождения серии seriesID пользователя userID
+	GetOverrides(userID int, seriesID EventID) ([]Event, error)
+	// GetEventsInRange возвращает события пользователя, пересекающиеся с полуинтервалом [from, to),
+	// и повторяющиеся серии, начавшиеся до to. Серии разворачивает Service.
+	GetEventsInRange(userID int, from, to time.Time) ([]Event, error)
+}
+
+// UserRepository описывает хранилище настроек пользователей
+type UserRepository interface {
+	// GetTimeZone возвращает часовой пояс пользователя по умолчанию или "", если он не задан
+	GetTimeZone(userID int) (string, error)
+	// SetTimeZone задает часовой пояс пользователя по умолчанию
+	SetTimeZone(userID int, timeZone string) error
+}
+
+// Repository хранилище, от которого зависит Service
+type Repository interface {
+	EventRepository
+	UserRepository
+}
+
+// Проверяем, что Calendar реализует Repository
+var _ Repository = (*Calendar)(nil)
@@ -0,0 +1,499 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// Frequency частота повторения правила RRULE
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum элемент BYDAY: день недели с необязательным порядковым номером
+// в месяце (1MO — первый понедельник, -1FR — последняя пятница, 0 — любой)
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// RRule правило повторения по RFC 5545. Поддерживаются FREQ, INTERVAL,
+// BYDAY, BYMONTHDAY, COUNT и UNTIL; неделя начинается с понедельника.
+type RRule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	Count      int
+	// Until граница повторений. Если untilUTC не выставлен, это настенное время
+	// в часовом поясе события, записанное в UTC.
+	Until    time.Time
+	untilUTC bool
+	// untilDate UNTIL задан датой без времени
+	untilDate bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// ParseRRule разбирает правило вида FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10.
+// Префикс RRULE: допускается.
+func ParseRRule(value string) (*RRule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, invalidRRule("rule is empty")
+	}
+
+	rule := &RRule{Interval: 1}
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		val = strings.ToUpper(strings.TrimSpace(val))
+		if !ok || val == "" {
+			return nil, invalidRRule("malformed part %q", part)
+		}
+		if seen[key] {
+			return nil, invalidRRule("duplicate %s", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			err = rule.parseFreq(val)
+		case "INTERVAL":
+			rule.Interval, err = parsePositive(key, val)
+		case "COUNT":
+			rule.Count, err = parsePositive(key, val)
+		case "UNTIL":
+			err = rule.parseUntil(val)
+		case "BYDAY":
+			err = rule.parseByDay(val)
+		case "BYMONTHDAY":
+			err = rule.parseByMonthDay(val)
+		case "WKST":
+			if val != "MO" {
+				err = invalidRRule("only WKST=MO is supported")
+			}
+		default:
+			err = invalidRRule("unsupported part %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, invalidRRule("FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, invalidRRule("COUNT and UNTIL are mutually exclusive")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return nil, invalidRRule("ordinal BYDAY is only allowed with MONTHLY or YEARLY")
+		}
+	}
+
+	return rule, nil
+}
+
+// String возвращает правило в каноническом виде RFC 5545
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = weekdayNames[day.Day]
+			if day.N != 0 {
+				days[i] = strconv.Itoa(day.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		switch {
+		case r.untilDate:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		case r.untilUTC:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405Z"))
+		default:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		}
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// ResolveUntil переводит UNTIL в форму, которую RFC 5545 требует для DTSTART
+// события: дату для событий на весь день и время UTC для остальных
+func (r *RRule) ResolveUntil(loc *time.Location, allDay bool) {
+	if r.Until.IsZero() {
+		return
+	}
+
+	until := r.until(loc)
+	if allDay {
+		y, m, d := until.Date()
+		r.Until = time.Date(y, m, d, 23, 59, 59, 0, time.UTC)
+		r.untilUTC, r.untilDate = false, true
+		return
+	}
+
+	r.Until = until.UTC()
+	r.untilUTC, r.untilDate = true, false
+}
+
+// iterate передает в fn начала вхождений серии с первым вхождением dtstart
+// в порядке возрастания, пока fn возвращает true. Вхождения раньше skipBefore
+// могут быть пропущены, если правило не ограничено COUNT. Перебор
+// прекращается на периоде, начинающемся после stopAfter.
+func (r *RRule) iterate(dtstart, skipBefore, stopAfter time.Time, fn func(time.Time) bool) {
+	loc := dtstart.Location()
+	until := r.until(loc)
+
+	n := 0
+	// COUNT требует считать вхождения с самого начала серии
+	if r.Count == 0 && skipBefore.After(dtstart) {
+		n = (r.unitsBetween(dtstart, skipBefore)/r.Interval - 1) * r.Interval
+		if n < 0 {
+			n = 0
+		}
+	}
+
+	count := 0
+	for ; ; n += r.Interval {
+		period := r.periodStart(dtstart, n)
+		if period.After(stopAfter) {
+			return
+		}
+
+		for _, start := range r.candidates(dtstart, period) {
+			if start.Before(dtstart) {
+				continue
+			}
+			if !until.IsZero() && start.After(until) {
+				return
+			}
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+			count++
+
+			if !fn(start) {
+				return
+			}
+		}
+	}
+}
+
+// until возвращает границу UNTIL в часовом поясе события
+func (r *RRule) until(loc *time.Location) time.Time {
+	if r.Until.IsZero() || r.untilUTC {
+		return r.Until
+	}
+
+	u := r.Until
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+}
+
+// periodStart возвращает начало n-го периода частоты, считая от периода dtstart
+func (r *RRule) periodStart(dtstart time.Time, n int) time.Time {
+	y, m, d := dtstart.Date()
+	loc := dtstart.Location()
+
+	switch r.Freq {
+	case Daily:
+		return time.Date(y, m, d+n, 0, 0, 0, 0, loc)
+	case Weekly:
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset+7*n, 0, 0, 0, 0, loc)
+	case Monthly:
+		return time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y+n, time.January, 1, 0, 0, 0, 0, loc)
+	}
+}
+
+// unitsBetween возвращает число целых периодов частоты от dtstart до t
+func (r *RRule) unitsBetween(dtstart, t time.Time) int {
+	t = t.In(dtstart.Location())
+
+	switch r.Freq {
+	case Daily:
+		return int(t.Sub(dtstart).Hours() / 24)
+	case Weekly:
+		return int(t.Sub(dtstart).Hours() / (24 * 7))
+	case Monthly:
+		return (t.Year()-dtstart.Year())*12 + int(t.Month()-dtstart.Month())
+	default:
+		return t.Year() - dtstart.Year()
+	}
+}
+
+// candidates возвращает упорядоченные начала вхождений внутри периода
+func (r *RRule) candidates(dtstart, period time.Time) []time.Time {
+	var days []time.Time
+
+	switch r.Freq {
+	case Daily:
+		days = []time.Time{period}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			days = []time.Time{period.AddDate(0, 0, (int(dtstart.Weekday())+6)%7)}
+		}
+		for _, day := range r.ByDay {
+			days = append(days, period.AddDate(0, 0, (int(day.Day)+6)%7))
+		}
+	case Monthly:
+		days = r.monthDays(dtstart, period.Year(), period.Month())
+	case Yearly:
+		days = r.monthDays(dtstart, period.Year(), dtstart.Month())
+	}
+
+	h, m, s := dtstart.Clock()
+	result := make([]time.Time, 0, len(days))
+	for _, day := range days {
+		if !r.matchesFilters(day) {
+			continue
+		}
+		y, mo, d := day.Date()
+		result = append(result, time.Date(y, mo, d, h, m, s, dtstart.Nanosecond(), dtstart.Location()))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return dedupe(result)
+}
+
+// monthDays возвращает дни месяца, подходящие под BYMONTHDAY и BYDAY
+func (r *RRule) monthDays(dtstart time.Time, year int, month time.Month) []time.Time {
+	loc := dtstart.Location()
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	length := first.AddDate(0, 1, -1).Day()
+
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = length + d + 1
+			}
+			if d >= 1 && d <= length {
+				days = append(days, time.Date(year, month, d, 0, 0, 0, 0, loc))
+			}
+		}
+	case len(r.ByDay) > 0:
+		for d := 1; d <= length; d++ {
+			days = append(days, time.Date(year, month, d, 0, 0, 0, 0, loc))
+		}
+	default:
+		// Месяцы без нужного числа (например, 31-го) пропускаются
+		if d := dtstart.Day(); d <= length {
+			days = append(days, time.Date(year, month, d, 0, 0, 0, 0, loc))
+		}
+	}
+
+	if len(r.ByDay) == 0 {
+		return days
+	}
+
+	filtered := days[:0]
+	for _, day := range days {
+		if matchesMonthlyByDay(r.ByDay, day, length) {
+			filtered = append(filtered, day)
+		}
+	}
+	return filtered
+}
+
+// matchesFilters применяет BYDAY и BYMONTHDAY как фильтры для частот,
+// у которых они не участвуют в построении кандидатов
+func (r *RRule) matchesFilters(day time.Time) bool {
+	if r.Freq == Daily && len(r.ByDay) > 0 {
+		found := false
+		for _, wd := range r.ByDay {
+			if wd.Day == day.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if (r.Freq == Daily || r.Freq == Weekly) && len(r.ByMonthDay) > 0 {
+		length := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+		found := false
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = length + d + 1
+			}
+			if d == day.Day() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesMonthlyByDay проверяет день месяца по BYDAY с порядковыми номерами
+func matchesMonthlyByDay(byDay []WeekdayNum, day time.Time, length int) bool {
+	for _, wd := range byDay {
+		if wd.Day != day.Weekday() {
+			continue
+		}
+		if wd.N == 0 {
+			return true
+		}
+
+		nth := (day.Day()-1)/7 + 1
+		nthFromEnd := -((length-day.Day())/7 + 1)
+		if wd.N == nth || wd.N == nthFromEnd {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *RRule) parseFreq(val string) error {
+	switch Frequency(val) {
+	case Daily, Weekly, Monthly, Yearly:
+		r.Freq = Frequency(val)
+		return nil
+	default:
+		return invalidRRule("unsupported FREQ %s", val)
+	}
+}
+
+func (r *RRule) parseUntil(val string) error {
+	layouts := []struct {
+		layout string
+		utc    bool
+		date   bool
+	}{
+		{"20060102T150405Z", true, false},
+		{"20060102T150405", false, false},
+		{"20060102", false, true},
+	}
+
+	for _, l := range layouts {
+		t, err := time.Parse(l.layout, val)
+		if err != nil {
+			continue
+		}
+		r.Until, r.untilUTC, r.untilDate = t, l.utc, l.date
+		if l.date {
+			// Дата включается целиком
+			r.Until = t.Add(24*time.Hour - time.Second)
+		}
+		return nil
+	}
+
+	return invalidRRule("invalid UNTIL %s", val)
+}
+
+func (r *RRule) parseByDay(val string) error {
+	for _, item := range strings.Split(val, ",") {
+		if len(item) < 2 {
+			return invalidRRule("invalid BYDAY %s", item)
+		}
+
+		day, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return invalidRRule("invalid BYDAY %s", item)
+		}
+
+		n := 0
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			n, err = strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return invalidRRule("invalid BYDAY %s", item)
+			}
+		}
+
+		r.ByDay = append(r.ByDay, WeekdayNum{N: n, Day: day})
+	}
+
+	return nil
+}
+
+func (r *RRule) parseByMonthDay(val string) error {
+	for _, item := range strings.Split(val, ",") {
+		d, err := strconv.Atoi(item)
+		if err != nil || d == 0 || d < -31 || d > 31 {
+			return invalidRRule("invalid BYMONTHDAY %s", item)
+		}
+		r.ByMonthDay = append(r.ByMonthDay, d)
+	}
+
+	return nil
+}
+
+func parsePositive(key, val string) (int, error) {
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		return 0, invalidRRule("%s must be a positive integer", key)
+	}
+
+	return n, nil
+}
+
+func invalidRRule(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", pkg.ErrInvalidRecurrence, fmt.Sprintf(format, args...))
+}
+
+func dedupe(times []time.Time) []time.Time {
+	if len(times) < 2 {
+		return times
+	}
+
+	result := times[:1]
+	for _, t := range times[1:] {
+		if !t.Equal(result[len(result)-1]) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
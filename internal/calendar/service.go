@@ -1,11 +1,282 @@
 package calendar
 
+import (
+	"sort"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// Границы выборки без ограничений по времени
+var (
+	minTime = time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// Service предоставляет операции над событиями поверх выбранного хранилища
 type Service struct {
-	Calendar *Calendar
+	repo Repository
 }
 
-func NewService() *Service {
+func NewService(repo Repository) *Service {
 	return &Service{
-		Calendar: NewCalendar(),
+		repo: repo,
+	}
+}
+
+// CreateEvent создает новое событие. Если часовой пояс события не задан,
+// используется часовой пояс пользователя по умолчанию.
+func (s *Service) CreateEvent(event Event) (Event, error) {
+	event, err := s.prepareCreate(event)
+	if err != nil {
+		return Event{}, err
+	}
+
+	created, err := s.repo.CreateEvent(event)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(created), nil
+}
+
+// UpdateEvent обновляет существующее событие пользователя event.UserID.
+// Если часовой пояс не задан, сохраняется текущий часовой пояс события.
+func (s *Service) UpdateEvent(event Event) error {
+	event, err := s.prepareUpdate(event)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.UpdateEvent(event)
+}
+
+// prepareCreate проверяет новое событие и задает ему часовой пояс пользователя,
+// если пояс не указан
+func (s *Service) prepareCreate(event Event) (Event, error) {
+	if err := validateTimes(event); err != nil {
+		return Event{}, err
+	}
+	if err := normalizeRRule(&event); err != nil {
+		return Event{}, err
+	}
+
+	if event.TimeZone == "" {
+		timeZone, err := s.TimeZone(event.UserID)
+		if err != nil {
+			return Event{}, err
+		}
+		event.TimeZone = timeZone
+	}
+	if _, err := LoadLocation(event.TimeZone); err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(event), nil
+}
+
+// prepareUpdate проверяет изменение события и сохраняет текущий
+// часовой пояс события, если новый не указан
+func (s *Service) prepareUpdate(event Event) (Event, error) {
+	if err := validateTimes(event); err != nil {
+		return Event{}, err
+	}
+	if err := normalizeRRule(&event); err != nil {
+		return Event{}, err
+	}
+
+	if event.TimeZone == "" {
+		existing, err := s.GetUserEvent(event.UserID, event.ID)
+		if err != nil {
+			return Event{}, err
+		}
+		event.TimeZone = existing.TimeZone
+	}
+	if event.TimeZone == "" {
+		event.TimeZone = DefaultTimeZone
+	}
+	if _, err := LoadLocation(event.TimeZone); err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(event), nil
+}
+
+// PatchEvent изменяет только заданные в патче поля события пользователя userID
+func (s *Service) PatchEvent(userID int, id EventID, patch EventPatch) (Event, error) {
+	event, err := s.repo.PatchEvent(userID, id, patch)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(event), nil
+}
+
+// DeleteEvent удаляет событие пользователя userID. Если version не 0,
+// событие должно иметь эту версию.
+func (s *Service) DeleteEvent(userID int, id EventID, version int) error {
+	return s.repo.DeleteEvent(userID, id, version)
+}
+
+// GetEvent возвращает событие по ID
+func (s *Service) GetEvent(id EventID) (Event, error) {
+	event, err := s.repo.GetEvent(id)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return inTimeZone(event), nil
+}
+
+// GetUserEvent возвращает событие пользователя userID по ID. Событие другого
+// пользователя считается отсутствующим, чтобы по ответу нельзя было узнать
+// о его существовании.
+func (s *Service) GetUserEvent(userID int, id EventID) (Event, error) {
+	event, err := s.GetEvent(id)
+	if err != nil {
+		return Event{}, err
+	}
+	if event.UserID != userID {
+		return Event{}, pkg.ErrEventNotFound
+	}
+
+	return event, nil
+}
+
+// GetEventByUID возвращает событие пользователя по UID
+func (s *Service) GetEventByUID(userID int, uid string) (Event, error) {
+	event, err := s.repo.GetEventByUID(userID, uid)
+	if err != nil {
+		return Event{}, err
 	}
+
+	return inTimeZone(event), nil
+}
+
+// GetOverrides возвращает измененные вхождения серии seriesID пользователя userID,
+// упорядоченные по времени начала
+func (s *Service) GetOverrides(userID int, seriesID EventID) ([]Event, error) {
+	events, err := s.repo.GetOverrides(userID, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		events[i] = inTimeZone(events[i])
+	}
+	sortByStart(events)
+
+	return events, nil
+}
+
+// GetEventsInRange возвращает события пользователя, пересекающиеся с полуинтервалом
+// [from, to), упорядоченные по времени начала. Повторяющиеся серии
+// разворачиваются во вхождения.
+func (s *Service) GetEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	if !from.Before(to) {
+		return nil, pkg.ErrInvalidRange
+	}
+
+	events, err := s.repo.GetEventsInRange(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		events[i] = inTimeZone(events[i])
+	}
+	events = expandOccurrences(events, from, to)
+
+	// Не все хранилища гарантируют порядок
+	sortByStart(events)
+
+	return events, nil
+}
+
+// ListEvents возвращает сохраненные события пользователя, пересекающиеся
+// с [from, to), не разворачивая серии: серия возвращается вместе с правилом,
+// если у нее есть вхождение в интервале. Нулевые границы не ограничивают выборку.
+func (s *Service) ListEvents(userID int, from, to time.Time) ([]Event, error) {
+	if from.IsZero() {
+		from = minTime
+	}
+	if to.IsZero() {
+		to = maxTime
+	}
+	if !from.Before(to) {
+		return nil, pkg.ErrInvalidRange
+	}
+
+	events, err := s.repo.GetEventsInRange(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := events[:0]
+	for _, event := range events {
+		event = inTimeZone(event)
+		if !event.IsRecurring() || hasOccurrence(event, from, to) {
+			result = append(result, event)
+		}
+	}
+
+	sortByStart(result)
+
+	return result, nil
+}
+
+// sortByStart упорядочивает события по началу, а с одинаковым началом — по ID
+func sortByStart(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return events[i].ID.Compare(events[j].ID) < 0
+	})
+}
+
+// GetEventsForDay возвращает события на день. Границы дня считаются
+// в часовом поясе, который несет day.
+func (s *Service) GetEventsForDay(userID int, day time.Time) ([]Event, error) {
+	from, to := dayBounds(day)
+	return s.GetEventsInRange(userID, from, to)
+}
+
+// GetEventsForWeek возвращает события на неделю
+func (s *Service) GetEventsForWeek(userID int, day time.Time) ([]Event, error) {
+	from, to := weekBounds(day)
+	return s.GetEventsInRange(userID, from, to)
+}
+
+// GetEventsForMonth возвращает события на месяц
+func (s *Service) GetEventsForMonth(userID int, day time.Time) ([]Event, error) {
+	from, to := monthBounds(day)
+	return s.GetEventsInRange(userID, from, to)
+}
+
+// validateTimes проверяет, что событие не заканчивается раньше, чем начинается
+func validateTimes(event Event) error {
+	if event.End.Before(event.Start) {
+		return pkg.ErrEndBeforeStart
+	}
+
+	return nil
+}
+
+// normalizeRRule проверяет правило повторения и приводит его к каноническому виду.
+// Поля вхождения не хранятся: их заполняет разворачивание серии.
+func normalizeRRule(event *Event) error {
+	event.SeriesID = ""
+	event.OccurrenceStart = nil
+
+	if event.RRule == "" {
+		return nil
+	}
+
+	rule, err := ParseRRule(event.RRule)
+	if err != nil {
+		return err
+	}
+
+	event.RRule = rule.String()
+	return nil
 }
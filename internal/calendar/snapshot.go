@@ -0,0 +1,47 @@
+package calendar
+
+// State полное состояние календаря для снимка
+type State struct {
+	Events    []Event        `json:"events"`
+	TimeZones map[int]string `json:"time_zones,omitempty"`
+}
+
+// Checkpoint копирует состояние календаря и вызывает fn, удерживая блокировку
+// на запись. Пока выполняется fn, изменения в календарь и журнал не попадают,
+// поэтому снимок точно соответствует позиции журнала, зафиксированной в fn.
+func (c *Calendar) Checkpoint(fn func(State) error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := State{
+		Events:    make([]Event, 0, len(c.events)),
+		TimeZones: make(map[int]string, len(c.timeZones)),
+	}
+	for _, event := range c.events {
+		state.Events = append(state.Events, event)
+	}
+	for userID, timeZone := range c.timeZones {
+		state.TimeZones[userID] = timeZone
+	}
+
+	return fn(state)
+}
+
+// Restore заменяет состояние календаря снимком
+func (c *Calendar) Restore(state State) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.events = make(map[EventID]Event, len(state.Events))
+	c.index = make(userIndex)
+	c.uids = make(map[uidKey]EventID)
+	c.overrides = make(map[EventID]map[EventID]struct{})
+	for _, event := range state.Events {
+		c.events[event.ID] = event
+		c.link(event)
+	}
+	c.timeZones = make(map[int]string, len(state.TimeZones))
+	for userID, timeZone := range state.TimeZones {
+		c.timeZones[userID] = timeZone
+	}
+}
@@ -0,0 +1,88 @@
+package calendar
+
+import (
+	"sync"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// DefaultTimeZone часовой пояс пользователей, которые не задали свой
+const DefaultTimeZone = "UTC"
+
+// locations кэш загруженных часовых поясов: time.LoadLocation читает базу при каждом вызове
+var locations sync.Map
+
+// LoadLocation загружает часовой пояс по имени IANA. Пустое имя означает UTC.
+// "Local" не допускается, чтобы результат не зависел от настроек сервера.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return nil, pkg.ErrInvalidTimeZone
+	}
+
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, pkg.ErrInvalidTimeZone
+	}
+
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// TimeZone возвращает часовой пояс пользователя по умолчанию
+func (s *Service) TimeZone(userID int) (string, error) {
+	timeZone, err := s.repo.GetTimeZone(userID)
+	if err != nil {
+		return "", err
+	}
+	if timeZone == "" {
+		return DefaultTimeZone, nil
+	}
+
+	return timeZone, nil
+}
+
+// SetTimeZone задает часовой пояс пользователя по умолчанию
+func (s *Service) SetTimeZone(userID int, timeZone string) error {
+	if timeZone == "" {
+		return pkg.ErrInvalidTimeZone
+	}
+	if _, err := LoadLocation(timeZone); err != nil {
+		return err
+	}
+
+	return s.repo.SetTimeZone(userID, timeZone)
+}
+
+// Location возвращает часовой пояс, в котором считаются границы дней для
+// запроса пользователя: override, если он задан, иначе пояс пользователя
+func (s *Service) Location(userID int, override string) (*time.Location, error) {
+	if override != "" {
+		return LoadLocation(override)
+	}
+
+	timeZone, err := s.TimeZone(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadLocation(timeZone)
+}
+
+// inTimeZone переводит время события в его часовой пояс
+func inTimeZone(event Event) Event {
+	loc, err := LoadLocation(event.TimeZone)
+	if err != nil {
+		return event
+	}
+
+	event.Start = event.Start.In(loc)
+	event.End = event.End.In(loc)
+	return event
+}
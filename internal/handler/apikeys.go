@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"wb-calendar/internal/apikey"
+	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler администрирование ключей API
+type APIKeyHandler struct {
+	store *apikey.Store
+}
+
+func NewAPIKeyHandler(store *apikey.Store) *APIKeyHandler {
+	return &APIKeyHandler{store: store}
+}
+
+// IssueAPIKeyRequest запрос на выпуск ключа пользователю user_id.
+// scopes — области ключа: events:read и/или events:write.
+type IssueAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	UserID int      `json:"user_id"`
+	Scopes []string `json:"scopes"`
+}
+
+// IssuedAPIKey выпущенный ключ. Token возвращается только в ответе на выпуск.
+type IssuedAPIKey struct {
+	apikey.Key
+	Token string `json:"key"`
+}
+
+// RegisterRoutes подключает управление ключами /admin/api_keys к группе r.
+// Маршруты доступны только администратору, см. middleware.RequireAdmin.
+func (h *APIKeyHandler) RegisterRoutes(r gin.IRouter) {
+	keys := r.Group("/admin/api_keys", middleware.RequireAdmin())
+
+	keys.GET("", h.ListAPIKeysHandler())
+	keys.POST("", h.IssueAPIKeyHandler())
+	keys.DELETE("/:id", h.RevokeAPIKeyHandler())
+}
+
+// IssueAPIKeyHandler выпускает ключ и отвечает 201 Created с самим ключом
+func (h *APIKeyHandler) IssueAPIKeyHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req IssueAPIKeyRequest
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+			response.Error(ctx, pkg.ErrInvalidBody.WithMessage("invalid JSON request body"))
+			return
+		}
+
+		key, token, err := h.store.Issue(req.Name, req.UserID, req.Scopes)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Header("Cache-Control", "no-store")
+		response.JSONResultStatus(ctx, http.StatusCreated, IssuedAPIKey{Key: key, Token: token})
+	}
+}
+
+// ListAPIKeysHandler возвращает ключи без секретов, с query-параметром
+// user_id — только ключи этого пользователя
+func (h *APIKeyHandler) ListAPIKeysHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var userID int
+		if value := ctx.Query("user_id"); value != "" {
+			var err error
+			if userID, err = parseUserID(value); err != nil {
+				response.Error(ctx, err)
+				return
+			}
+		}
+
+		response.JSONResult(ctx, h.store.List(userID))
+	}
+}
+
+// RevokeAPIKeyHandler отзывает ключ и отвечает 204 No Content
+func (h *APIKeyHandler) RevokeAPIKeyHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, err := h.store.Revoke(ctx.Param("id")); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
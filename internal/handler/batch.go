@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchOperations ограничивает число операций в одном пакете
+const maxBatchOperations = 1000
+
+// BatchRequest тело запроса пакетного изменения событий. mode — atomic
+// (по умолчанию, все операции или ни одной) или best_effort.
+type BatchRequest struct {
+	Mode       string                  `json:"mode"`
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+// BatchOperationRequest операция пакета: op — create, update или delete.
+// Поля события те же, что в EventRequest. id и version нужны для update и delete,
+// user_id — только в /batch_events, в ресурсном API пользователь берется из пути.
+type BatchOperationRequest struct {
+	Op      string           `json:"op"`
+	ID      calendar.EventID `json:"id,omitempty"`
+	UserID  int              `json:"user_id,omitempty"`
+	Version int              `json:"version,omitempty"`
+	EventRequest
+}
+
+// BatchResponse результаты операций пакета в порядке запроса
+type BatchResponse struct {
+	Mode    calendar.BatchMode `json:"mode"`
+	Results []BatchItemResult  `json:"results"`
+}
+
+// BatchItemResult результат одной операции: событие или описание ошибки
+type BatchItemResult struct {
+	Index  int               `json:"index"`
+	Status int               `json:"status"`
+	Event  *calendar.Event   `json:"event,omitempty"`
+	Error  *response.Problem `json:"error,omitempty"`
+}
+
+// BatchEventsHandler выполняет пакет операций над событиями разных пользователей.
+// Пользователь операции create задается полем user_id. При аутентификации
+// токеном все операции относятся к пользователю из токена.
+func (h *CalendarHandler) BatchEventsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, _ := actingUser(ctx, 0)
+		h.applyBatch(ctx, userID)
+	}
+}
+
+// BatchUserEventsHandler выполняет пакет операций над событиями пользователя из пути.
+// События других пользователей считаются отсутствующими.
+func (h *CalendarHandler) BatchUserEventsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := pathUserID(ctx)
+		if !ok {
+			return
+		}
+
+		h.applyBatch(ctx, userID)
+	}
+}
+
+// applyBatch разбирает пакет и выполняет его. Если userID не 0, все операции
+// относятся к этому пользователю. В атомарном режиме ошибки разбора всех
+// операций возвращаются одним ответом, а ошибка выполнения — со статусом
+// этой ошибки; в режиме best_effort ошибки возвращаются в результатах операций.
+func (h *CalendarHandler) applyBatch(ctx *gin.Context, userID int) {
+	var req BatchRequest
+	if err := bindRequest(ctx, &req); err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	var v pkg.ValidationError
+	mode, err := calendar.ParseBatchMode(req.Mode)
+	if err != nil {
+		v.Add(err)
+	}
+	switch {
+	case len(req.Operations) == 0:
+		v.Add(pkg.Required("operations", "operations cannot be empty"))
+	case len(req.Operations) > maxBatchOperations:
+		v.Add(pkg.Invalid("operations", "at most "+strconv.Itoa(maxBatchOperations)+" operations are allowed"))
+	}
+	if err := v.Err(); err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	// Операция от имени другого пользователя отклоняет весь пакет в любом режиме
+	for i, item := range req.Operations {
+		if _, err := actingUser(ctx, item.UserID); err != nil {
+			response.Error(ctx, batchItemError(i, err))
+			return
+		}
+	}
+
+	ops := make([]calendar.BatchOp, 0, len(req.Operations))
+	indexes := make([]int, 0, len(req.Operations))
+	results := make([]BatchItemResult, len(req.Operations))
+	for i, item := range req.Operations {
+		op, err := h.parseBatchOp(item, userID)
+		if err != nil {
+			err = batchItemError(i, err)
+			if mode == calendar.BatchAtomic && errors.Is(err, pkg.ErrEventNotFound) {
+				// Отсутствующее событие — не ошибка поля, ответ как при выполнении пакета
+				response.Error(ctx, err)
+				return
+			}
+			if mode == calendar.BatchAtomic {
+				v.Add(err)
+			} else {
+				results[i] = h.batchItemResult(ctx, i, item.Op, calendar.BatchResult{Err: err})
+			}
+			continue
+		}
+
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+	if err := v.Err(); err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	stored, err := h.service.ApplyBatch(ops, mode)
+	var batchErr *calendar.BatchError
+	if errors.As(err, &batchErr) {
+		response.Error(ctx, batchItemError(indexes[batchErr.Index], batchErr.Err))
+		return
+	}
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	for j, result := range stored {
+		i := indexes[j]
+		if result.Err != nil {
+			result.Err = batchItemError(i, result.Err)
+		}
+		results[i] = h.batchItemResult(ctx, i, req.Operations[i].Op, result)
+	}
+
+	response.JSONResult(ctx, BatchResponse{Mode: mode, Results: results})
+}
+
+// parseBatchOp проверяет операцию пакета и переводит ее в calendar.BatchOp
+func (h *CalendarHandler) parseBatchOp(item BatchOperationRequest, userID int) (calendar.BatchOp, error) {
+	if userID == 0 {
+		userID = item.UserID
+	} else if item.UserID != 0 && item.UserID != userID {
+		return calendar.BatchOp{}, pkg.Invalid("user_id", "user_id does not match the path")
+	}
+
+	op := calendar.BatchOp{
+		Type:  calendar.BatchOpType(item.Op),
+		Event: calendar.Event{ID: item.ID, UserID: userID, Version: item.Version},
+	}
+
+	var v pkg.ValidationError
+	if userID <= 0 {
+		// Изменять и удалять можно только события пользователя операции
+		v.Add(pkg.Required("user_id", "user_id must be positive"))
+		return calendar.BatchOp{}, v.Err()
+	}
+
+	switch op.Type {
+	case calendar.BatchCreate:
+		loc, err := h.service.Location(userID, item.TimeZone)
+		if err != nil && !errors.Is(err, pkg.ErrInvalidTimeZone) {
+			return calendar.BatchOp{}, err
+		}
+		if op.Event, err = batchEvent(item, loc, err); err != nil {
+			return calendar.BatchOp{}, err
+		}
+		op.Event.UserID = userID
+	case calendar.BatchUpdate:
+		if err := checkEventID(item.ID); err != nil {
+			v.Add(err)
+			return calendar.BatchOp{}, v.Err()
+		}
+		// Даты без времени считаются в поясе из запроса или в текущем поясе события
+		timeZone := item.TimeZone
+		if timeZone == "" {
+			existing, err := h.service.GetUserEvent(userID, item.ID)
+			if err != nil {
+				return calendar.BatchOp{}, err
+			}
+			timeZone = existing.TimeZone
+		}
+		loc, err := calendar.LoadLocation(timeZone)
+		event, err := batchEvent(item, loc, err)
+		if err != nil {
+			return calendar.BatchOp{}, err
+		}
+		event.ID, event.UserID, event.Version = item.ID, userID, item.Version
+		op.Event = event
+	case calendar.BatchDelete:
+		if err := checkEventID(item.ID); err != nil {
+			v.Add(err)
+		}
+	default:
+		v.Add(pkg.Invalid("op", "op must be one of create, update, delete"))
+	}
+
+	return op, v.Err()
+}
+
+// batchEvent проверяет поля события операции и разбирает время в поясе loc
+func batchEvent(item BatchOperationRequest, loc *time.Location, locErr error) (calendar.Event, error) {
+	start, end, allDay, err := validateEventRequest(item.EventRequest, loc, locErr)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+
+	return calendar.Event{
+		Start:    start,
+		End:      end,
+		AllDay:   allDay,
+		TimeZone: loc.String(),
+		Title:    item.Title,
+		RRule:    item.RRule,
+	}, nil
+}
+
+// batchItemResult описывает результат операции i для ответа
+func (h *CalendarHandler) batchItemResult(ctx *gin.Context, i int, op string, result calendar.BatchResult) BatchItemResult {
+	if result.Err != nil {
+		problem := response.NewProblem(result.Err, ctx.Request.URL.Path)
+		return BatchItemResult{Index: i, Status: problem.Status, Error: &problem}
+	}
+
+	status := http.StatusOK
+	if calendar.BatchOpType(op) == calendar.BatchCreate {
+		status = http.StatusCreated
+	}
+
+	return BatchItemResult{Index: i, Status: status, Event: &result.Event}
+}
+
+// batchItemError относит ошибки операции i к полям operations[i]
+func batchItemError(i int, err error) error {
+	prefix := "operations[" + strconv.Itoa(i) + "]"
+	field := func(name string) string {
+		if name == "" {
+			return prefix
+		}
+		return prefix + "." + name
+	}
+
+	var validation *pkg.ValidationError
+	if errors.As(err, &validation) {
+		var v pkg.ValidationError
+		for _, e := range validation.Errors {
+			v.Errors = append(v.Errors, e.ForField(field(e.Field)))
+		}
+		return &v
+	}
+
+	var e *pkg.Error
+	if errors.As(err, &e) {
+		return e.ForField(field(e.Field)).WithMessage(err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventETag возвращает ETag события: его версию в кавычках
+func eventETag(event calendar.Event) string {
+	return `"` + strconv.Itoa(event.Version) + `"`
+}
+
+// setETag передает версию события в заголовке ETag
+func setETag(ctx *gin.Context, event calendar.Event) {
+	ctx.Header("ETag", eventETag(event))
+}
+
+// ifMatch возвращает версию, которую ожидает заголовок If-Match, или 0, если
+// заголовка нет или он равен "*". Заголовок сверяется с загруженным событием,
+// а хранилище еще раз сверяет версию под блокировкой, поэтому изменение,
+// сделанное после проверки, тоже приведет к pkg.ErrVersionMismatch.
+func ifMatch(ctx *gin.Context, event calendar.Event) (int, error) {
+	header := ctx.GetHeader("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return 0, nil
+	}
+	if !etagMatches(header, eventETag(event), false) {
+		return 0, pkg.ErrVersionMismatch
+	}
+
+	return event.Version, nil
+}
+
+// notModified отвечает 304 Not Modified, если If-None-Match совпадает с ETag события
+func notModified(ctx *gin.Context, event calendar.Event) bool {
+	header := ctx.GetHeader("If-None-Match")
+	if header == "" || !etagMatches(header, eventETag(event), true) {
+		return false
+	}
+
+	setETag(ctx, event)
+	ctx.Status(http.StatusNotModified)
+	return true
+}
+
+// etagMatches проверяет, есть ли etag в списке заголовка If-Match или If-None-Match.
+// При слабом сравнении префикс W/ игнорируется, при строгом слабые теги не совпадают.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,9 +2,13 @@ package handler
 
 import (
 	"encoding/json"
-	"net/http"
+	"errors"
+	"io"
+	"strconv"
 	"time"
 	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg"
 	"wb-calendar/pkg/response"
 
 	"github.com/gin-gonic/gin"
@@ -18,23 +22,49 @@ func NewCalendarHandler(service calendar.Service) *CalendarHandler {
 	return &CalendarHandler{service: service}
 }
 
-// CreateEventRequest структура для создания события
+// CreateEventRequest структура для создания события.
+// Время задается через start/end (RFC 3339) или устаревшим полем date (YYYY-MM-DD),
+// которое создает событие на весь день. Если time_zone не задан, используется
+// часовой пояс пользователя. rrule задает повторение по RFC 5545,
+// например FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10.
 type CreateEventRequest struct {
-	UserID int    `json:"user_id" form:"user_id"`
-	Date   string `json:"date" form:"date"`
-	Title  string `json:"title" form:"title"`
+	UserID   int    `json:"user_id" form:"user_id"`
+	Date     string `json:"date,omitempty" form:"date"`
+	Start    string `json:"start,omitempty" form:"start"`
+	End      string `json:"end,omitempty" form:"end"`
+	AllDay   bool   `json:"all_day,omitempty" form:"all_day"`
+	TimeZone string `json:"time_zone,omitempty" form:"time_zone"`
+	Title    string `json:"title" form:"title"`
+	RRule    string `json:"rrule,omitempty" form:"rrule"`
 }
 
-// UpdateEventRequest структура для обновления события
+// UpdateEventRequest структура для обновления события пользователя user_id.
+// Если time_zone не задан, сохраняется текущий часовой пояс события.
+// Пустой rrule превращает серию в одиночное событие.
+//
+// scope задает область изменения серии: all (по умолчанию), this или following.
+// Для this и following recurrence_id указывает начало изменяемого вхождения.
 type UpdateEventRequest struct {
-	ID    int    `json:"id" form:"id"`
-	Date  string `json:"date" form:"date"`
-	Title string `json:"title" form:"title"`
+	ID           calendar.EventID `json:"id" form:"id"`
+	UserID       int              `json:"user_id" form:"user_id"`
+	Date         string           `json:"date,omitempty" form:"date"`
+	Start        string           `json:"start,omitempty" form:"start"`
+	End          string           `json:"end,omitempty" form:"end"`
+	AllDay       bool             `json:"all_day,omitempty" form:"all_day"`
+	TimeZone     string           `json:"time_zone,omitempty" form:"time_zone"`
+	Title        string           `json:"title" form:"title"`
+	RRule        string           `json:"rrule,omitempty" form:"rrule"`
+	Scope        string           `json:"scope,omitempty" form:"scope"`
+	RecurrenceID string           `json:"recurrence_id,omitempty" form:"recurrence_id"`
 }
 
-// DeleteEventRequest структура для удаления события
+// DeleteEventRequest структура для удаления события пользователя user_id.
+// scope и recurrence_id имеют тот же смысл, что и при обновлении.
 type DeleteEventRequest struct {
-	ID int `json:"id" form:"id"`
+	ID           calendar.EventID `json:"id" form:"id"`
+	UserID       int              `json:"user_id" form:"user_id"`
+	Scope        string           `json:"scope,omitempty" form:"scope"`
+	RecurrenceID string           `json:"recurrence_id,omitempty" form:"recurrence_id"`
 }
 
 func (h *CalendarHandler) CreateEventHandler() gin.HandlerFunc {
@@ -42,39 +72,57 @@ func (h *CalendarHandler) CreateEventHandler() gin.HandlerFunc {
 		var req CreateEventRequest
 
 		// Поддерживаем оба формата: JSON и form
-		contentType := ctx.GetHeader("Content-Type")
-		if contentType == "application/json" {
-			if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid JSON request body")
-				return
-			}
-		} else {
-			if err := ctx.ShouldBind(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid form data")
-				return
-			}
+		if err := bindRequest(ctx, &req); err != nil {
+			response.Error(ctx, err)
+			return
 		}
 
-		// Валидация
-		if req.UserID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "user_id must be positive")
+		userID, err := actingUser(ctx, req.UserID)
+		if err != nil {
+			response.Error(ctx, err)
 			return
 		}
+		req.UserID = userID
+
+		// Валидация: ошибки всех полей возвращаются одним ответом
+		var v pkg.ValidationError
+		if req.UserID <= 0 {
+			v.Add(pkg.Invalid("user_id", "user_id must be positive"))
+		}
 		if req.Title == "" {
-			response.JSONError(ctx, http.StatusBadRequest, "title cannot be empty")
-			return
+			v.Add(pkg.Required("title", "title cannot be empty"))
 		}
 
-		// Парсинг даты
-		date, err := time.Parse("2006-01-02", req.Date)
+		loc, err := h.service.Location(req.UserID, req.TimeZone)
 		if err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
+			if !errors.Is(err, pkg.ErrInvalidTimeZone) {
+				response.Error(ctx, err)
+				return
+			}
+			v.Add(err)
+			loc = time.UTC
+		}
+
+		start, end, allDay, err := parseEventTimes(req.Date, req.Start, req.End, req.AllDay, loc)
+		if err != nil {
+			v.Add(err)
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		event, err := h.service.Calendar.CreateEvent(req.UserID, date, req.Title)
+		event, err := h.service.CreateEvent(calendar.Event{
+			UserID:   req.UserID,
+			Start:    start,
+			End:      end,
+			AllDay:   allDay,
+			TimeZone: loc.String(),
+			Title:    req.Title,
+			RRule:    req.RRule,
+		})
 		if err != nil {
-			response.JSONError(ctx, http.StatusInternalServerError, "failed to create event")
+			response.Error(ctx, err)
 			return
 		}
 
@@ -87,44 +135,96 @@ func (h *CalendarHandler) UpdateEventHandler() gin.HandlerFunc {
 		var req UpdateEventRequest
 
 		// Поддерживаем оба формата: JSON и form
-		contentType := ctx.GetHeader("Content-Type")
-		if contentType == "application/json" {
-			if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid JSON request body")
-				return
-			}
-		} else {
-			if err := ctx.ShouldBind(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid form data")
-				return
-			}
+		if err := bindRequest(ctx, &req); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		userID, err := actingUser(ctx, req.UserID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
 		}
 
 		// Валидация
-		if req.ID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "id must be positive")
+		var v pkg.ValidationError
+		existing, ok := h.requestEvent(ctx, userID, req.ID, &v)
+		if !ok {
 			return
 		}
 		if req.Title == "" {
-			response.JSONError(ctx, http.StatusBadRequest, "title cannot be empty")
+			v.Add(pkg.Required("title", "title cannot be empty"))
+		}
+		scope, err := calendar.ParseScope(req.Scope)
+		if err != nil {
+			v.Add(err)
+		}
+
+		// Даты без времени считаются в поясе из запроса или в текущем поясе события
+		timeZone := req.TimeZone
+		if timeZone == "" {
+			timeZone = existing.TimeZone
+		}
+
+		loc, err := calendar.LoadLocation(timeZone)
+		if err != nil {
+			v.Add(err)
+			loc = time.UTC
+		}
+
+		start, end, allDay, err := parseEventTimes(req.Date, req.Start, req.End, req.AllDay, loc)
+		if err != nil {
+			v.Add(err)
+		}
+
+		var occurrence time.Time
+		if scope != calendar.ScopeAll && existing.ID != "" {
+			if occurrence, err = h.parseOccurrence(existing.ID, req.RecurrenceID); err != nil {
+				v.Add(err)
+			}
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		// Парсинг даты
-		date, err := time.Parse("2006-01-02", req.Date)
+		version, err := ifMatch(ctx, existing)
 		if err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
+			response.Error(ctx, err)
 			return
 		}
 
-		if err := h.service.Calendar.UpdateEvent(req.ID, date, req.Title); err != nil {
-			if err.Error() == "event not found" {
-				response.JSONError(ctx, http.StatusServiceUnavailable, "event not found")
+		event := calendar.Event{
+			ID:       req.ID,
+			UserID:   userID,
+			Start:    start,
+			End:      end,
+			AllDay:   allDay,
+			TimeZone: loc.String(),
+			Title:    req.Title,
+			RRule:    req.RRule,
+			Version:  version,
+		}
+
+		if scope != calendar.ScopeAll {
+			updated, err := h.service.UpdateOccurrences(event, occurrence, scope)
+			if err != nil {
+				response.Error(ctx, err)
 				return
 			}
-			response.JSONError(ctx, http.StatusInternalServerError, "failed to update event")
+
+			setETag(ctx, updated)
+			response.JSONResult(ctx, updated)
+			return
+		}
+
+		if err := h.service.UpdateEvent(event); err != nil {
+			response.Error(ctx, err)
 			return
 		}
+		if updated, err := h.service.GetEvent(event.ID); err == nil {
+			setETag(ctx, updated)
+		}
 
 		response.JSONResult(ctx, "event updated successfully")
 	}
@@ -134,31 +234,52 @@ func (h *CalendarHandler) DeleteEventHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var req DeleteEventRequest
 
-		contentType := ctx.GetHeader("Content-Type")
-		if contentType == "application/json" {
-			if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid JSON request body")
-				return
-			}
-		} else {
-			if err := ctx.ShouldBind(&req); err != nil {
-				response.JSONError(ctx, http.StatusBadRequest, "invalid form data")
-				return
-			}
+		if err := bindRequest(ctx, &req); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		userID, err := actingUser(ctx, req.UserID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
 		}
 
 		// Валидация
-		if req.ID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "id must be positive")
+		var v pkg.ValidationError
+		existing, ok := h.requestEvent(ctx, userID, req.ID, &v)
+		if !ok {
 			return
 		}
+		scope, err := calendar.ParseScope(req.Scope)
+		if err != nil {
+			v.Add(err)
+		}
 
-		if err := h.service.Calendar.DeleteEvent(req.ID); err != nil {
-			if err.Error() == "event not found" {
-				response.JSONError(ctx, http.StatusServiceUnavailable, "event not found")
-				return
+		var occurrence time.Time
+		if scope != calendar.ScopeAll && existing.ID != "" {
+			if occurrence, err = h.parseOccurrence(existing.ID, req.RecurrenceID); err != nil {
+				v.Add(err)
 			}
-			response.JSONError(ctx, http.StatusInternalServerError, "failed to delete event")
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		version, err := ifMatch(ctx, existing)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if scope != calendar.ScopeAll {
+			err = h.service.DeleteOccurrences(userID, req.ID, version, occurrence, scope)
+		} else {
+			err = h.service.DeleteEvent(userID, req.ID, version)
+		}
+		if err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
@@ -167,100 +288,388 @@ func (h *CalendarHandler) DeleteEventHandler() gin.HandlerFunc {
 }
 
 func (h *CalendarHandler) GetEventsForDayHandler() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		var req struct {
-			UserID int    `json:"user_id"`
-			Date   string `json:"date"`
-		}
+	return h.eventsForPeriodHandler(h.service.GetEventsForDay)
+}
 
-		if err := ctx.BindJSON(&req); err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid request body")
+func (h *CalendarHandler) GetEventsForWeekHandler() gin.HandlerFunc {
+	return h.eventsForPeriodHandler(h.service.GetEventsForWeek)
+}
+
+func (h *CalendarHandler) GetEventsForMonthHandler() gin.HandlerFunc {
+	return h.eventsForPeriodHandler(h.service.GetEventsForMonth)
+}
+
+// GetEventsInRangeHandler возвращает события пользователя в полуинтервале [from, to).
+// Границы передаются в query-параметрах в формате RFC 3339 или YYYY-MM-DD;
+// даты считаются в часовом поясе tz или в поясе пользователя.
+func (h *CalendarHandler) GetEventsInRangeHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var v pkg.ValidationError
+		userID, err := requestUser(ctx, ctx.Query("user_id"))
+		if errors.Is(err, pkg.ErrForbidden) {
+			response.Error(ctx, err)
 			return
 		}
+		if err != nil {
+			v.Add(err)
+		}
 
-		if req.UserID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid user_id")
+		loc, err := h.queryLocation(ctx, userID, &v)
+		if err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		if req.Date == "" {
-			response.JSONError(ctx, http.StatusBadRequest, "date parameter is required")
+		if ctx.Query("from") == "" {
+			v.Add(pkg.Required("from", "from parameter is required"))
+		}
+		if ctx.Query("to") == "" {
+			v.Add(pkg.Required("to", "to parameter is required"))
+		}
+		from, to := parseRange(ctx, loc, &v)
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		day, err := time.Parse("2006-01-02", req.Date)
+		events, err := h.service.GetEventsInRange(userID, from, to)
 		if err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
+			response.Error(ctx, err)
 			return
 		}
 
-		events := h.service.Calendar.GetEventsForDay(req.UserID, day)
 		response.JSONResult(ctx, events)
 	}
 }
 
-func (h *CalendarHandler) GetEventsForWeekHandler() gin.HandlerFunc {
+// eventsForPeriodHandler общий обработчик для выборок за день, неделю и месяц.
+// user_id и date передаются в query-параметрах или, для совместимости, в JSON-теле.
+// Границы периода считаются в часовом поясе из query-параметра tz или в поясе пользователя.
+func (h *CalendarHandler) eventsForPeriodHandler(get func(userID int, day time.Time) ([]calendar.Event, error)) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var req struct {
-			UserID int    `json:"user_id"`
-			Date   string `json:"date"`
+		userID, date, err := bindPeriodRequest(ctx)
+		if errors.Is(err, pkg.ErrInvalidBody) || errors.Is(err, pkg.ErrForbidden) {
+			response.Error(ctx, err)
+			return
 		}
 
-		if err := ctx.BindJSON(&req); err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid request body")
-			return
+		var v pkg.ValidationError
+		if err != nil {
+			v.Add(err)
 		}
 
-		if req.UserID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid user_id")
+		loc, err := h.queryLocation(ctx, userID, &v)
+		if err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		if req.Date == "" {
-			response.JSONError(ctx, http.StatusBadRequest, "date parameter is required")
+		var day time.Time
+		if date != "" {
+			if day, err = time.ParseInLocation("2006-01-02", date, loc); err != nil {
+				v.Add(pkg.ErrInvalidDate.WithMessage("invalid date format, expected YYYY-MM-DD"))
+			}
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
 			return
 		}
 
-		day, err := time.Parse("2006-01-02", req.Date)
+		events, err := get(userID, day)
 		if err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
+			response.Error(ctx, err)
 			return
 		}
 
-		events := h.service.Calendar.GetEventsForWeek(req.UserID, day)
 		response.JSONResult(ctx, events)
 	}
 }
 
-func (h *CalendarHandler) GetEventsForMonthHandler() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		var req struct {
-			UserID int    `json:"user_id"`
-			Date   string `json:"date"`
+// bindRequest читает тело запроса в формате JSON или form
+func bindRequest(ctx *gin.Context, req any) error {
+	if ctx.GetHeader("Content-Type") == "application/json" {
+		if err := json.NewDecoder(ctx.Request.Body).Decode(req); err != nil {
+			return pkg.ErrInvalidBody.WithMessage("invalid JSON request body")
 		}
+		return nil
+	}
 
-		if err := ctx.BindJSON(&req); err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid request body")
-			return
+	if err := ctx.ShouldBind(req); err != nil {
+		return pkg.ErrInvalidBody.WithMessage("invalid form data")
+	}
+
+	return nil
+}
+
+// bindPeriodRequest читает user_id и date из query-параметров. Параметры,
+// которых нет в query, берутся из JSON-тела. Значения из обоих источников
+// проверяются одинаково: user_id в теле может быть числом или строкой.
+// Ошибки обоих параметров возвращаются вместе в pkg.ValidationError,
+// кроме несовпадения с пользователем из токена.
+func bindPeriodRequest(ctx *gin.Context) (int, string, error) {
+	userID, date := ctx.Query("user_id"), ctx.Query("date")
+
+	if (userID == "" || date == "") && ctx.Request.ContentLength != 0 {
+		var body struct {
+			UserID json.RawMessage `json:"user_id"`
+			Date   json.RawMessage `json:"date"`
+		}
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			return 0, "", pkg.ErrInvalidBody
 		}
+		if userID == "" {
+			userID = rawString(body.UserID)
+		}
+		if date == "" {
+			date = rawString(body.Date)
+		}
+	}
 
-		if req.UserID <= 0 {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid user_id")
-			return
+	var v pkg.ValidationError
+	id, err := requestUser(ctx, userID)
+	if errors.Is(err, pkg.ErrForbidden) {
+		return 0, "", err
+	}
+	if err != nil {
+		v.Add(err)
+	}
+	if date == "" {
+		v.Add(pkg.Required("date", "date parameter is required"))
+	}
+
+	return id, date, v.Err()
+}
+
+// parseUserID проверяет user_id из query-параметра или тела запроса
+func parseUserID(value string) (int, error) {
+	if value == "" {
+		return 0, pkg.Required("user_id", "user_id parameter is required")
+	}
+
+	userID, err := strconv.Atoi(value)
+	if err != nil || userID <= 0 {
+		return 0, pkg.Invalid("user_id", "invalid user_id")
+	}
+
+	return userID, nil
+}
+
+// checkEventID проверяет id из тела запроса: ULID или прежний целый ID
+func checkEventID(id calendar.EventID) error {
+	if id == "" {
+		return pkg.Invalid("id", "id is required")
+	}
+	_, err := calendar.ParseEventID(string(id))
+	return err
+}
+
+// requestEvent проверяет user_id и id из тела запроса и загружает событие
+// пользователя. Ошибки полей добавляются в v, тогда возвращается пустое событие.
+// Если события нет или оно принадлежит другому пользователю, отвечает 404
+// и возвращает false.
+func (h *CalendarHandler) requestEvent(ctx *gin.Context, userID int, id calendar.EventID, v *pkg.ValidationError) (calendar.Event, bool) {
+	if userID <= 0 {
+		v.Add(pkg.Invalid("user_id", "user_id must be positive"))
+	}
+	idErr := checkEventID(id)
+	if idErr != nil {
+		v.Add(idErr)
+	}
+	if userID <= 0 || idErr != nil {
+		return calendar.Event{}, true
+	}
+
+	event, err := h.service.GetUserEvent(userID, id)
+	if err != nil {
+		response.Error(ctx, err)
+		return calendar.Event{}, false
+	}
+
+	return event, true
+}
+
+// actingUser возвращает пользователя, от имени которого выполняется запрос.
+// Если клиент аутентифицирован токеном пользователя, используется пользователь
+// из токена: пустой requested заменяется им, а другой — отклоняется с 403.
+// Без такой аутентификации используется requested из запроса.
+func actingUser(ctx *gin.Context, requested int) (int, error) {
+	principal, ok := middleware.PrincipalFrom(ctx)
+	if !ok || principal.UserID == 0 {
+		return requested, nil
+	}
+	if requested != 0 && requested != principal.UserID {
+		return 0, pkg.ErrForbidden
+	}
+
+	return principal.UserID, nil
+}
+
+// requestUser разбирает user_id из query-параметра, пути или тела запроса
+// и проверяет его через actingUser. При аутентификации токеном user_id
+// можно не передавать.
+func requestUser(ctx *gin.Context, value string) (int, error) {
+	if value == "" {
+		if userID, _ := actingUser(ctx, 0); userID != 0 {
+			return userID, nil
 		}
+	}
 
-		if req.Date == "" {
-			response.JSONError(ctx, http.StatusBadRequest, "date parameter is required")
-			return
+	userID, err := parseUserID(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return actingUser(ctx, userID)
+}
+
+// rawString возвращает JSON-значение как строку без кавычек
+func rawString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+
+	return string(raw)
+}
+
+// queryLocation определяет часовой пояс из query-параметра tz или пояс пользователя.
+// Неверный tz добавляется в v, и дальше используется UTC. Ошибка возвращается,
+// только если пояс не удалось определить по другой причине.
+func (h *CalendarHandler) queryLocation(ctx *gin.Context, userID int, v *pkg.ValidationError) (*time.Location, error) {
+	loc, err := h.service.Location(userID, ctx.Query("tz"))
+	if errors.Is(err, pkg.ErrInvalidTimeZone) {
+		v.Add(pkg.ErrInvalidTimeZone.ForField("tz"))
+		return time.UTC, nil
+	}
+
+	return loc, err
+}
+
+// parseRange разбирает необязательные query-параметры from и to.
+// Ошибки формата и порядка границ добавляются в v.
+func parseRange(ctx *gin.Context, loc *time.Location, v *pkg.ValidationError) (time.Time, time.Time) {
+	var from, to time.Time
+	var err error
+
+	if value := ctx.Query("from"); value != "" {
+		if from, err = parseInstant(value, loc); err != nil {
+			v.Add(pkg.Invalid("from", "invalid from format, expected RFC 3339 or YYYY-MM-DD"))
 		}
+	}
+	if value := ctx.Query("to"); value != "" {
+		if to, err = parseInstant(value, loc); err != nil {
+			v.Add(pkg.Invalid("to", "invalid to format, expected RFC 3339 or YYYY-MM-DD"))
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		v.Add(pkg.ErrInvalidRange.WithMessage("from must be before to"))
+	}
 
-		day, err := time.Parse("2006-01-02", req.Date)
+	return from, to
+}
+
+// parseEventTimes разбирает время события из запроса.
+// Устаревшее поле date задает событие на весь день. Для событий на весь день
+// start и end принимаются как даты, end не включается и по умолчанию равен
+// следующему дню. Для остальных событий end по умолчанию равен start.
+// Даты без времени считаются в часовом поясе loc. Ошибки формата start и end
+// возвращаются вместе в pkg.ValidationError.
+func parseEventTimes(date, start, end string, allDay bool, loc *time.Location) (time.Time, time.Time, bool, error) {
+	if start == "" {
+		if date == "" {
+			return time.Time{}, time.Time{}, false, pkg.Required("start", "start or date is required")
+		}
+
+		day, err := time.ParseInLocation("2006-01-02", date, loc)
 		if err != nil {
-			response.JSONError(ctx, http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
-			return
+			return time.Time{}, time.Time{}, false, pkg.ErrInvalidDate.WithMessage("invalid date format, expected YYYY-MM-DD")
 		}
 
-		events := h.service.Calendar.GetEventsForMonth(req.UserID, day)
-		response.JSONResult(ctx, events)
+		return day, day.AddDate(0, 0, 1), true, nil
+	}
+
+	parse := parseTimestamp
+	if allDay {
+		parse = func(value string) (time.Time, error) { return parseDay(value, loc) }
+	}
+
+	var v pkg.ValidationError
+	startTime, err := parse(start)
+	if err != nil {
+		v.Add(pkg.Invalid("start", "invalid start format, "+expectedFormat(allDay)))
+	}
+
+	endTime := startTime
+	if allDay {
+		endTime = startTime.AddDate(0, 0, 1)
+	}
+	if end != "" {
+		if endTime, err = parse(end); err != nil {
+			v.Add(pkg.Invalid("end", "invalid end format, "+expectedFormat(allDay)))
+		}
+	}
+	if err := v.Err(); err != nil {
+		return time.Time{}, time.Time{}, false, err
 	}
+
+	if endTime.Before(startTime) {
+		return time.Time{}, time.Time{}, false, pkg.ErrEndBeforeStart
+	}
+
+	return startTime, endTime, allDay, nil
+}
+
+// parseTimestamp разбирает момент времени в формате RFC 3339
+func parseTimestamp(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseDay разбирает дату YYYY-MM-DD или дату момента в формате RFC 3339
+// и возвращает начало этого дня в часовом поясе loc
+func parseDay(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), nil
+	}
+
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+func expectedFormat(allDay bool) string {
+	if allDay {
+		return "expected YYYY-MM-DD"
+	}
+	return "expected RFC 3339"
+}
+
+// parseInstant разбирает момент времени в формате RFC 3339 или дату YYYY-MM-DD
+// (полночь в часовом поясе loc)
+func parseInstant(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// parseOccurrence читает recurrence_id — начало вхождения серии в формате RFC 3339.
+// Дата без времени считается в часовом поясе события.
+func (h *CalendarHandler) parseOccurrence(id calendar.EventID, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, pkg.Required("recurrence_id", "recurrence_id is required for scope this and following")
+	}
+
+	loc := time.UTC
+	if event, err := h.service.GetEvent(id); err == nil {
+		loc = event.Start.Location()
+	}
+
+	occurrence, err := parseInstant(value, loc)
+	if err != nil {
+		return time.Time{}, pkg.Invalid("recurrence_id", "invalid recurrence_id, expected RFC 3339 or YYYY-MM-DD")
+	}
+
+	return occurrence, nil
 }
@@ -2,31 +2,63 @@ package handler
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"wb-calendar/config"
+	"wb-calendar/internal/apikey"
 	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/ical"
+	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg/response"
 
 	"github.com/gin-gonic/gin"
 )
 
-func setupTestRouter() (*gin.Engine, *calendar.Service) {
+// newEvent создает событие без длительности
+func newEvent(userID int, start time.Time, title string) calendar.Event {
+	return calendar.Event{UserID: userID, Start: start, End: start, Title: title}
+}
+
+func setupTestRouter(middlewares ...gin.HandlerFunc) (*gin.Engine, *calendar.Service) {
 	gin.SetMode(gin.TestMode)
-	service := calendar.NewService()
+	service := calendar.NewService(calendar.NewCalendar())
 	handler := NewCalendarHandler(*service)
 	router := gin.New()
+	router.Use(middlewares...)
+
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(time.Hour))
 
 	api := router.Group("/api")
 	{
-		api.POST("/create_event", handler.CreateEventHandler())
+		api.POST("/create_event", idempotency, handler.CreateEventHandler())
 		api.POST("/update_event", handler.UpdateEventHandler())
+		api.PATCH("/update_event", handler.PatchEventHandler())
 		api.POST("/delete_event", handler.DeleteEventHandler())
+		api.POST("/batch_events", handler.BatchEventsHandler())
 		api.GET("/events_for_day", handler.GetEventsForDayHandler())
 		api.GET("/events_for_week", handler.GetEventsForWeekHandler())
 		api.GET("/events_for_month", handler.GetEventsForMonthHandler())
+		api.GET("/events_in_range", handler.GetEventsInRangeHandler())
+		api.GET("/events.ics", handler.ExportICSHandler())
+		api.POST("/import_ics", handler.ImportICSHandler())
+		api.GET("/time_zone", handler.GetTimeZoneHandler())
+		api.POST("/set_time_zone", handler.SetTimeZoneHandler())
 	}
+	handler.RegisterResourceRoutes(router.Group("/api/v1"), idempotency)
 
 	return router, service
 }
@@ -58,7 +90,7 @@ func TestCreateEventHandler(t *testing.T) {
 				Title:  "Christmas",
 			},
 			contentType:    "application/json",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name: "empty title",
@@ -68,7 +100,7 @@ func TestCreateEventHandler(t *testing.T) {
 				Title:  "",
 			},
 			contentType:    "application/json",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name: "invalid user_id",
@@ -78,7 +110,60 @@ func TestCreateEventHandler(t *testing.T) {
 				Title:  "Christmas",
 			},
 			contentType:    "application/json",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "start and end",
+			requestBody: CreateEventRequest{
+				UserID: 1,
+				Start:  "2023-12-25T14:00:00+03:00",
+				End:    "2023-12-25T15:30:00+03:00",
+				Title:  "Meeting",
+			},
+			contentType:    "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "all-day range",
+			requestBody: CreateEventRequest{
+				UserID: 1,
+				Start:  "2023-12-25",
+				End:    "2023-12-28",
+				AllDay: true,
+				Title:  "Holidays",
+			},
+			contentType:    "application/json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "end before start",
+			requestBody: CreateEventRequest{
+				UserID: 1,
+				Start:  "2023-12-25T15:30:00Z",
+				End:    "2023-12-25T14:00:00Z",
+				Title:  "Meeting",
+			},
+			contentType:    "application/json",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "start is not RFC 3339",
+			requestBody: CreateEventRequest{
+				UserID: 1,
+				Start:  "2023-12-25 14:00",
+				Title:  "Meeting",
+			},
+			contentType:    "application/json",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "no time",
+			requestBody: CreateEventRequest{
+				UserID: 1,
+				Title:  "Meeting",
+			},
+			contentType:    "application/json",
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -101,7 +186,7 @@ func TestCreateEventHandler(t *testing.T) {
 func TestUpdateEventHandler(t *testing.T) {
 	router, service := setupTestRouter()
 
-	event, _ := service.Calendar.CreateEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas")
+	event, _ := service.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas"))
 
 	tests := []struct {
 		name           string
@@ -112,9 +197,10 @@ func TestUpdateEventHandler(t *testing.T) {
 		{
 			name: "valid update request",
 			requestBody: UpdateEventRequest{
-				ID:    event.ID,
-				Date:  "2023-12-26",
-				Title: "Boxing Day",
+				ID:     event.ID,
+				UserID: 1,
+				Date:   "2023-12-26",
+				Title:  "Boxing Day",
 			},
 			contentType:    "application/json",
 			expectedStatus: http.StatusOK,
@@ -122,12 +208,13 @@ func TestUpdateEventHandler(t *testing.T) {
 		{
 			name: "event not found",
 			requestBody: UpdateEventRequest{
-				ID:    999,
-				Date:  "2023-12-26",
-				Title: "Boxing Day",
+				ID:     "999",
+				UserID: 1,
+				Date:   "2023-12-26",
+				Title:  "Boxing Day",
 			},
 			contentType:    "application/json",
-			expectedStatus: http.StatusServiceUnavailable,
+			expectedStatus: http.StatusNotFound,
 		},
 	}
 
@@ -150,7 +237,7 @@ func TestUpdateEventHandler(t *testing.T) {
 func TestDeleteEventHandler(t *testing.T) {
 	router, service := setupTestRouter()
 
-	event, _ := service.Calendar.CreateEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas")
+	event, _ := service.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas"))
 
 	tests := []struct {
 		name           string
@@ -161,7 +248,8 @@ func TestDeleteEventHandler(t *testing.T) {
 		{
 			name: "valid delete request",
 			requestBody: DeleteEventRequest{
-				ID: event.ID,
+				ID:     event.ID,
+				UserID: 1,
 			},
 			contentType:    "application/json",
 			expectedStatus: http.StatusOK,
@@ -169,10 +257,11 @@ func TestDeleteEventHandler(t *testing.T) {
 		{
 			name: "event not found",
 			requestBody: DeleteEventRequest{
-				ID: 999,
+				ID:     "999",
+				UserID: 1,
 			},
 			contentType:    "application/json",
-			expectedStatus: http.StatusServiceUnavailable,
+			expectedStatus: http.StatusNotFound,
 		},
 	}
 
@@ -196,7 +285,7 @@ func TestGetEventsForDayHandler(t *testing.T) {
 	router, service := setupTestRouter()
 
 	date1 := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
-	service.Calendar.CreateEvent(1, date1, "Christmas")
+	service.CreateEvent(newEvent(1, date1, "Christmas"))
 
 	tests := []struct {
 		name           string
@@ -214,12 +303,12 @@ func TestGetEventsForDayHandler(t *testing.T) {
 		{
 			name:           "missing user_id",
 			requestBody:    map[string]interface{}{"date": "2023-12-25"},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name:           "missing date",
 			requestBody:    map[string]interface{}{"user_id": 1},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name: "invalid user_id",
@@ -227,7 +316,7 @@ func TestGetEventsForDayHandler(t *testing.T) {
 				"user_id": "abc",
 				"date":    "2023-12-25",
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name: "invalid date format",
@@ -235,7 +324,7 @@ func TestGetEventsForDayHandler(t *testing.T) {
 				"user_id": 1,
 				"date":    "invalid-date",
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -258,7 +347,7 @@ func TestGetEventsForWeekHandler(t *testing.T) {
 	router, service := setupTestRouter()
 
 	date1 := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
-	service.Calendar.CreateEvent(1, date1, "Christmas")
+	service.CreateEvent(newEvent(1, date1, "Christmas"))
 
 	tests := []struct {
 		name           string
@@ -279,7 +368,7 @@ func TestGetEventsForWeekHandler(t *testing.T) {
 				"user_id": 0,
 				"date":    "2023-12-25",
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -302,7 +391,7 @@ func TestGetEventsForMonthHandler(t *testing.T) {
 	router, service := setupTestRouter()
 
 	date1 := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
-	service.Calendar.CreateEvent(1, date1, "Christmas")
+	service.CreateEvent(newEvent(1, date1, "Christmas"))
 
 	tests := []struct {
 		name           string
@@ -323,7 +412,7 @@ func TestGetEventsForMonthHandler(t *testing.T) {
 				"user_id": -1,
 				"date":    "2023-12-25",
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -341,3 +430,1477 @@ func TestGetEventsForMonthHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGetEventsInRangeHandler(t *testing.T) {
+	router, service := setupTestRouter()
+
+	service.CreateEvent(newEvent(1, time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC), "Boxing Day"))
+	service.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas"))
+	service.CreateEvent(newEvent(1, time.Date(2023, 12, 27, 0, 0, 0, 0, time.UTC), "Outside"))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedTitles []string
+	}{
+		{
+			name:           "dates, half-open and sorted",
+			query:          "user_id=1&from=2023-12-25&to=2023-12-27",
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Christmas", "Boxing Day"},
+		},
+		{
+			name:           "RFC 3339 instants",
+			query:          "user_id=1&from=2023-12-25T12:00:00Z&to=2023-12-27T00:00:01Z",
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Boxing Day", "Outside"},
+		},
+		{
+			name:           "missing to",
+			query:          "user_id=1&from=2023-12-25",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid from",
+			query:          "user_id=1&from=yesterday&to=2023-12-27",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "empty range",
+			query:          "user_id=1&from=2023-12-27&to=2023-12-27",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid user_id",
+			query:          "user_id=abc&from=2023-12-25&to=2023-12-27",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/events_in_range?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedTitles == nil {
+				return
+			}
+
+			var body struct {
+				Result []calendar.Event `json:"result"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(body.Result) != len(tt.expectedTitles) {
+				t.Fatalf("expected %d events, got %d", len(tt.expectedTitles), len(body.Result))
+			}
+			for i, title := range tt.expectedTitles {
+				if body.Result[i].Title != title {
+					t.Errorf("expected event %d to be %s, got %s", i, title, body.Result[i].Title)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateEventHandlerTimes(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	body, _ := json.Marshal(CreateEventRequest{
+		UserID: 1,
+		Start:  "2023-12-25T14:00:00Z",
+		End:    "2023-12-25T15:30:00Z",
+		Title:  "Meeting",
+	})
+	req := httptest.NewRequest("POST", "/api/create_event", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var created struct {
+		Result calendar.Event `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.Result.Duration() != 90*time.Minute || created.Result.AllDay {
+		t.Fatalf("unexpected event: %+v", created.Result)
+	}
+
+	// Встреча попадает в выборку за день, в который пересекается с ним
+	req = httptest.NewRequest("GET", "/api/events_in_range?user_id=1&from=2023-12-25T15:00:00Z&to=2023-12-25T16:00:00Z", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var listed struct {
+		Result []calendar.Event `json:"result"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Result) != 1 {
+		t.Fatalf("expected overlapping meeting, got %d events", len(listed.Result))
+	}
+}
+
+func TestTimeZoneHandlers(t *testing.T) {
+	router, service := setupTestRouter()
+
+	tests := []struct {
+		name           string
+		requestBody    SetTimeZoneRequest
+		expectedStatus int
+	}{
+		{
+			name:           "valid time zone",
+			requestBody:    SetTimeZoneRequest{UserID: 1, TimeZone: "Asia/Vladivostok"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown time zone",
+			requestBody:    SetTimeZoneRequest{UserID: 1, TimeZone: "Mars/Olympus"},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid user_id",
+			requestBody:    SetTimeZoneRequest{UserID: 0, TimeZone: "UTC"},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.requestBody)
+			req := httptest.NewRequest("POST", "/api/set_time_zone", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	// Событие в 20:00 UTC 25 декабря приходится на 26 декабря во Владивостоке
+	service.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 20, 0, 0, 0, time.UTC), "Stand-up"))
+
+	dayTests := []struct {
+		name     string
+		query    string
+		date     string
+		expected int
+	}{
+		{name: "user's zone", query: "", date: "2023-12-26", expected: 1},
+		{name: "tz override", query: "?tz=UTC", date: "2023-12-26", expected: 0},
+		{name: "tz override previous day", query: "?tz=UTC", date: "2023-12-25", expected: 1},
+	}
+
+	for _, tt := range dayTests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(map[string]interface{}{"user_id": 1, "date": tt.date})
+			req := httptest.NewRequest("GET", "/api/events_for_day"+tt.query, bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var body struct {
+				Result []calendar.Event `json:"result"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if len(body.Result) != tt.expected {
+				t.Errorf("expected %d events, got %d", tt.expected, len(body.Result))
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/events_for_day?tz=Mars/Olympus", bytes.NewBufferString(`{"user_id":1,"date":"2023-12-26"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for invalid tz, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestRecurringEventHandlers(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	body, _ := json.Marshal(CreateEventRequest{UserID: 1, Start: "2024-01-01T10:00:00Z", Title: "Stand-up", RRule: "FREQ=LATER"})
+	req := httptest.NewRequest("POST", "/api/create_event", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d for invalid rule, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	body, _ = json.Marshal(CreateEventRequest{
+		UserID: 1,
+		Start:  "2024-01-01T10:00:00Z",
+		End:    "2024-01-01T10:15:00Z",
+		Title:  "Stand-up",
+		RRule:  "FREQ=WEEKLY;BYDAY=MO,WE",
+	})
+	req = httptest.NewRequest("POST", "/api/create_event", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var series struct {
+		Result calendar.Event `json:"result"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &series)
+
+	req = httptest.NewRequest("GET", "/api/events_in_range?user_id=1&from=2024-03-04&to=2024-03-11", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var listed struct {
+		Result []calendar.Event `json:"result"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Result) != 2 {
+		t.Fatalf("expected 2 occurrences, got %d", len(listed.Result))
+	}
+	for _, occurrence := range listed.Result {
+		if occurrence.SeriesID != series.Result.ID || occurrence.OccurrenceStart == nil {
+			t.Fatalf("expected occurrence of series %s, got %+v", series.Result.ID, occurrence)
+		}
+	}
+	if listed.Result[1].Start.Format(time.RFC3339) != "2024-03-06T10:00:00Z" {
+		t.Fatalf("expected Wednesday occurrence, got %v", listed.Result[1].Start)
+	}
+}
+
+func TestOccurrenceScopeHandlers(t *testing.T) {
+	router, service := setupTestRouter()
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Weekly", RRule: "FREQ=WEEKLY"})
+
+	post := func(path string, payload any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		payload        any
+		expectedStatus int
+	}{
+		{
+			name:           "invalid scope",
+			path:           "/api/delete_event",
+			payload:        DeleteEventRequest{ID: series.ID, UserID: 1, Scope: "some"},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "missing recurrence_id",
+			path:           "/api/delete_event",
+			payload:        DeleteEventRequest{ID: series.ID, UserID: 1, Scope: "this"},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "unknown occurrence",
+			path:           "/api/delete_event",
+			payload:        DeleteEventRequest{ID: series.ID, UserID: 1, Scope: "this", RecurrenceID: "2024-01-02T10:00:00Z"},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "cancel one occurrence",
+			path:           "/api/delete_event",
+			payload:        DeleteEventRequest{ID: series.ID, UserID: 1, Scope: "this", RecurrenceID: "2024-01-08T10:00:00Z"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "rename one occurrence",
+			path: "/api/update_event",
+			payload: UpdateEventRequest{
+				ID:           series.ID,
+				UserID:       1,
+				Start:        "2024-01-15T12:00:00Z",
+				End:          "2024-01-15T13:00:00Z",
+				Title:        "Moved",
+				Scope:        "this",
+				RecurrenceID: "2024-01-15T10:00:00Z",
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "end series",
+			path:           "/api/delete_event",
+			payload:        DeleteEventRequest{ID: series.ID, UserID: 1, Scope: "following", RecurrenceID: "2024-01-22T10:00:00Z"},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.path, tt.payload)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	events, _ := service.GetEventsInRange(1, start, start.AddDate(0, 2, 0))
+	var titles []string
+	for _, event := range events {
+		titles = append(titles, event.Start.Format("01-02 15")+" "+event.Title)
+	}
+	want := []string{"01-01 10 Weekly", "01-15 12 Moved"}
+	if len(titles) != len(want) || titles[0] != want[0] || titles[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, titles)
+	}
+}
+
+func TestExportICSHandler(t *testing.T) {
+	router, service := setupTestRouter()
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Weekly", RRule: "FREQ=WEEKLY;COUNT=4"})
+	service.CreateEvent(newEvent(1, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "March"))
+	service.CreateEvent(newEvent(2, start, "Other user"))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       []string
+		unexpected     []string
+	}{
+		{
+			name:           "all events",
+			query:          "user_id=1",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"BEGIN:VCALENDAR\r\n", "SUMMARY:Weekly", "RRULE:FREQ=WEEKLY;COUNT=4", "SUMMARY:March"},
+			unexpected:     []string{"Other user"},
+		},
+		{
+			name:           "range keeps series with occurrences inside",
+			query:          "user_id=1&from=2024-01-20&to=2024-02-01",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"SUMMARY:Weekly"},
+			unexpected:     []string{"SUMMARY:March"},
+		},
+		{
+			name:           "range after series end",
+			query:          "user_id=1&from=2024-02-01",
+			expectedStatus: http.StatusOK,
+			expected:       []string{"SUMMARY:March"},
+			unexpected:     []string{"SUMMARY:Weekly"},
+		},
+		{
+			name:           "invalid user_id",
+			query:          "user_id=abc",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid range",
+			query:          "user_id=1&from=2024-02-01&to=2024-01-01",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/events.ics?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if contentType := w.Header().Get("Content-Type"); contentType != "text/calendar; charset=utf-8" {
+				t.Fatalf("expected text/calendar, got %q", contentType)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("expected %q in export:\n%s", s, w.Body.String())
+				}
+			}
+			for _, s := range tt.unexpected {
+				if strings.Contains(w.Body.String(), s) {
+					t.Errorf("unexpected %q in export:\n%s", s, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestImportICSHandler(t *testing.T) {
+	router, service := setupTestRouter()
+
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:a@example.com\r\nDTSTART:20240110T140000Z\r\nDTEND:20240110T150000Z\r\nSUMMARY:Imported\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:b@example.com\r\nSUMMARY:Broken\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	multipartBody := func() (*bytes.Buffer, string) {
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		part, _ := writer.CreateFormFile("file", "calendar.ics")
+		part.Write([]byte(ics))
+		writer.Close()
+		return &buf, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		body           func() (*bytes.Buffer, string)
+		expectedStatus int
+		expectedReport ical.Report
+	}{
+		{
+			name:           "raw body",
+			query:          "user_id=1",
+			body:           func() (*bytes.Buffer, string) { return bytes.NewBufferString(ics), "text/calendar" },
+			expectedStatus: http.StatusOK,
+			expectedReport: ical.Report{Created: 1, Errors: []ical.EntryError{{Line: 9, UID: "b@example.com", Error: "DTSTART is required"}}},
+		},
+		{
+			name:           "multipart re-import",
+			query:          "user_id=1",
+			body:           multipartBody,
+			expectedStatus: http.StatusOK,
+			expectedReport: ical.Report{Skipped: 1, Errors: []ical.EntryError{{Line: 9, UID: "b@example.com", Error: "DTSTART is required"}}},
+		},
+		{
+			name:  "not a calendar",
+			query: "user_id=1",
+			body: func() (*bytes.Buffer, string) {
+				return bytes.NewBufferString("BEGIN:VEVENT\r\nEND:VEVENT\r\n"), "text/calendar"
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid user_id",
+			query:          "user_id=0",
+			body:           func() (*bytes.Buffer, string) { return bytes.NewBufferString(ics), "text/calendar" },
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := tt.body()
+			req := httptest.NewRequest("POST", "/api/import_ics?"+tt.query, body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result struct {
+				Result ical.Report `json:"result"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &result)
+			if !reflect.DeepEqual(result.Result, tt.expectedReport) {
+				t.Fatalf("expected report %+v, got %+v", tt.expectedReport, result.Result)
+			}
+		})
+	}
+
+	if event, err := service.GetEventByUID(1, "a@example.com"); err != nil || event.Title != "Imported" {
+		t.Fatalf("expected imported event, got %+v, %v", event, err)
+	}
+}
+
+func TestEventResourceHandlers(t *testing.T) {
+	router, service := setupTestRouter()
+
+	do := func(method, path string, payload any) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		if payload != nil {
+			_ = json.NewEncoder(&body).Encode(payload)
+		}
+		req := httptest.NewRequest(method, path, &body)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	result := func(w *httptest.ResponseRecorder) calendar.Event {
+		var resp struct {
+			Result calendar.Event `json:"result"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+		}
+		return resp.Result
+	}
+
+	w := do("POST", "/api/v1/users/1/events", EventRequest{
+		Start: "2025-08-11T14:00:00Z",
+		End:   "2025-08-11T15:30:00Z",
+		Title: "Meeting",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	created := result(w)
+	location := w.Header().Get("Location")
+	if want := "/api/v1/users/1/events/" + string(created.ID); location != want {
+		t.Fatalf("expected Location %s, got %s", want, location)
+	}
+
+	if w := do("GET", location, nil); w.Code != http.StatusOK || result(w).Title != "Meeting" {
+		t.Fatalf("get: status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do("PATCH", location, map[string]any{"start": "2025-08-11T16:00:00Z"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch: status %d: %s", w.Code, w.Body.String())
+	}
+	if patched := result(w); patched.Title != "Meeting" || patched.Duration() != 90*time.Minute || patched.Start.Hour() != 16 {
+		t.Fatalf("patch changed more than start: %+v", patched)
+	}
+
+	w = do("PUT", location, EventRequest{Date: "2025-08-12", Title: "Offsite"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("put: status %d: %s", w.Code, w.Body.String())
+	}
+	if replaced := result(w); !replaced.AllDay || replaced.Title != "Offsite" {
+		t.Fatalf("put did not replace event: %+v", replaced)
+	}
+
+	other, _ := service.CreateEvent(newEvent(2, time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC), "Private"))
+	otherPath := "/api/v1/users/1/events/" + string(other.ID)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        any
+		expectedStatus int
+	}{
+		{"list all", "GET", "/api/v1/users/1/events", nil, http.StatusOK},
+		{"list range", "GET", "/api/v1/users/1/events?from=2025-08-11&to=2025-08-18", nil, http.StatusOK},
+		{"list half range", "GET", "/api/v1/users/1/events?from=2025-08-11", nil, http.StatusUnprocessableEntity},
+		{"invalid user", "GET", "/api/v1/users/x/events", nil, http.StatusUnprocessableEntity},
+		{"create without title", "POST", "/api/v1/users/1/events", EventRequest{Date: "2025-08-11"}, http.StatusUnprocessableEntity},
+		{"get missing", "GET", "/api/v1/users/1/events/999", nil, http.StatusNotFound},
+		{"get other user", "GET", otherPath, nil, http.StatusNotFound},
+		{"put other user", "PUT", otherPath, EventRequest{Date: "2025-08-11", Title: "Mine"}, http.StatusNotFound},
+		{"patch empty title", "PATCH", location, map[string]any{"title": ""}, http.StatusUnprocessableEntity},
+		{"patch bad rrule", "PATCH", location, map[string]any{"rrule": "FREQ=HOURLY"}, http.StatusUnprocessableEntity},
+		{"delete other user", "DELETE", otherPath, nil, http.StatusNotFound},
+		{"delete", "DELETE", location, nil, http.StatusNoContent},
+		{"delete again", "DELETE", location, nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.payload)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := service.GetEvent(other.ID); err != nil {
+		t.Fatalf("event of another user was deleted: %v", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	router, service := setupTestRouter()
+
+	start := time.Date(2025, 8, 11, 14, 0, 0, 0, time.UTC)
+	event, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Stand-up", RRule: "FREQ=DAILY"})
+	path := "/api/v1/users/1/events/" + string(event.ID)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		contentType    string
+		body           string
+		expectedStatus int
+		check          func(calendar.Event) bool
+	}{
+		{
+			name: "title only", method: "PATCH", path: path, contentType: MergePatchContentType,
+			body:           `{"title": "Daily"}`,
+			expectedStatus: http.StatusOK,
+			check: func(e calendar.Event) bool {
+				return e.Title == "Daily" && e.RRule == "FREQ=DAILY" && e.Start.Equal(start) && e.Duration() == time.Hour
+			},
+		},
+		{
+			name: "null clears rrule", method: "PATCH", path: path, contentType: MergePatchContentType,
+			body:           `{"rrule": null}`,
+			expectedStatus: http.StatusOK,
+			check:          func(e calendar.Event) bool { return e.RRule == "" && e.Title == "Daily" },
+		},
+		{
+			name: "switch to all day", method: "PATCH", path: path, contentType: "application/json",
+			body:           `{"all_day": true}`,
+			expectedStatus: http.StatusOK,
+			check: func(e calendar.Event) bool {
+				return e.AllDay && e.Start.Equal(time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC)) && e.Duration() == 24*time.Hour
+			},
+		},
+		{
+			name: "legacy route", method: "PATCH", path: "/api/update_event", contentType: MergePatchContentType,
+			body:           `{"id": "` + string(event.ID) + `", "user_id": 1, "title": "Legacy"}`,
+			expectedStatus: http.StatusOK,
+			check:          func(e calendar.Event) bool { return e.Title == "Legacy" && e.AllDay },
+		},
+		{name: "unknown field", method: "PATCH", path: path, contentType: MergePatchContentType, body: `{"colour": "red"}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "null title", method: "PATCH", path: path, contentType: MergePatchContentType, body: `{"title": null}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "not an object", method: "PATCH", path: path, contentType: MergePatchContentType, body: `["title"]`, expectedStatus: http.StatusBadRequest},
+		{name: "unsupported media type", method: "PATCH", path: path, contentType: "text/plain", body: `{"title": "x"}`, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "legacy route without id", method: "PATCH", path: "/api/update_event", contentType: MergePatchContentType, body: `{"title": "x"}`, expectedStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.check == nil {
+				return
+			}
+
+			var resp struct {
+				Result calendar.Event `json:"result"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(resp.Result) {
+				t.Errorf("unexpected event %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestEventETags(t *testing.T) {
+	router, service := setupTestRouter()
+
+	start := time.Date(2025, 8, 11, 14, 0, 0, 0, time.UTC)
+	event, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Review"})
+	path := "/api/v1/users/1/events/" + string(event.ID)
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", path, "", nil)
+	if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf("expected ETag \"1\", got %q", etag)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		headers        map[string]string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{name: "not modified", method: "GET", path: path, headers: map[string]string{"If-None-Match": `"1"`}, expectedStatus: http.StatusNotModified, expectedETag: `"1"`},
+		{name: "weak not modified", method: "GET", path: path, headers: map[string]string{"If-None-Match": `"7", W/"1"`}, expectedStatus: http.StatusNotModified, expectedETag: `"1"`},
+		{name: "modified", method: "GET", path: path, headers: map[string]string{"If-None-Match": `"0"`}, expectedStatus: http.StatusOK, expectedETag: `"1"`},
+		{name: "patch with current version", method: "PATCH", path: path, body: `{"title": "Review 2"}`, headers: map[string]string{"If-Match": `"1"`}, expectedStatus: http.StatusOK, expectedETag: `"2"`},
+		{name: "patch with stale version", method: "PATCH", path: path, body: `{"title": "Lost"}`, headers: map[string]string{"If-Match": `"1"`}, expectedStatus: http.StatusPreconditionFailed},
+		{name: "weak etag never matches", method: "PATCH", path: path, body: `{"title": "Lost"}`, headers: map[string]string{"If-Match": `W/"2"`}, expectedStatus: http.StatusPreconditionFailed},
+		{name: "put with stale version", method: "PUT", path: path, body: `{"date": "2025-08-12", "title": "Lost"}`, headers: map[string]string{"If-Match": `"1"`}, expectedStatus: http.StatusPreconditionFailed},
+		{name: "legacy update with stale version", method: "POST", path: "/api/update_event", body: `{"id": "` + string(event.ID) + `", "user_id": 1, "date": "2025-08-12", "title": "Lost"}`, headers: map[string]string{"If-Match": `"1"`}, expectedStatus: http.StatusPreconditionFailed},
+		{name: "any version", method: "PUT", path: path, body: `{"date": "2025-08-12", "title": "Offsite"}`, headers: map[string]string{"If-Match": "*"}, expectedStatus: http.StatusOK, expectedETag: `"3"`},
+		{name: "legacy update with current version", method: "POST", path: "/api/update_event", body: `{"id": "` + string(event.ID) + `", "user_id": 1, "date": "2025-08-13", "title": "Moved"}`, headers: map[string]string{"If-Match": `"3"`}, expectedStatus: http.StatusOK, expectedETag: `"4"`},
+		{name: "legacy delete with stale version", method: "POST", path: "/api/delete_event", body: `{"id": "` + string(event.ID) + `", "user_id": 1}`, headers: map[string]string{"If-Match": `"2"`}, expectedStatus: http.StatusPreconditionFailed},
+		{name: "delete with current version", method: "DELETE", path: path, headers: map[string]string{"If-Match": `"2", "4"`}, expectedStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.body, tt.headers)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if etag := w.Header().Get("ETag"); etag != tt.expectedETag {
+				t.Errorf("expected ETag %q, got %q", tt.expectedETag, etag)
+			}
+		})
+	}
+
+	if w := do("GET", path, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected event to be deleted, got %d", w.Code)
+	}
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	router, service := setupTestRouter()
+
+	do := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"user_id": 1, "date": "2025-08-11", "title": "Retro"}`
+	first := do("/api/create_event", "key-1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", first.Code, first.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		key            string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		replayed       bool
+	}{
+		{name: "retry replays response", path: "/api/create_event", key: "key-1", body: body, expectedStatus: http.StatusOK, replayed: true},
+		{name: "reused key with other body", path: "/api/create_event", key: "key-1", body: `{"user_id": 1, "date": "2025-08-12", "title": "Retro"}`, expectedStatus: http.StatusConflict, expectedCode: "idempotency_key_reused"},
+		{name: "same key on other route", path: "/api/v1/users/1/events", key: "key-1", body: `{"date": "2025-08-11", "title": "Retro"}`, expectedStatus: http.StatusCreated},
+		{name: "too long key", path: "/api/create_event", key: strings.Repeat("k", 256), body: body, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed"},
+		{name: "invalid request", path: "/api/create_event", key: "key-2", body: `{"user_id": 1}`, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed"},
+		{name: "retry of invalid request", path: "/api/create_event", key: "key-2", body: `{"user_id": 1}`, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed", replayed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.path, tt.key, tt.body)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("expected replayed %v, got %v", tt.replayed, replayed)
+			}
+			if tt.replayed && tt.expectedStatus == http.StatusOK && w.Body.String() != first.Body.String() {
+				t.Errorf("expected replayed body %s, got %s", first.Body.String(), w.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var problem response.Problem
+				json.Unmarshal(w.Body.Bytes(), &problem)
+				if problem.Code != tt.expectedCode {
+					t.Errorf("expected code %s, got %s", tt.expectedCode, problem.Code)
+				}
+			}
+		})
+	}
+
+	// Повтор не создал второе событие
+	events, _ := service.ListEvents(1, time.Time{}, time.Time{})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+}
+
+func TestBatchHandlers(t *testing.T) {
+	date := time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		path             string
+		body             func(existing, other calendar.Event) string
+		expectedStatus   int
+		expectedStatuses []int
+		expectedFields   []string
+		expectedCount    int
+	}{
+		{
+			name: "atomic",
+			path: "/api/v1/users/1/events/batch",
+			body: func(existing, other calendar.Event) string {
+				return `{"operations": [
+					{"op": "create", "date": "2025-08-12", "title": "Retro"},
+					{"op": "update", "id": "` + string(existing.ID) + `", "start": "2025-08-11T10:00:00Z", "title": "Planning", "version": 1},
+					{"op": "delete", "id": "` + string(existing.ID) + `"}
+				]}`
+			},
+			expectedStatus:   http.StatusOK,
+			expectedStatuses: []int{http.StatusCreated, http.StatusOK, http.StatusOK},
+			expectedCount:    1,
+		},
+		{
+			name: "atomic lists every invalid field",
+			path: "/api/v1/users/1/events/batch",
+			body: func(existing, other calendar.Event) string {
+				return `{"operations": [
+					{"op": "create", "date": "2025-08-12"},
+					{"op": "move", "id": 1},
+					{"op": "delete"}
+				]}`
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedFields: []string{"operations[0].title", "operations[1].op", "operations[2].id"},
+			expectedCount:  1,
+		},
+		{
+			name: "atomic hides other user's event",
+			path: "/api/v1/users/1/events/batch",
+			body: func(existing, other calendar.Event) string {
+				return `{"operations": [
+					{"op": "create", "date": "2025-08-12", "title": "Retro"},
+					{"op": "delete", "id": "` + string(other.ID) + `"}
+				]}`
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedFields: []string{"operations[1]"},
+			expectedCount:  1,
+		},
+		{
+			name: "best effort",
+			path: "/api/batch_events",
+			body: func(existing, other calendar.Event) string {
+				return `{"mode": "best_effort", "operations": [
+					{"op": "create", "user_id": 1, "date": "2025-08-12", "title": "Retro"},
+					{"op": "create", "user_id": 1, "date": "2025-08-12"},
+					{"op": "update", "user_id": 1, "id": "` + string(existing.ID) + `", "date": "2025-08-13", "title": "Planning", "version": 5},
+					{"op": "delete", "user_id": 1, "id": 999}
+				]}`
+			},
+			expectedStatus:   http.StatusOK,
+			expectedStatuses: []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusPreconditionFailed, http.StatusNotFound},
+			expectedCount:    2,
+		},
+		{
+			name: "invalid mode",
+			path: "/api/batch_events",
+			body: func(existing, other calendar.Event) string {
+				return `{"mode": "eventually", "operations": []}`
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedFields: []string{"mode", "operations"},
+			expectedCount:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, service := setupTestRouter()
+			existing, _ := service.CreateEvent(newEvent(1, date, "Standup"))
+			other, _ := service.CreateEvent(newEvent(2, date, "Private"))
+
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body(existing, other)))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			if tt.expectedStatuses != nil {
+				var resp struct {
+					Result BatchResponse `json:"result"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				var statuses []int
+				for _, result := range resp.Result.Results {
+					statuses = append(statuses, result.Status)
+				}
+				if !reflect.DeepEqual(statuses, tt.expectedStatuses) {
+					t.Errorf("expected statuses %v, got %v", tt.expectedStatuses, statuses)
+				}
+			} else {
+				var problem response.Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+					t.Fatal(err)
+				}
+				var fields []string
+				for _, fieldErr := range problem.Errors {
+					fields = append(fields, fieldErr.Field)
+				}
+				if !reflect.DeepEqual(fields, tt.expectedFields) {
+					t.Errorf("expected fields %v, got %v", tt.expectedFields, fields)
+				}
+			}
+
+			events, _ := service.ListEvents(1, time.Time{}, time.Time{})
+			if len(events) != tt.expectedCount {
+				t.Errorf("expected %d events of user 1, got %d", tt.expectedCount, len(events))
+			}
+		})
+	}
+}
+
+func TestPeriodHandlersQueryString(t *testing.T) {
+	router, service := setupTestRouter()
+
+	service.CreateEvent(newEvent(1, time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC), "Planning"))
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectedError  string
+		expectedCount  int
+	}{
+		{name: "day from query", path: "/api/events_for_day?user_id=1&date=2025-08-11", expectedStatus: http.StatusOK, expectedCount: 1},
+		{name: "week from query", path: "/api/events_for_week?user_id=1&date=2025-08-13", expectedStatus: http.StatusOK, expectedCount: 1},
+		{name: "month from query", path: "/api/events_for_month?user_id=1&date=2025-08-31", expectedStatus: http.StatusOK, expectedCount: 1},
+		{name: "other day", path: "/api/events_for_day?user_id=1&date=2025-08-12", expectedStatus: http.StatusOK},
+		{name: "query overrides body", path: "/api/events_for_day?date=2025-08-11", body: `{"user_id": 1, "date": "2025-08-12"}`, expectedStatus: http.StatusOK, expectedCount: 1},
+		{name: "user_id as string in body", path: "/api/events_for_day", body: `{"user_id": "1", "date": "2025-08-11"}`, expectedStatus: http.StatusOK, expectedCount: 1},
+		{name: "missing user_id in query", path: "/api/events_for_day?date=2025-08-11", expectedStatus: http.StatusUnprocessableEntity, expectedError: "user_id parameter is required"},
+		{name: "missing user_id in body", path: "/api/events_for_day", body: `{"date": "2025-08-11"}`, expectedStatus: http.StatusUnprocessableEntity, expectedError: "user_id parameter is required"},
+		{name: "invalid user_id in query", path: "/api/events_for_day?user_id=abc&date=2025-08-11", expectedStatus: http.StatusUnprocessableEntity, expectedError: "invalid user_id"},
+		{name: "invalid user_id in body", path: "/api/events_for_day", body: `{"user_id": "abc", "date": "2025-08-11"}`, expectedStatus: http.StatusUnprocessableEntity, expectedError: "invalid user_id"},
+		{name: "missing date in query", path: "/api/events_for_day?user_id=1", expectedStatus: http.StatusUnprocessableEntity, expectedError: "date parameter is required"},
+		{name: "invalid date in query", path: "/api/events_for_day?user_id=1&date=11.08.2025", expectedStatus: http.StatusUnprocessableEntity, expectedError: "invalid date format, expected YYYY-MM-DD"},
+		{name: "invalid body", path: "/api/events_for_day", body: `{`, expectedStatus: http.StatusBadRequest, expectedError: "invalid request body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Result []calendar.Event `json:"result"`
+				Detail string           `json:"detail"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Detail != tt.expectedError {
+				t.Errorf("expected error %q, got %q", tt.expectedError, resp.Detail)
+			}
+			if len(resp.Result) != tt.expectedCount {
+				t.Errorf("expected %d events, got %d", tt.expectedCount, len(resp.Result))
+			}
+		})
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		expectedFields []string
+	}{
+		{
+			name:           "every invalid field at once",
+			method:         "POST",
+			path:           "/api/create_event",
+			body:           `{"user_id": 0, "start": "tomorrow", "end": "later", "time_zone": "Mars/Olympus"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "validation_failed",
+			expectedFields: []string{"user_id", "title", "time_zone", "start", "end"},
+		},
+		{
+			name:           "single service validation error",
+			method:         "POST",
+			path:           "/api/create_event",
+			body:           `{"user_id": 1, "start": "2025-08-11T10:00:00Z", "title": "x", "rrule": "FREQ=HOURLY"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "validation_failed",
+			expectedFields: []string{"rrule"},
+		},
+		{
+			name:           "malformed body",
+			method:         "POST",
+			path:           "/api/create_event",
+			body:           `{"user_id":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_body",
+		},
+		{
+			name:           "event not found",
+			method:         "POST",
+			path:           "/api/delete_event",
+			body:           `{"id": 999, "user_id": 1}`,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "event_not_found",
+		},
+		{
+			name:           "patch with malformed user_id",
+			method:         "PATCH",
+			path:           "/api/update_event",
+			body:           `{"id": "01J9ZQ8Y3K6V2C5T4XW7N0B1MR", "user_id": "abc", "title": "x"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "validation_failed",
+			expectedFields: []string{"user_id"},
+		},
+		{
+			name:           "query parameters",
+			method:         "GET",
+			path:           "/api/events_in_range?user_id=-1&from=bad&tz=Nowhere",
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "validation_failed",
+			expectedFields: []string{"user_id", "tz", "to", "from"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != response.ProblemContentType {
+				t.Errorf("expected Content-Type %s, got %s", response.ProblemContentType, contentType)
+			}
+
+			var problem response.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Code != tt.expectedCode || problem.Status != tt.expectedStatus || problem.Title == "" {
+				t.Errorf("unexpected problem %+v", problem)
+			}
+
+			var fields []string
+			for _, fieldErr := range problem.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.expectedFields) {
+				t.Errorf("expected fields %v, got %v", tt.expectedFields, fields)
+			}
+		})
+	}
+
+	// Внутренняя ошибка не раскрывается клиенту, но попадает в лог
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	router.GET("/boom", func(ctx *gin.Context) { response.Error(ctx, errors.New("wal: fsync: disk full")) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "disk full") {
+		t.Fatalf("expected generic internal error, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(logs.String(), "GET /boom: wal: fsync: disk full") {
+		t.Fatalf("expected error in log, got %q", logs.String())
+	}
+}
+
+func TestOwnershipChecks(t *testing.T) {
+	router, service := setupTestRouter()
+	start := time.Date(2025, 8, 11, 10, 0, 0, 0, time.UTC)
+	event, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Planning"})
+	series, _ := service.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Standup", RRule: "FREQ=DAILY;COUNT=5"})
+	id, seriesID := string(event.ID), string(series.ID)
+
+	// Пользователь 2 не может изменить или удалить события пользователя 1 ни одним
+	// маршрутом и не отличает их от несуществующих: ответ всегда 404
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header map[string]string
+	}{
+		{name: "legacy update", method: "POST", path: "/api/update_event", body: `{"id": "` + id + `", "user_id": 2, "date": "2025-08-12", "title": "Mine"}`},
+		{name: "legacy update with stale version", method: "POST", path: "/api/update_event", body: `{"id": "` + id + `", "user_id": 2, "date": "2025-08-12", "title": "Mine"}`, header: map[string]string{"If-Match": `"7"`}},
+		{name: "legacy update of occurrence", method: "POST", path: "/api/update_event", body: `{"id": "` + seriesID + `", "user_id": 2, "start": "2025-08-12T12:00:00Z", "title": "Mine", "scope": "this", "recurrence_id": "2025-08-12T10:00:00Z"}`},
+		{name: "legacy patch", method: "PATCH", path: "/api/update_event", body: `{"id": "` + id + `", "user_id": 2, "title": "Mine"}`},
+		{name: "legacy delete", method: "POST", path: "/api/delete_event", body: `{"id": "` + id + `", "user_id": 2}`},
+		{name: "legacy delete of occurrence", method: "POST", path: "/api/delete_event", body: `{"id": "` + seriesID + `", "user_id": 2, "scope": "this", "recurrence_id": "2025-08-12T10:00:00Z"}`},
+		{name: "resource read", method: "GET", path: "/api/v1/users/2/events/" + id},
+		{name: "resource replace", method: "PUT", path: "/api/v1/users/2/events/" + id, body: `{"date": "2025-08-12", "title": "Mine"}`},
+		{name: "resource patch", method: "PATCH", path: "/api/v1/users/2/events/" + id, body: `{"title": "Mine"}`},
+		{name: "resource delete", method: "DELETE", path: "/api/v1/users/2/events/" + id},
+		{name: "resource delete of occurrence", method: "DELETE", path: "/api/v1/users/2/events/" + seriesID + "?scope=following&recurrence_id=2025-08-13T10:00:00Z"},
+		{name: "legacy batch update", method: "POST", path: "/api/batch_events", body: `{"operations": [{"op": "update", "user_id": 2, "id": "` + id + `", "date": "2025-08-12", "title": "Mine"}]}`},
+		{name: "legacy batch delete", method: "POST", path: "/api/batch_events", body: `{"operations": [{"op": "delete", "user_id": 2, "id": "` + id + `"}]}`},
+		{name: "resource batch delete", method: "POST", path: "/api/v1/users/2/events/batch", body: `{"operations": [{"op": "delete", "id": "` + seriesID + `"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	for _, want := range []calendar.Event{event, series} {
+		got, err := service.GetEvent(want.ID)
+		if err != nil || got.Title != want.Title || got.Version != 1 || got.RRule != want.RRule || len(got.ExDates) != 0 {
+			t.Fatalf("event %s was changed by another user: %+v, %v", want.ID, got, err)
+		}
+	}
+
+	// Без user_id изменение отклоняется, владелец по-прежнему может изменить событие
+	for _, tt := range []struct {
+		body           string
+		expectedStatus int
+	}{
+		{body: `{"id": "` + id + `", "date": "2025-08-12", "title": "Mine"}`, expectedStatus: http.StatusUnprocessableEntity},
+		{body: `{"id": "` + id + `", "user_id": 1, "date": "2025-08-12", "title": "Mine"}`, expectedStatus: http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/api/update_event", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.expectedStatus {
+			t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+}
+
+// userToken возвращает JWT пользователя userID, подписанный секретом HS256
+func userToken(secret string, userID int) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]any{"sub": strconv.Itoa(userID), "exp": time.Now().Add(time.Hour).Unix()})
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTokenIdentity(t *testing.T) {
+	auth := middleware.Auth([]middleware.Authenticator{middleware.JWTAuth{Keys: []middleware.JWTKey{{Secret: []byte("secret")}}}})
+	router, service := setupTestRouter(auth)
+	token := "Bearer " + userToken("secret", 1)
+	other, _ := service.CreateEvent(newEvent(2, time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC), "Other"))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		authorization  string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "create without user_id", method: "POST", path: "/api/create_event", body: `{"date": "2025-08-11", "title": "Standup"}`, authorization: token, expectedStatus: http.StatusOK},
+		{name: "create with own user_id", method: "POST", path: "/api/create_event", body: `{"user_id": 1, "date": "2025-08-12", "title": "Retro"}`, authorization: token, expectedStatus: http.StatusOK},
+		{name: "create for other user", method: "POST", path: "/api/create_event", body: `{"user_id": 2, "date": "2025-08-11", "title": "Standup"}`, authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "day without user_id", method: "GET", path: "/api/events_for_day?date=2025-08-11", authorization: token, expectedStatus: http.StatusOK},
+		{name: "day of other user", method: "GET", path: "/api/events_for_day?user_id=2&date=2025-08-11", authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "range of other user", method: "GET", path: "/api/events_in_range?user_id=2&from=2025-08-01&to=2025-09-01", authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "export of other user", method: "GET", path: "/api/events.ics?user_id=2", authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "own time zone", method: "GET", path: "/api/time_zone", authorization: token, expectedStatus: http.StatusOK},
+		{name: "set time zone of other user", method: "POST", path: "/api/set_time_zone", body: `{"user_id": 2, "time_zone": "Europe/Moscow"}`, authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "batch for other user", method: "POST", path: "/api/batch_events", body: `{"mode": "best_effort", "operations": [{"op": "create", "user_id": 2, "date": "2025-08-11", "title": "Standup"}]}`, authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "delete event of other user", method: "POST", path: "/api/delete_event", body: `{"id": "` + string(other.ID) + `"}`, authorization: token, expectedStatus: http.StatusNotFound, expectedCode: "event_not_found"},
+		{name: "patch with malformed user_id", method: "PATCH", path: "/api/update_event", body: `{"id": "` + string(other.ID) + `", "user_id": "2", "title": "Mine"}`, authorization: token, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed"},
+		{name: "patch with object id", method: "PATCH", path: "/api/update_event", body: `{"id": {}, "title": "Mine"}`, authorization: token, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed"},
+		{name: "own resource", method: "GET", path: "/api/v1/users/1/events", authorization: token, expectedStatus: http.StatusOK},
+		{name: "resource of other user", method: "GET", path: "/api/v1/users/2/events", authorization: token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "token of other key", method: "GET", path: "/api/v1/users/1/events", authorization: "Bearer " + userToken("other", 1), expectedStatus: http.StatusUnauthorized, expectedCode: "unauthorized"},
+		{name: "no token", method: "GET", path: "/api/v1/users/1/events", expectedStatus: http.StatusUnauthorized, expectedCode: "unauthorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var problem response.Problem
+				json.Unmarshal(w.Body.Bytes(), &problem)
+				if problem.Code != tt.expectedCode {
+					t.Errorf("expected code %s, got %s", tt.expectedCode, problem.Code)
+				}
+			}
+		})
+	}
+
+	// События созданы от имени пользователя из токена
+	events, _ := service.ListEvents(1, time.Time{}, time.Time{})
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events of user 1, got %d", len(events))
+	}
+	if events, _ := service.ListEvents(2, time.Time{}, time.Time{}); len(events) != 1 {
+		t.Fatalf("expected only the existing event of user 2, got %d", len(events))
+	}
+}
+
+func TestIdempotencyPerUser(t *testing.T) {
+	auth := middleware.Auth([]middleware.Authenticator{middleware.JWTAuth{Keys: []middleware.JWTKey{{Secret: []byte("secret")}}}})
+	router, service := setupTestRouter(auth)
+	body := `{"date": "2025-08-11", "title": "Standup"}`
+
+	for _, userID := range []int{1, 2} {
+		req := httptest.NewRequest("POST", "/api/create_event", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+userToken("secret", userID))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "same-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("user %d: expected new event, got %d (replayed %q): %s", userID, w.Code, w.Header().Get("Idempotent-Replayed"), w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), `"user_id":`+strconv.Itoa(userID)) {
+			t.Fatalf("user %d: expected own event, got %s", userID, w.Body.String())
+		}
+	}
+
+	for _, userID := range []int{1, 2} {
+		if events, _ := service.ListEvents(userID, time.Time{}, time.Time{}); len(events) != 1 {
+			t.Fatalf("expected 1 event of user %d, got %d", userID, len(events))
+		}
+	}
+}
+
+func TestNewAuthenticators(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		apiKeys   bool
+		expected  int
+		expectErr bool
+	}{
+		{name: "basic", cfg: config.Config{HTTPServer: config.HTTPServer{User: "admin", Password: "secret"}}, expected: 1},
+		{name: "basic and jwt", cfg: config.Config{HTTPServer: config.HTTPServer{User: "admin", Password: "secret"}, JWT: config.JWT{Secret: "key"}}, expected: 2},
+		{name: "user without password", cfg: config.Config{HTTPServer: config.HTTPServer{User: "admin"}}, expectErr: true},
+		{name: "password without user", cfg: config.Config{HTTPServer: config.HTTPServer{Password: "secret"}}, expectErr: true},
+		{name: "nothing configured", cfg: config.Config{}, expectErr: true},
+		{name: "explicitly disabled", cfg: config.Config{Auth: config.Auth{Disabled: true}}, expected: 0},
+		{name: "api keys with basic", cfg: config.Config{HTTPServer: config.HTTPServer{User: "admin", Password: "secret"}}, apiKeys: true, expected: 2},
+		{name: "api keys without basic", cfg: config.Config{JWT: config.JWT{Secret: "key"}}, apiKeys: true, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys *apikey.Store
+			if tt.apiKeys {
+				keys, _ = apikey.Open(filepath.Join(t.TempDir(), "api_keys.json"))
+			}
+			authenticators, err := newAuthenticators(&tt.cfg, keys)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d authenticators", len(authenticators))
+				}
+				return
+			}
+			if err != nil || len(authenticators) != tt.expected {
+				t.Fatalf("expected %d authenticators, got %d, %v", tt.expected, len(authenticators), err)
+			}
+		})
+	}
+
+	// Ошибка настройки возвращается вызывающему, а не завершает процесс
+	if _, err := InitRoute(calendar.NewService(calendar.NewCalendar()), nil, &config.Config{}); err == nil {
+		t.Fatal("expected InitRoute to fail without authentication")
+	}
+}
+
+func TestLegacyEventIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cal := calendar.NewCalendar()
+	start := time.Date(2025, 8, 11, 10, 0, 0, 0, time.UTC)
+	// Событие, созданное до перехода на ULID
+	cal.Apply(calendar.Record{Op: calendar.OpPut, Event: calendar.Event{ID: "5", UserID: 1, Start: start, End: start.Add(time.Hour), TimeZone: "UTC", Title: "Legacy", Version: 1}})
+	service := calendar.NewService(cal)
+	handler := NewCalendarHandler(*service)
+
+	router := gin.New()
+	router.POST("/api/update_event", handler.UpdateEventHandler())
+	router.PATCH("/api/update_event", handler.PatchEventHandler())
+	router.POST("/api/delete_event", handler.DeleteEventHandler())
+	router.POST("/api/batch_events", handler.BatchEventsHandler())
+	handler.RegisterResourceRoutes(router.Group("/api/v1"))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedTitle  string
+	}{
+		{name: "numeric id", method: "POST", path: "/api/update_event", body: `{"id": 5, "user_id": 1, "date": "2025-08-12", "title": "Numeric"}`, expectedStatus: http.StatusOK, expectedTitle: "Numeric"},
+		{name: "string id", method: "PATCH", path: "/api/update_event", body: `{"id": "5", "user_id": 1, "title": "String"}`, expectedStatus: http.StatusOK, expectedTitle: "String"},
+		{name: "batch numeric id", method: "POST", path: "/api/batch_events", body: `{"operations": [{"op": "update", "id": 5, "user_id": 1, "date": "2025-08-13", "title": "Batch"}]}`, expectedStatus: http.StatusOK, expectedTitle: "Batch"},
+		{name: "resource path", method: "GET", path: "/api/v1/users/1/events/5", expectedStatus: http.StatusOK, expectedTitle: "Batch"},
+		{name: "zero id", method: "POST", path: "/api/update_event", body: `{"id": 0, "user_id": 1, "date": "2025-08-12", "title": "Zero"}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "negative id", method: "POST", path: "/api/delete_event", body: `{"id": -5, "user_id": 1}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "malformed path id", method: "GET", path: "/api/v1/users/1/events/abc", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "unknown ULID", method: "GET", path: "/api/v1/users/1/events/" + string(calendar.NewEventID()), expectedStatus: http.StatusNotFound},
+		{name: "delete by numeric id", method: "POST", path: "/api/delete_event", body: `{"id": 5, "user_id": 1}`, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedTitle != "" {
+				if event, _ := service.GetEvent("5"); event.Title != tt.expectedTitle {
+					t.Fatalf("expected title %q, got %q", tt.expectedTitle, event.Title)
+				}
+			}
+		})
+	}
+
+	if _, err := service.GetEvent("5"); err == nil {
+		t.Fatal("expected legacy event to be deleted")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	store, err := apikey.Open(filepath.Join(t.TempDir(), "api_keys.json"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	auth := middleware.Auth([]middleware.Authenticator{
+		middleware.BasicAuth{Source: middleware.StaticCredentials{User: "admin", Password: "secret"}, Realm: "wb-calendar"},
+		middleware.APIKeyAuth{Source: store},
+	})
+	router, _ := setupTestRouter(auth, middleware.EnforceScopes())
+	NewAPIKeyHandler(store).RegisterRoutes(router.Group("/api/v1"))
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret"))
+
+	do := func(method, path, body, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	issue := func(body string) IssuedAPIKey {
+		w := do("POST", "/api/v1/admin/api_keys", body, basic)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Result IssuedAPIKey `json:"result"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Result
+	}
+
+	reader := issue(`{"name": "dashboard", "user_id": 1, "scopes": ["events:read"]}`)
+	writer := issue(`{"name": "sync", "user_id": 1, "scopes": ["events:read", "events:write"]}`)
+	if reader.Token == "" || reader.ID == "" {
+		t.Fatalf("expected issued key, got %+v", reader)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		authorization  string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "read with read key", method: "GET", path: "/api/v1/users/1/events", authorization: "ApiKey " + reader.Token, expectedStatus: http.StatusOK},
+		{name: "write with read key", method: "POST", path: "/api/v1/users/1/events", body: `{"date": "2025-08-11", "title": "Standup"}`, authorization: "ApiKey " + reader.Token, expectedStatus: http.StatusForbidden, expectedCode: "insufficient_scope"},
+		{name: "write with write key", method: "POST", path: "/api/v1/users/1/events", body: `{"date": "2025-08-11", "title": "Standup"}`, authorization: "ApiKey " + writer.Token, expectedStatus: http.StatusCreated},
+		{name: "other user", method: "GET", path: "/api/v1/users/2/events", authorization: "ApiKey " + writer.Token, expectedStatus: http.StatusForbidden, expectedCode: "forbidden"},
+		{name: "admin with api key", method: "GET", path: "/api/v1/admin/api_keys", authorization: "ApiKey " + writer.Token, expectedStatus: http.StatusForbidden, expectedCode: "admin_required"},
+		{name: "unknown scope", method: "POST", path: "/api/v1/admin/api_keys", body: `{"name": "x", "user_id": 1, "scopes": ["events:admin"]}`, authorization: basic, expectedStatus: http.StatusUnprocessableEntity, expectedCode: "validation_failed"},
+		{name: "revoke", method: "DELETE", path: "/api/v1/admin/api_keys/" + reader.ID, authorization: basic, expectedStatus: http.StatusNoContent},
+		{name: "revoked key", method: "GET", path: "/api/v1/users/1/events", authorization: "ApiKey " + reader.Token, expectedStatus: http.StatusUnauthorized, expectedCode: "unauthorized"},
+		{name: "revoke unknown key", method: "DELETE", path: "/api/v1/admin/api_keys/missing", authorization: basic, expectedStatus: http.StatusNotFound, expectedCode: "api_key_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.body, tt.authorization)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var problem response.Problem
+				json.Unmarshal(w.Body.Bytes(), &problem)
+				if problem.Code != tt.expectedCode {
+					t.Errorf("expected code %s, got %s", tt.expectedCode, problem.Code)
+				}
+			}
+		})
+	}
+
+	// В списке нет секретов, зато видно использование и отзыв
+	w := do("GET", "/api/v1/admin/api_keys?user_id=1", "", basic)
+	if strings.Contains(w.Body.String(), reader.Token) || strings.Contains(w.Body.String(), `"hash"`) {
+		t.Fatalf("expected no secrets in list, got %s", w.Body.String())
+	}
+	var list struct {
+		Result []apikey.Key `json:"result"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Result) != 2 || list.Result[0].RevokedAt == nil || list.Result[1].LastUsedAt == nil {
+		t.Fatalf("expected revoked reader and used writer, got %+v", list.Result)
+	}
+}
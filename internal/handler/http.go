@@ -1,26 +1,148 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"wb-calendar/config"
+	"wb-calendar/internal/apikey"
+	"wb-calendar/internal/caldav"
 	"wb-calendar/internal/calendar"
 	"wb-calendar/internal/middleware"
+	"wb-calendar/pkg/response"
 
 	"github.com/gin-gonic/gin"
 )
 
-func InitRoute(service *calendar.Service) *gin.Engine {
+// healthPath проверка здоровья, доступная без аутентификации
+const healthPath = "/healthz"
+
+// InitRoute собирает маршруты сервера. keys — открытое хранилище ключей API
+// или nil, если ключи API не настроены; закрывает его вызывающий.
+func InitRoute(service *calendar.Service, keys *apikey.Store, cfg *config.Config) (*gin.Engine, error) {
+	authenticators, err := newAuthenticators(cfg, keys)
+	if err != nil {
+		return nil, fmt.Errorf("init authentication: %w", err)
+	}
+
 	r := gin.Default()
 
 	r.Use(middleware.LoggingMiddleware())
 
+	if len(authenticators) > 0 {
+		// Области ключей API проверяются сразу после аутентификации
+		r.Use(middleware.Auth(authenticators, healthPath), middleware.EnforceScopes())
+	} else {
+		log.Printf("authentication is disabled by auth.disabled: every endpoint is open")
+	}
+
+	r.GET(healthPath, HealthHandler())
+
 	calendarHandler := NewCalendarHandler(*service)
 
-	r.POST("/create_event", calendarHandler.CreateEventHandler())
+	// Повторы создания событий с тем же Idempotency-Key не создают дубликатов
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(cfg.Idempotency.TTL))
+
+	calendarHandler.RegisterResourceRoutes(r.Group("/api/v1"), idempotency)
+
+	// Устаревшие маршруты сохранены для совместимости
+	r.POST("/create_event", idempotency, calendarHandler.CreateEventHandler())
 	r.POST("/update_event", calendarHandler.UpdateEventHandler())
+	r.PATCH("/update_event", calendarHandler.PatchEventHandler())
 	r.POST("/delete_event", calendarHandler.DeleteEventHandler())
+	r.POST("/batch_events", idempotency, calendarHandler.BatchEventsHandler())
 
 	r.GET("/events_for_day", calendarHandler.GetEventsForDayHandler())
 	r.GET("/events_for_week", calendarHandler.GetEventsForWeekHandler())
 	r.GET("/events_for_month", calendarHandler.GetEventsForMonthHandler())
+	r.GET("/events_in_range", calendarHandler.GetEventsInRangeHandler())
+	r.GET("/events.ics", calendarHandler.ExportICSHandler())
+	r.POST("/import_ics", calendarHandler.ImportICSHandler())
+
+	r.GET("/time_zone", calendarHandler.GetTimeZoneHandler())
+	r.POST("/set_time_zone", calendarHandler.SetTimeZoneHandler())
+
+	caldav.NewHandler(service, "/caldav").Register(r)
+
+	if keys != nil {
+		NewAPIKeyHandler(keys).RegisterRoutes(r.Group("/api/v1"))
+	}
+
+	return r, nil
+}
+
+// newAuthenticators возвращает схемы аутентификации, настроенные в cfg,
+// и проверку ключей API, если хранилище ключей keys открыто.
+// Если не настроена ни одна схема, возвращает ошибку, кроме явного auth.disabled.
+func newAuthenticators(cfg *config.Config, keys *apikey.Store) ([]middleware.Authenticator, error) {
+	if (cfg.HTTPServer.User == "") != (cfg.HTTPServer.Password == "") {
+		return nil, errors.New("http_server.user and http_server.password must be set together")
+	}
+
+	var authenticators []middleware.Authenticator
+	if cfg.HTTPServer.User != "" {
+		authenticators = append(authenticators, middleware.BasicAuth{
+			Source: middleware.StaticCredentials{User: cfg.HTTPServer.User, Password: cfg.HTTPServer.Password},
+			Realm:  "wb-calendar",
+		})
+	}
+
+	jwt, err := jwtKeys(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if len(jwt) > 0 {
+		authenticators = append(authenticators, middleware.JWTAuth{
+			Keys:      jwt,
+			Issuer:    cfg.JWT.Issuer,
+			Audience:  cfg.JWT.Audience,
+			UserClaim: cfg.JWT.UserClaim,
+			Leeway:    cfg.JWT.Leeway,
+		})
+	}
+
+	if keys != nil {
+		// Ключами управляет только администратор, входящий по HTTP Basic
+		if cfg.HTTPServer.User == "" {
+			return nil, errors.New("api_keys.path requires http_server credentials to manage the keys")
+		}
+		authenticators = append(authenticators, middleware.APIKeyAuth{Source: keys})
+	}
+
+	if len(authenticators) == 0 && !cfg.Auth.Disabled {
+		return nil, errors.New("no authentication is configured: set http_server credentials, jwt keys or api keys, or auth.disabled to run without it")
+	}
+
+	return authenticators, nil
+}
+
+// jwtKeys загружает ключи проверки токенов из секрета, PEM-файла и файла JWKS
+func jwtKeys(cfg config.JWT) ([]middleware.JWTKey, error) {
+	var keys []middleware.JWTKey
+	if cfg.Secret != "" {
+		keys = append(keys, middleware.JWTKey{Secret: []byte(cfg.Secret)})
+	}
+	if cfg.PublicKeyFile != "" {
+		key, err := middleware.LoadRSAPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, middleware.JWTKey{PublicKey: key})
+	}
+	if cfg.JWKSFile != "" {
+		set, err := middleware.LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, set...)
+	}
+
+	return keys, nil
+}
 
-	return r
+// HealthHandler сообщает, что сервер запущен
+func HealthHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		response.JSONResult(ctx, "ok")
+	}
 }
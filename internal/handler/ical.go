@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"wb-calendar/internal/ical"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportICSHandler отдает события пользователя в формате iCalendar для подписки
+// из календарных приложений. Необязательные from и to ограничивают выборку
+// полуинтервалом [from, to); повторяющиеся серии выгружаются правилом RRULE.
+func (h *CalendarHandler) ExportICSHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var v pkg.ValidationError
+		userID, err := requestUser(ctx, ctx.Query("user_id"))
+		if errors.Is(err, pkg.ErrForbidden) {
+			response.Error(ctx, err)
+			return
+		}
+		if err != nil {
+			v.Add(err)
+		}
+
+		loc, err := h.queryLocation(ctx, userID, &v)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		from, to := parseRange(ctx, loc, &v)
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		events, err := h.service.ListEvents(userID, from, to)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := ical.Encode(&buf, events, time.Now()); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Header("Content-Disposition", `inline; filename="calendar.ics"`)
+		ctx.Data(http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+	}
+}
+
+// maxImportSize максимальный размер загружаемого файла .ics
+const maxImportSize = 10 << 20
+
+// ImportICSHandler импортирует события из файла iCalendar. Файл передается
+// телом запроса (text/calendar) или полем file формы multipart/form-data.
+// В ответе — число созданных и пропущенных событий и ошибки отдельных VEVENT.
+func (h *CalendarHandler) ImportICSHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, err := requestUser(ctx, ctx.Query("user_id"))
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		// Остальные ошибки импорта означают, что файл не удалось прочитать
+		if _, err := h.service.Location(userID, ""); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize)
+
+		var body io.Reader = ctx.Request.Body
+		if ctx.ContentType() == "multipart/form-data" {
+			file, err := ctx.FormFile("file")
+			if err != nil {
+				response.Error(ctx, importReadError(err, pkg.Required("file", "file field is required")))
+				return
+			}
+			f, err := file.Open()
+			if err != nil {
+				response.Error(ctx, err)
+				return
+			}
+			defer f.Close()
+			body = f
+		}
+
+		report, err := ical.Import(&h.service, userID, body)
+		if err != nil {
+			response.Error(ctx, importReadError(err, pkg.ErrInvalidBody.WithMessage(err.Error())))
+			return
+		}
+
+		response.JSONResult(ctx, report)
+	}
+}
+
+// importReadError заменяет ошибку чтения загружаемого файла на ошибку API:
+// превышение размера — на pkg.ErrTooLarge, остальные — на fallback
+func importReadError(err error, fallback error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return pkg.ErrTooLarge.WithMessage("file is too large")
+	}
+
+	return fallback
+}
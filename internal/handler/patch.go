@@ -0,0 +1,288 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MergePatchContentType тип тела JSON Merge Patch (RFC 7396)
+const MergePatchContentType = "application/merge-patch+json"
+
+// PatchUserEventHandler изменяет только переданные поля события по JSON Merge Patch.
+// Query-параметры scope и recurrence_id ограничивают изменение вхождениями серии.
+func (h *CalendarHandler) PatchUserEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		existing, ok := h.pathEvent(ctx)
+		if !ok {
+			return
+		}
+
+		fields, err := readMergePatch(ctx)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		var v pkg.ValidationError
+		patch := h.parseMergePatch(fields, existing, &v)
+		scope, occurrence, err := h.queryScope(ctx, existing.ID)
+		if err != nil {
+			v.Add(err)
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if patch.Version, err = ifMatch(ctx, existing); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		h.patchEvent(ctx, existing, patch, scope, occurrence)
+	}
+}
+
+// PatchEventHandler изменяет только переданные поля события по JSON Merge Patch.
+// Кроме полей события тело содержит id, user_id и, при необходимости,
+// scope и recurrence_id.
+func (h *CalendarHandler) PatchEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		fields, err := readMergePatch(ctx)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		var req DeleteEventRequest
+		for name, target := range map[string]*string{"scope": &req.Scope, "recurrence_id": &req.RecurrenceID} {
+			if raw, ok := fields[name]; ok {
+				if err := json.Unmarshal(raw, target); err != nil {
+					response.Error(ctx, pkg.Invalid(name, name+" must be a string"))
+					return
+				}
+				delete(fields, name)
+			}
+		}
+		for name, target := range map[string]any{"id": &req.ID, "user_id": &req.UserID} {
+			if raw, ok := fields[name]; ok {
+				if err := json.Unmarshal(raw, target); err != nil {
+					response.Error(ctx, pkg.Invalid(name, "invalid "+name))
+					return
+				}
+				delete(fields, name)
+			}
+		}
+
+		userID, err := actingUser(ctx, req.UserID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		var v pkg.ValidationError
+		existing, ok := h.requestEvent(ctx, userID, req.ID, &v)
+		if !ok {
+			return
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		patch := h.parseMergePatch(fields, existing, &v)
+		scope, err := calendar.ParseScope(req.Scope)
+		if err != nil {
+			v.Add(err)
+		}
+		var occurrence time.Time
+		if scope != calendar.ScopeAll && err == nil {
+			if occurrence, err = h.parseOccurrence(req.ID, req.RecurrenceID); err != nil {
+				v.Add(err)
+			}
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if patch.Version, err = ifMatch(ctx, existing); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		h.patchEvent(ctx, existing, patch, scope, occurrence)
+	}
+}
+
+// patchEvent применяет патч ко всей серии атомарно через Service.PatchEvent,
+// а к вхождениям — поверх вхождения, начинающегося в occurrence
+func (h *CalendarHandler) patchEvent(ctx *gin.Context, existing calendar.Event, patch calendar.EventPatch, scope calendar.Scope, occurrence time.Time) {
+	var updated calendar.Event
+	var err error
+
+	if scope == calendar.ScopeAll || !existing.IsRecurring() {
+		updated, err = h.service.PatchEvent(existing.UserID, existing.ID, patch)
+	} else {
+		base := existing
+		base.Start = occurrence.In(existing.Start.Location())
+		base.End = base.Start.Add(existing.Duration())
+
+		var event calendar.Event
+		if event, err = patch.Apply(base); err == nil {
+			event.Version = patch.Version
+			updated, err = h.service.UpdateOccurrences(event, occurrence, scope)
+		}
+	}
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	setETag(ctx, updated)
+	response.JSONResult(ctx, updated)
+}
+
+// readMergePatch читает тело JSON Merge Patch. Кроме application/merge-patch+json
+// принимается application/json.
+func readMergePatch(ctx *gin.Context) (map[string]json.RawMessage, error) {
+	if contentType := ctx.GetHeader("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != MergePatchContentType && mediaType != "application/json") {
+			return nil, pkg.ErrUnsupportedMediaType.WithMessage("expected " + MergePatchContentType)
+		}
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, pkg.ErrInvalidBody
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, pkg.ErrInvalidBody.WithMessage("merge patch must be a JSON object")
+	}
+
+	return fields, nil
+}
+
+// parseMergePatch переводит поля JSON Merge Patch в calendar.EventPatch.
+// Время разбирается так же, как при создании: для событий на весь день — как даты
+// в часовом поясе события. null в rrule отменяет повторение, null в time_zone
+// возвращает часовой пояс пользователя по умолчанию; остальные поля обязательны
+// и не могут быть null. Ошибки всех полей добавляются в v.
+func (h *CalendarHandler) parseMergePatch(fields map[string]json.RawMessage, event calendar.Event, v *pkg.ValidationError) calendar.EventPatch {
+	var patch calendar.EventPatch
+
+	str := func(name string) (*string, bool) {
+		raw, ok := fields[name]
+		if !ok {
+			return nil, false
+		}
+		if string(raw) == "null" {
+			return nil, true
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			v.Add(pkg.Invalid(name, name+" must be a string"))
+			return nil, false
+		}
+		return &value, true
+	}
+	required := func(name string) *string {
+		value, ok := str(name)
+		if ok && value == nil {
+			v.Add(pkg.Required(name, name+" cannot be null"))
+		}
+		return value
+	}
+
+	for name := range fields {
+		switch name {
+		case "start", "end", "all_day", "time_zone", "title", "rrule":
+		default:
+			v.Add(pkg.Invalid(name, "unknown field "+name))
+		}
+	}
+
+	if raw, ok := fields["all_day"]; ok {
+		var allDay bool
+		if err := json.Unmarshal(raw, &allDay); err != nil || string(raw) == "null" {
+			v.Add(pkg.Invalid("all_day", "all_day must be a boolean"))
+		} else {
+			patch.AllDay = &allDay
+		}
+	}
+
+	if timeZone, ok := str("time_zone"); ok {
+		if timeZone == nil {
+			userZone, err := h.service.TimeZone(event.UserID)
+			if err != nil {
+				v.Add(err)
+			}
+			timeZone = &userZone
+		}
+		if _, err := calendar.LoadLocation(*timeZone); err != nil {
+			v.Add(err)
+		} else {
+			patch.TimeZone = timeZone
+		}
+	}
+
+	patch.Title = required("title")
+	if rrule, ok := str("rrule"); ok {
+		if rrule == nil {
+			rrule = new(string)
+		}
+		patch.RRule = rrule
+	}
+
+	// Время разбирается в итоговом часовом поясе и с итоговым признаком all_day
+	allDay := event.AllDay
+	if patch.AllDay != nil {
+		allDay = *patch.AllDay
+	}
+	loc := event.Start.Location()
+	if patch.TimeZone != nil {
+		loc, _ = calendar.LoadLocation(*patch.TimeZone)
+	}
+	parse := parseTimestamp
+	if allDay {
+		parse = func(value string) (time.Time, error) { return parseDay(value, loc) }
+	}
+
+	for _, field := range []struct {
+		name   string
+		target **time.Time
+	}{{"start", &patch.Start}, {"end", &patch.End}} {
+		value := required(field.name)
+		if value == nil {
+			continue
+		}
+		t, err := parse(*value)
+		if err != nil {
+			v.Add(pkg.Invalid(field.name, "invalid "+field.name+" format, "+expectedFormat(allDay)))
+			continue
+		}
+		*field.target = &t
+	}
+
+	// Событие, ставшее событием на весь день, занимает целые дни
+	if allDay && !event.AllDay && patch.Start == nil {
+		start, _ := parseDay(event.Start.In(loc).Format(time.RFC3339), loc)
+		patch.Start = &start
+		if patch.End == nil {
+			end := start.AddDate(0, 0, 1)
+			patch.End = &end
+		}
+	}
+
+	return patch
+}
@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventRequest тело запроса на создание или замену события ресурса
+// /api/v1/users/{user_id}/events. Поля имеют тот же смысл, что и в CreateEventRequest.
+type EventRequest struct {
+	Date     string `json:"date,omitempty"`
+	Start    string `json:"start,omitempty"`
+	End      string `json:"end,omitempty"`
+	AllDay   bool   `json:"all_day,omitempty"`
+	TimeZone string `json:"time_zone,omitempty"`
+	Title    string `json:"title"`
+	RRule    string `json:"rrule,omitempty"`
+}
+
+// RegisterResourceRoutes подключает ресурс событий пользователя
+// /users/{user_id}/events к группе r, например /api/v1.
+// Обработчики create выполняются перед созданием событий, в том числе пакетом.
+func (h *CalendarHandler) RegisterResourceRoutes(r gin.IRouter, create ...gin.HandlerFunc) {
+	events := r.Group("/users/:user_id/events")
+
+	events.GET("", h.ListUserEventsHandler())
+	events.POST("", append(create, h.CreateUserEventHandler())...)
+	events.POST("/batch", append(create, h.BatchUserEventsHandler())...)
+	events.GET("/:id", h.GetUserEventHandler())
+	events.PUT("/:id", h.ReplaceUserEventHandler())
+	events.PATCH("/:id", h.PatchUserEventHandler())
+	events.DELETE("/:id", h.DeleteUserEventHandler())
+}
+
+// ListUserEventsHandler возвращает события пользователя. С query-параметрами
+// from и to возвращаются события и вхождения серий в [from, to), без них —
+// все сохраненные события без разворачивания серий.
+func (h *CalendarHandler) ListUserEventsHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := pathUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var v pkg.ValidationError
+		loc, err := h.queryLocation(ctx, userID, &v)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if (ctx.Query("from") == "") != (ctx.Query("to") == "") {
+			v.Add(pkg.Required("to", "from and to parameters must be used together"))
+		}
+		from, to := parseRange(ctx, loc, &v)
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		var events []calendar.Event
+		if from.IsZero() {
+			events, err = h.service.ListEvents(userID, from, to)
+		} else {
+			events, err = h.service.GetEventsInRange(userID, from, to)
+		}
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		response.JSONResult(ctx, events)
+	}
+}
+
+// GetUserEventHandler возвращает событие пользователя по ID
+func (h *CalendarHandler) GetUserEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		event, ok := h.pathEvent(ctx)
+		if !ok || notModified(ctx, event) {
+			return
+		}
+
+		setETag(ctx, event)
+		response.JSONResult(ctx, event)
+	}
+}
+
+// CreateUserEventHandler создает событие пользователя и отвечает 201 Created
+// с адресом нового ресурса в заголовке Location
+func (h *CalendarHandler) CreateUserEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := pathUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var req EventRequest
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+			response.Error(ctx, pkg.ErrInvalidBody.WithMessage("invalid JSON request body"))
+			return
+		}
+
+		loc, err := h.service.Location(userID, req.TimeZone)
+		if err != nil && !errors.Is(err, pkg.ErrInvalidTimeZone) {
+			response.Error(ctx, err)
+			return
+		}
+
+		start, end, allDay, err := validateEventRequest(req, loc, err)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		event, err := h.service.CreateEvent(calendar.Event{
+			UserID:   userID,
+			Start:    start,
+			End:      end,
+			AllDay:   allDay,
+			TimeZone: loc.String(),
+			Title:    req.Title,
+			RRule:    req.RRule,
+		})
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Header("Location", eventLocation(ctx, event.ID))
+		setETag(ctx, event)
+		response.JSONResultStatus(ctx, http.StatusCreated, event)
+	}
+}
+
+// ReplaceUserEventHandler заменяет событие пользователя целиком. Query-параметры
+// scope и recurrence_id ограничивают изменение вхождениями серии.
+func (h *CalendarHandler) ReplaceUserEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		existing, ok := h.pathEvent(ctx)
+		if !ok {
+			return
+		}
+
+		var req EventRequest
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
+			response.Error(ctx, pkg.ErrInvalidBody.WithMessage("invalid JSON request body"))
+			return
+		}
+
+		timeZone := req.TimeZone
+		if timeZone == "" {
+			timeZone = existing.TimeZone
+		}
+		loc, err := calendar.LoadLocation(timeZone)
+
+		start, end, allDay, err := validateEventRequest(req, loc, err)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		version, err := ifMatch(ctx, existing)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		h.updateUserEvent(ctx, calendar.Event{
+			ID:       existing.ID,
+			UserID:   existing.UserID,
+			Start:    start,
+			End:      end,
+			AllDay:   allDay,
+			TimeZone: loc.String(),
+			Title:    req.Title,
+			RRule:    req.RRule,
+			Version:  version,
+		})
+	}
+}
+
+// DeleteUserEventHandler удаляет событие пользователя и отвечает 204 No Content.
+// Query-параметры scope и recurrence_id ограничивают удаление вхождениями серии.
+func (h *CalendarHandler) DeleteUserEventHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		existing, ok := h.pathEvent(ctx)
+		if !ok {
+			return
+		}
+
+		scope, occurrence, err := h.queryScope(ctx, existing.ID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		version, err := ifMatch(ctx, existing)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if scope != calendar.ScopeAll {
+			err = h.service.DeleteOccurrences(existing.UserID, existing.ID, version, occurrence, scope)
+		} else {
+			err = h.service.DeleteEvent(existing.UserID, existing.ID, version)
+		}
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// updateUserEvent сохраняет изменения события с учетом scope и recurrence_id
+// из query-параметров и отвечает итоговым событием
+func (h *CalendarHandler) updateUserEvent(ctx *gin.Context, event calendar.Event) {
+	scope, occurrence, err := h.queryScope(ctx, event.ID)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	var updated calendar.Event
+	if scope != calendar.ScopeAll {
+		updated, err = h.service.UpdateOccurrences(event, occurrence, scope)
+	} else if err = h.service.UpdateEvent(event); err == nil {
+		updated, err = h.service.GetEvent(event.ID)
+	}
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	setETag(ctx, updated)
+	response.JSONResult(ctx, updated)
+}
+
+// queryScope читает область изменения серии из query-параметров scope и recurrence_id
+func (h *CalendarHandler) queryScope(ctx *gin.Context, id calendar.EventID) (calendar.Scope, time.Time, error) {
+	var v pkg.ValidationError
+	scope, err := calendar.ParseScope(ctx.Query("scope"))
+	if err != nil {
+		v.Add(err)
+	}
+
+	var occurrence time.Time
+	if scope != calendar.ScopeAll && err == nil {
+		if occurrence, err = h.parseOccurrence(id, ctx.Query("recurrence_id")); err != nil {
+			v.Add(err)
+		}
+	}
+
+	return scope, occurrence, v.Err()
+}
+
+// validateEventRequest проверяет тело запроса и разбирает время события в поясе loc.
+// locErr — ошибка определения пояса, она попадает в общий список ошибок.
+func validateEventRequest(req EventRequest, loc *time.Location, locErr error) (time.Time, time.Time, bool, error) {
+	var v pkg.ValidationError
+	if req.Title == "" {
+		v.Add(pkg.Required("title", "title cannot be empty"))
+	}
+	if locErr != nil {
+		v.Add(locErr)
+		loc = time.UTC
+	}
+
+	start, end, allDay, err := parseEventTimes(req.Date, req.Start, req.End, req.AllDay, loc)
+	if err != nil {
+		v.Add(err)
+	}
+
+	return start, end, allDay, v.Err()
+}
+
+// pathUserID читает user_id из пути запроса. При аутентификации токеном
+// путь должен указывать на пользователя из токена.
+func pathUserID(ctx *gin.Context) (int, bool) {
+	userID, err := requestUser(ctx, ctx.Param("user_id"))
+	if err != nil {
+		response.Error(ctx, err)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// pathEvent загружает событие из пути запроса. Событие другого пользователя
+// считается отсутствующим.
+func (h *CalendarHandler) pathEvent(ctx *gin.Context) (calendar.Event, bool) {
+	userID, ok := pathUserID(ctx)
+	if !ok {
+		return calendar.Event{}, false
+	}
+
+	id, err := calendar.ParseEventID(ctx.Param("id"))
+	if err != nil {
+		response.Error(ctx, err)
+		return calendar.Event{}, false
+	}
+
+	event, err := h.service.GetUserEvent(userID, id)
+	if err != nil {
+		response.Error(ctx, err)
+		return calendar.Event{}, false
+	}
+
+	return event, true
+}
+
+// eventLocation возвращает адрес ресурса события в коллекции запроса
+func eventLocation(ctx *gin.Context, id calendar.EventID) string {
+	path := ctx.Request.URL.Path
+	if len(path) > 0 && path[len(path)-1] == '/' {
+		path = path[:len(path)-1]
+	}
+
+	return path + "/" + string(id)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetTimeZoneRequest структура для установки часового пояса пользователя
+type SetTimeZoneRequest struct {
+	UserID   int    `json:"user_id" form:"user_id"`
+	TimeZone string `json:"time_zone" form:"time_zone"`
+}
+
+// GetTimeZoneHandler возвращает часовой пояс пользователя по умолчанию
+func (h *CalendarHandler) GetTimeZoneHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, err := requestUser(ctx, ctx.Query("user_id"))
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		timeZone, err := h.service.TimeZone(userID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		response.JSONResult(ctx, SetTimeZoneRequest{UserID: userID, TimeZone: timeZone})
+	}
+}
+
+// SetTimeZoneHandler задает часовой пояс пользователя по умолчанию
+func (h *CalendarHandler) SetTimeZoneHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req SetTimeZoneRequest
+
+		// Поддерживаем оба формата: JSON и form
+		if err := bindRequest(ctx, &req); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		userID, err := actingUser(ctx, req.UserID)
+		if err != nil {
+			response.Error(ctx, err)
+			return
+		}
+		req.UserID = userID
+
+		var v pkg.ValidationError
+		if req.UserID <= 0 {
+			v.Add(pkg.Invalid("user_id", "user_id must be positive"))
+		}
+		if req.TimeZone == "" {
+			v.Add(pkg.Required("time_zone", "time_zone cannot be empty"))
+		} else if _, err := calendar.LoadLocation(req.TimeZone); err != nil {
+			v.Add(err)
+		}
+		if err := v.Err(); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		if err := h.service.SetTimeZone(req.UserID, req.TimeZone); err != nil {
+			response.Error(ctx, err)
+			return
+		}
+
+		response.JSONResult(ctx, "time zone updated successfully")
+	}
+}
@@ -0,0 +1,402 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+// maxContentLine максимальная длина строки контента после склейки переносов
+const maxContentLine = 1 << 20
+
+var (
+	// ErrNotCalendar данные не содержат VCALENDAR
+	ErrNotCalendar = errors.New("ical: no VCALENDAR found")
+	// ErrUnterminated компонент не закрыт строкой END
+	ErrUnterminated = errors.New("ical: unterminated component")
+)
+
+// Entry событие, прочитанное из VEVENT. Если событие не удалось разобрать,
+// Err содержит причину, а Event заполнен частично.
+type Entry struct {
+	// Line номер строки BEGIN:VEVENT
+	Line  int
+	Event calendar.Event
+	// RecurrenceID начало заменяемого вхождения серии, если VEVENT его изменяет
+	RecurrenceID *time.Time
+	Err          error
+
+	// duration значение DURATION, если DTEND не задан
+	duration *time.Duration
+}
+
+// property строка контента: имя, параметры и значение
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+	line   int
+}
+
+// Decode читает VEVENT из VCALENDAR. Время без часового пояса и даты
+// считаются в loc. Ошибки отдельных событий возвращаются в Entry.Err,
+// ошибка Decode означает, что данные не являются календарем.
+func Decode(r io.Reader, loc *time.Location) ([]Entry, error) {
+	props, err := readProperties(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries  []Entry
+		stack    []string
+		current  *Entry
+		found    bool
+		eventErr error
+	)
+
+	for _, prop := range props {
+		switch prop.name {
+		case "BEGIN":
+			component := strings.ToUpper(prop.value)
+			stack = append(stack, component)
+			if component == "VCALENDAR" {
+				found = true
+			}
+			if component == "VEVENT" && len(stack) == 2 {
+				current = &Entry{Line: prop.line}
+				eventErr = nil
+			}
+			continue
+		case "END":
+			component := strings.ToUpper(prop.value)
+			if len(stack) == 0 || stack[len(stack)-1] != component {
+				return nil, fmt.Errorf("ical: line %d: unexpected END:%s", prop.line, prop.value)
+			}
+			stack = stack[:len(stack)-1]
+			if component == "VEVENT" && current != nil && len(stack) == 1 {
+				if eventErr == nil {
+					eventErr = finishEvent(current)
+				}
+				current.Err = eventErr
+				entries = append(entries, *current)
+				current = nil
+			}
+			continue
+		}
+
+		// Свойства вложенных компонентов (VALARM) и VTIMEZONE не нужны
+		if current == nil || len(stack) != 2 || eventErr != nil {
+			continue
+		}
+		eventErr = applyProperty(current, prop, loc)
+	}
+
+	if len(stack) != 0 {
+		return nil, ErrUnterminated
+	}
+	if !found {
+		return nil, ErrNotCalendar
+	}
+
+	return entries, nil
+}
+
+// applyProperty переносит свойство VEVENT в событие
+func applyProperty(entry *Entry, prop property, loc *time.Location) error {
+	event := &entry.Event
+
+	switch prop.name {
+	case "UID":
+		event.UID = unescapeText(prop.value)
+	case "SUMMARY":
+		event.Title = unescapeText(prop.value)
+	case "DTSTART":
+		start, allDay, zone, err := parseTime(prop, loc)
+		if err != nil {
+			return err
+		}
+		event.Start, event.AllDay, event.TimeZone = start, allDay, zone
+	case "DTEND":
+		end, _, _, err := parseTime(prop, loc)
+		if err != nil {
+			return err
+		}
+		event.End = end
+	case "DURATION":
+		d, err := parseDuration(prop.value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", prop.line, err)
+		}
+		// DTEND вычисляется после разбора всех свойств
+		entry.duration = &d
+	case "RRULE":
+		event.RRule = prop.value
+	case "EXDATE":
+		for _, value := range strings.Split(prop.value, ",") {
+			exDate, _, _, err := parseTime(property{name: prop.name, params: prop.params, value: value, line: prop.line}, loc)
+			if err != nil {
+				return err
+			}
+			event.ExDates = append(event.ExDates, exDate)
+		}
+	case "RECURRENCE-ID":
+		occurrence, _, _, err := parseTime(prop, loc)
+		if err != nil {
+			return err
+		}
+		entry.RecurrenceID = &occurrence
+	}
+
+	return nil
+}
+
+// finishEvent проверяет обязательные свойства и вычисляет конец события
+func finishEvent(entry *Entry) error {
+	event := &entry.Event
+
+	if event.Start.IsZero() {
+		return errors.New("DTSTART is required")
+	}
+	if event.Title == "" {
+		event.Title = "(no title)"
+	}
+
+	switch {
+	case !event.End.IsZero():
+	case entry.duration != nil:
+		d := *entry.duration
+		if event.AllDay && d%(24*time.Hour) == 0 {
+			event.End = event.Start.AddDate(0, 0, int(d/(24*time.Hour)))
+		} else {
+			event.End = event.Start.Add(d)
+		}
+	case event.AllDay:
+		// Без DTEND событие на весь день длится день, а со временем — ноль секунд
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.End = event.Start
+	}
+
+	return nil
+}
+
+// parseTime разбирает значение DATE или DATE-TIME с учетом TZID
+// и возвращает время, признак даты и имя часового пояса
+func parseTime(prop property, loc *time.Location) (time.Time, bool, string, error) {
+	value := prop.value
+
+	if tzid, ok := prop.params["TZID"]; ok {
+		zone, err := calendar.LoadLocation(strings.TrimPrefix(tzid, "/"))
+		if err != nil {
+			return time.Time{}, false, "", fmt.Errorf("line %d: unknown TZID %q", prop.line, tzid)
+		}
+		loc = zone
+	}
+
+	if prop.params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		t, err := time.ParseInLocation(dateLayout, value, loc)
+		if err != nil {
+			return time.Time{}, false, "", fmt.Errorf("line %d: invalid %s date %q", prop.line, prop.name, value)
+		}
+		return t, true, loc.String(), nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeLayout+"Z", value)
+		if err != nil {
+			return time.Time{}, false, "", fmt.Errorf("line %d: invalid %s %q", prop.line, prop.name, value)
+		}
+		return t, false, calendar.DefaultTimeZone, nil
+	}
+
+	t, err := time.ParseInLocation(dateTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, false, "", fmt.Errorf("line %d: invalid %s %q", prop.line, prop.name, value)
+	}
+
+	return t, false, loc.String(), nil
+}
+
+// parseDuration разбирает длительность RFC 5545, например P1D, PT1H30M или P2W
+func parseDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(value, "+")
+	if strings.HasPrefix(s, "-") || !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid DURATION %q", value)
+	}
+	s = s[1:]
+
+	var (
+		total  time.Duration
+		inTime bool
+		digits string
+	)
+	units := map[bool]map[byte]time.Duration{
+		false: {'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour},
+		true:  {'H': time.Hour, 'M': time.Minute, 'S': time.Second},
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 'T' && !inTime && digits == "":
+			inTime = true
+		case c >= '0' && c <= '9':
+			digits += string(c)
+		default:
+			unit, ok := units[inTime][c]
+			n, err := strconv.Atoi(digits)
+			if !ok || err != nil {
+				return 0, fmt.Errorf("invalid DURATION %q", value)
+			}
+			total += time.Duration(n) * unit
+			digits = ""
+		}
+	}
+
+	if digits != "" || s == "" {
+		return 0, fmt.Errorf("invalid DURATION %q", value)
+	}
+
+	return total, nil
+}
+
+// readProperties читает строки контента, склеивая перенесенные строки
+func readProperties(r io.Reader) ([]property, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxContentLine)
+
+	var (
+		props   []property
+		builder strings.Builder
+		start   int
+		number  int
+	)
+
+	flush := func() error {
+		if builder.Len() == 0 {
+			return nil
+		}
+		prop, err := parseProperty(builder.String())
+		if err != nil {
+			return fmt.Errorf("ical: line %d: %w", start, err)
+		}
+		prop.line = start
+		props = append(props, prop)
+		builder.Reset()
+		return nil
+	}
+
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if number == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if builder.Len()+len(line) > maxContentLine {
+				return nil, fmt.Errorf("ical: line %d: content line too long", number)
+			}
+			builder.WriteString(line[1:])
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		if line != "" {
+			builder.WriteString(line)
+			start = number
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ical: read: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return props, nil
+}
+
+// parseProperty разбирает строку вида NAME;PARAM=value;PARAM="a:b":VALUE
+func parseProperty(line string) (property, error) {
+	prop := property{params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return property{}, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		rest := line[i+1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return property{}, fmt.Errorf("malformed parameter in %q", line)
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return property{}, fmt.Errorf("unterminated quoted parameter in %q", line)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return property{}, fmt.Errorf("missing value in %q", line)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		if name == "VALUE" {
+			value = strings.ToUpper(value)
+		}
+		prop.params[name] = value
+
+		i = len(line) - len(rest)
+		if i >= len(line) {
+			return property{}, fmt.Errorf("missing value in %q", line)
+		}
+	}
+
+	if line[i] != ':' {
+		return property{}, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.value = line[i+1:]
+
+	return prop, nil
+}
+
+// unescapeText отменяет экранирование значения типа TEXT
+func unescapeText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
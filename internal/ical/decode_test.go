@@ -0,0 +1,133 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/recurring.ics")
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+
+	entries, err := Decode(bytes.NewReader(data), time.UTC)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	series := entries[0]
+	if series.Err != nil || series.Event.UID != "1@wb-calendar" || series.Event.TimeZone != "Europe/Berlin" {
+		t.Fatalf("unexpected series: %+v", series)
+	}
+	if series.Event.Start.Hour() != 10 || series.Event.Duration() != 15*time.Minute {
+		t.Fatalf("unexpected series times: %v - %v", series.Event.Start, series.Event.End)
+	}
+	if series.Event.RRule != "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240630T215959Z" || len(series.Event.ExDates) != 1 {
+		t.Fatalf("unexpected recurrence: %q %v", series.Event.RRule, series.Event.ExDates)
+	}
+
+	override := entries[1]
+	if override.RecurrenceID == nil || override.RecurrenceID.Day() != 15 || override.Event.UID != series.Event.UID {
+		t.Fatalf("unexpected override: %+v", override)
+	}
+
+	report := entries[2]
+	if !report.Event.AllDay || report.Event.End.Sub(report.Event.Start) != 24*time.Hour {
+		t.Fatalf("unexpected all-day event: %+v", report.Event)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{name: "not a calendar", data: "hello\r\n", err: nil},
+		{name: "no calendar", data: "BEGIN:VEVENT\r\nEND:VEVENT\r\n", err: ErrNotCalendar},
+		{name: "unterminated", data: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n", err: ErrUnterminated},
+		{name: "mismatched end", data: "BEGIN:VCALENDAR\r\nEND:VEVENT\r\n", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(strings.NewReader(tt.data), time.UTC)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	service := calendar.NewService(calendar.NewCalendar())
+	if err := service.SetTimeZone(1, "Europe/Moscow"); err != nil {
+		t.Fatalf("SetTimeZone failed: %v", err)
+	}
+
+	data, err := os.ReadFile("testdata/import.ics")
+	if err != nil {
+		t.Fatalf("failed to read test data: %v", err)
+	}
+
+	report, err := Import(service, 1, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Created != 4 || report.Skipped != 1 || len(report.Errors) != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for i, uid := range []string{"broken@example.com", "mars@example.com", "yearly@example.com"} {
+		if report.Errors[i].UID != uid || report.Errors[i].Line == 0 {
+			t.Errorf("expected error for %s, got %+v", uid, report.Errors[i])
+		}
+	}
+
+	standup, err := service.GetEventByUID(1, "standup@example.com")
+	if err != nil {
+		t.Fatalf("expected imported series: %v", err)
+	}
+	if standup.Title != "Daily stand-up with a very long title that is folded across several content lines by the exporting tool" {
+		t.Fatalf("unexpected unfolded title %q", standup.Title)
+	}
+
+	events, _ := service.GetEventsInRange(1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC))
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Start.UTC().Format("02 15:04")+" "+event.Title[:8])
+	}
+	want := []string{"01 09:00 Daily st", "05 09:00 Daily st", "08 10:00 Stand-up", "10 09:00 Daily st", "12 09:00 Daily st"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	holiday, _ := service.GetEventByUID(1, "holiday@example.com")
+	if !holiday.AllDay || holiday.TimeZone != "Europe/Moscow" || holiday.Title != "Labour Day, office closed" {
+		t.Fatalf("unexpected all-day event: %+v", holiday)
+	}
+	trip, _ := service.GetEventByUID(1, "trip@example.com")
+	if trip.Duration() != 60*time.Hour || trip.TimeZone != "UTC" {
+		t.Fatalf("unexpected DURATION handling: %+v", trip)
+	}
+
+	// Повторный импорт пропускает уже загруженные события
+	report, err = Import(service, 1, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("second Import failed: %v", err)
+	}
+	if report.Created != 0 || report.Skipped != 5 || len(report.Errors) != 3 {
+		t.Fatalf("unexpected report on re-import: %+v", report)
+	}
+}
@@ -0,0 +1,243 @@
+// Package ical преобразует события календаря в формат iCalendar (RFC 5545)
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wb-calendar/internal/calendar"
+)
+
+const (
+	// ProdID идентификатор программы, создавшей календарь
+	ProdID = "-//wb-calendar//wb-calendar 1.0//EN"
+	// UIDDomain домен, которым дополняются ID событий в UID
+	UIDDomain = "wb-calendar"
+
+	// maxLineLength максимальная длина строки в октетах без CRLF
+	maxLineLength = 75
+
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+)
+
+// UID возвращает глобальный идентификатор события: импортированный UID или
+// ID события с доменом. Измененное вхождение получает UID своей серии
+// из uids и отличается от нее RECURRENCE-ID.
+func UID(event calendar.Event, uids map[calendar.EventID]string) string {
+	if event.IsOverride() {
+		if uid, ok := uids[event.SeriesID]; ok {
+			return uid
+		}
+		return fmt.Sprintf("%s@%s", event.SeriesID, UIDDomain)
+	}
+	if event.UID != "" {
+		return event.UID
+	}
+
+	return fmt.Sprintf("%s@%s", event.ID, UIDDomain)
+}
+
+// Encode записывает события в w как VCALENDAR. stamp используется как DTSTAMP.
+// Для каждого часового пояса событий, кроме UTC, добавляется VTIMEZONE.
+func Encode(w io.Writer, events []calendar.Event, stamp time.Time) error {
+	lw := &lineWriter{w: bufio.NewWriter(w)}
+
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + ProdID)
+	lw.line("CALSCALE:GREGORIAN")
+
+	for _, zone := range timeZones(events) {
+		writeTimeZone(lw, zone.loc, zone.year-1)
+	}
+
+	// Отмененные вхождения, замененные измененными, не попадают в EXDATE:
+	// иначе некоторые клиенты скрывают и само измененное вхождение
+	overridden := make(map[calendar.EventID][]time.Time)
+	uids := make(map[calendar.EventID]string)
+	for _, event := range events {
+		if event.IsOverride() && event.OccurrenceStart != nil {
+			overridden[event.SeriesID] = append(overridden[event.SeriesID], *event.OccurrenceStart)
+		} else {
+			uids[event.ID] = UID(event, nil)
+		}
+	}
+
+	for _, event := range events {
+		writeEvent(lw, event, UID(event, uids), overridden[event.ID], stamp)
+	}
+
+	lw.line("END:VCALENDAR")
+	return lw.flush()
+}
+
+func writeEvent(lw *lineWriter, event calendar.Event, uid string, overridden []time.Time, stamp time.Time) {
+	loc := location(event)
+
+	lw.line("BEGIN:VEVENT")
+	lw.line("UID:" + escapeText(uid))
+	lw.line("DTSTAMP:" + stamp.UTC().Format(dateTimeLayout) + "Z")
+	if event.IsOverride() && event.OccurrenceStart != nil {
+		lw.line("RECURRENCE-ID" + formatTime(*event.OccurrenceStart, loc, event.AllDay))
+	}
+	lw.line("DTSTART" + formatTime(event.Start, loc, event.AllDay))
+	// Без DTEND событие со временем длится ноль секунд, а на весь день — один день
+	if !event.End.Equal(event.Start) {
+		lw.line("DTEND" + formatTime(event.End, loc, event.AllDay))
+	}
+	lw.line("SUMMARY:" + escapeText(event.Title))
+
+	if event.IsRecurring() {
+		if rule, err := calendar.ParseRRule(event.RRule); err == nil {
+			rule.ResolveUntil(loc, event.AllDay)
+			lw.line("RRULE:" + rule.String())
+		}
+
+		var exDates []time.Time
+		for _, exDate := range event.ExDates {
+			if !containsTime(overridden, exDate) {
+				exDates = append(exDates, exDate)
+			}
+		}
+		if len(exDates) > 0 {
+			lw.line("EXDATE" + formatTimes(exDates, loc, event.AllDay))
+		}
+	}
+
+	lw.line("END:VEVENT")
+}
+
+// formatTime возвращает параметры и значение свойства даты, начиная с ; или :
+func formatTime(t time.Time, loc *time.Location, allDay bool) string {
+	return formatTimes([]time.Time{t}, loc, allDay)
+}
+
+func formatTimes(times []time.Time, loc *time.Location, allDay bool) string {
+	values := make([]string, len(times))
+	for i, t := range times {
+		t = t.In(loc)
+		switch {
+		case allDay:
+			values[i] = t.Format(dateLayout)
+		case loc == time.UTC:
+			values[i] = t.Format(dateTimeLayout) + "Z"
+		default:
+			values[i] = t.Format(dateTimeLayout)
+		}
+	}
+
+	value := strings.Join(values, ",")
+	switch {
+	case allDay:
+		return ";VALUE=DATE:" + value
+	case loc == time.UTC:
+		return ":" + value
+	default:
+		return ";TZID=" + loc.String() + ":" + value
+	}
+}
+
+// escapeText экранирует значение типа TEXT
+func escapeText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(value)
+}
+
+func location(event calendar.Event) *time.Location {
+	loc, err := calendar.LoadLocation(event.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, other := range times {
+		if other.Equal(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zoneUsage часовой пояс событий и самый ранний год, в котором он используется
+type zoneUsage struct {
+	loc  *time.Location
+	year int
+}
+
+// timeZones возвращает часовые пояса событий со временем, кроме UTC, по имени
+func timeZones(events []calendar.Event) []zoneUsage {
+	zones := make(map[string]zoneUsage)
+	for _, event := range events {
+		loc := location(event)
+		if event.AllDay || loc == time.UTC {
+			continue
+		}
+
+		year := event.Start.In(loc).Year()
+		if event.OccurrenceStart != nil {
+			year = min(year, event.OccurrenceStart.In(loc).Year())
+		}
+		if zone, ok := zones[loc.String()]; !ok || year < zone.year {
+			zones[loc.String()] = zoneUsage{loc: loc, year: year}
+		}
+	}
+
+	result := make([]zoneUsage, 0, len(zones))
+	for _, zone := range zones {
+		result = append(result, zone)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].loc.String() < result[j].loc.String() })
+
+	return result
+}
+
+// lineWriter пишет строки контента, разделенные CRLF, и переносит строки
+// длиннее 75 октетов, не разрывая символы UTF-8
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	limit := maxLineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		lw.write(s[:cut] + "\r\n ")
+		s = s[cut:]
+		// Пробел в начале строки продолжения тоже занимает октет
+		limit = maxLineLength - 1
+	}
+	lw.write(s + "\r\n")
+}
+
+func (lw *lineWriter) write(s string) {
+	if lw.err == nil {
+		_, lw.err = lw.w.WriteString(s)
+	}
+}
+
+func (lw *lineWriter) flush() error {
+	if lw.err != nil {
+		return lw.err
+	}
+
+	return lw.w.Flush()
+}
@@ -0,0 +1,151 @@
+package ical
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+var stamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestEncodeGolden(t *testing.T) {
+	berlin, _ := calendar.LoadLocation("Europe/Berlin")
+	moscow, _ := calendar.LoadLocation("Europe/Moscow")
+	moved := time.Date(2024, 1, 15, 9, 0, 0, 0, berlin)
+
+	tests := []struct {
+		name   string
+		events []calendar.Event
+	}{
+		{
+			name:   "empty",
+			events: nil,
+		},
+		{
+			name: "single",
+			events: []calendar.Event{
+				{
+					ID:       "1",
+					UserID:   1,
+					Start:    time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC),
+					End:      time.Date(2024, 1, 10, 15, 30, 0, 0, time.UTC),
+					TimeZone: "UTC",
+					Title:    "Planning; budget, Q1\nRoom 4\\2",
+				},
+				{
+					ID:       "2",
+					UserID:   1,
+					Start:    time.Date(2024, 1, 11, 0, 0, 0, 0, moscow),
+					End:      time.Date(2024, 1, 13, 0, 0, 0, 0, moscow),
+					AllDay:   true,
+					TimeZone: "Europe/Moscow",
+					Title:    "Командировка в Санкт-Петербург для обсуждения планов развития календаря на год",
+				},
+				{
+					ID:       "3",
+					UserID:   1,
+					Start:    time.Date(2024, 1, 12, 18, 0, 0, 0, moscow),
+					End:      time.Date(2024, 1, 12, 18, 0, 0, 0, moscow),
+					TimeZone: "Europe/Moscow",
+					Title:    "Reminder",
+				},
+			},
+		},
+		{
+			name: "recurring",
+			events: []calendar.Event{
+				{
+					ID:       "1",
+					UserID:   1,
+					Start:    time.Date(2024, 1, 1, 10, 0, 0, 0, berlin),
+					End:      time.Date(2024, 1, 1, 10, 15, 0, 0, berlin),
+					TimeZone: "Europe/Berlin",
+					Title:    "Stand-up",
+					RRule:    "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240630T235959",
+					ExDates: []time.Time{
+						time.Date(2024, 1, 8, 10, 0, 0, 0, berlin),
+						time.Date(2024, 1, 15, 10, 0, 0, 0, berlin),
+					},
+				},
+				{
+					ID:              "2",
+					UserID:          1,
+					Start:           moved,
+					End:             moved.Add(15 * time.Minute),
+					TimeZone:        "Europe/Berlin",
+					Title:           "Stand-up (early)",
+					SeriesID:        "1",
+					OccurrenceStart: ptr(time.Date(2024, 1, 15, 10, 0, 0, 0, berlin)),
+				},
+				{
+					ID:       "3",
+					UserID:   1,
+					Start:    time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+					End:      time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+					AllDay:   true,
+					TimeZone: "UTC",
+					Title:    "Report",
+					RRule:    "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=12",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.events, stamp); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".ics")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("output differs from %s:\n%s", golden, buf.String())
+			}
+		})
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	var buf bytes.Buffer
+	event := calendar.Event{ID: "1", Start: stamp, End: stamp, Title: strings.Repeat("ё", 100)}
+	if err := Encode(&buf, []calendar.Event{event}, stamp); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Fatalf("line longer than %d octets: %q", maxLineLength, line)
+		}
+		if !strings.HasPrefix(line, " ") && strings.ContainsRune(line, '\uFFFD') {
+			t.Fatalf("line splits a UTF-8 sequence: %q", line)
+		}
+	}
+
+	// Склеивание строк продолжения восстанавливает исходное значение
+	unfolded := strings.ReplaceAll(buf.String(), "\r\n ", "")
+	if !strings.Contains(unfolded, "SUMMARY:"+event.Title+"\r\n") {
+		t.Fatalf("unfolded output does not contain summary:\n%s", unfolded)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
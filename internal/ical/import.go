@@ -0,0 +1,147 @@
+package ical
+
+import (
+	"errors"
+	"io"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+)
+
+// Report итог импорта календаря
+type Report struct {
+	Created int          `json:"created"`
+	Skipped int          `json:"skipped"`
+	Errors  []EntryError `json:"errors,omitempty"`
+}
+
+// EntryError ошибка импорта одного VEVENT
+type EntryError struct {
+	Line  int    `json:"line"`
+	UID   string `json:"uid,omitempty"`
+	Error string `json:"error"`
+}
+
+// Import создает события пользователя из VCALENDAR через service.CreateEvent.
+// События с UID, который у пользователя уже есть, пропускаются, поэтому
+// повторный импорт того же файла ничего не дублирует. Измененные вхождения
+// (RECURRENCE-ID) применяются к сериям, созданным в этом же импорте.
+// Ошибка возвращается, только если данные не удалось прочитать как календарь.
+func Import(service *calendar.Service, userID int, r io.Reader) (Report, error) {
+	var report Report
+
+	loc, err := service.Location(userID, "")
+	if err != nil {
+		return report, err
+	}
+
+	entries, err := Decode(r, loc)
+	if err != nil {
+		return report, err
+	}
+
+	fail := func(entry Entry, err error) {
+		report.Errors = append(report.Errors, EntryError{Line: entry.Line, UID: entry.Event.UID, Error: err.Error()})
+	}
+
+	// Вхождения, которые заменяются измененными, не должны быть отменены в серии
+	overridden := make(map[string][]Entry)
+	for _, entry := range entries {
+		if entry.Err == nil && entry.RecurrenceID != nil {
+			overridden[entry.Event.UID] = append(overridden[entry.Event.UID], entry)
+		}
+	}
+
+	created := make(map[string]calendar.EventID)
+	skipped := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.RecurrenceID != nil {
+			continue
+		}
+		if entry.Err != nil {
+			fail(entry, entry.Err)
+			continue
+		}
+
+		event := entry.Event
+		event.UserID = userID
+
+		if event.UID != "" {
+			if _, ok := created[event.UID]; ok || skipped[event.UID] {
+				report.Skipped++
+				continue
+			}
+			if _, err := service.GetEventByUID(userID, event.UID); err == nil {
+				skipped[event.UID] = true
+				report.Skipped++
+				continue
+			}
+		}
+
+		var exDates []time.Time
+		for _, exDate := range event.ExDates {
+			if !isOverridden(overridden[event.UID], exDate) {
+				exDates = append(exDates, exDate)
+			}
+		}
+		event.ExDates = exDates
+
+		result, err := service.CreateEvent(event)
+		if errors.Is(err, pkg.ErrDuplicateUID) {
+			skipped[event.UID] = true
+			report.Skipped++
+			continue
+		}
+		if err != nil {
+			fail(entry, err)
+			continue
+		}
+
+		if event.UID != "" {
+			created[event.UID] = result.ID
+		}
+		report.Created++
+	}
+
+	for _, entry := range entries {
+		if entry.RecurrenceID == nil {
+			continue
+		}
+		if entry.Err != nil {
+			fail(entry, entry.Err)
+			continue
+		}
+		if skipped[entry.Event.UID] {
+			report.Skipped++
+			continue
+		}
+
+		seriesID, ok := created[entry.Event.UID]
+		if !ok {
+			fail(entry, errors.New("RECURRENCE-ID without a master event"))
+			continue
+		}
+
+		override := entry.Event
+		override.ID, override.UserID = seriesID, userID
+		override.UID = ""
+		if _, err := service.UpdateOccurrences(override, *entry.RecurrenceID, calendar.ScopeThis); err != nil {
+			fail(entry, err)
+			continue
+		}
+		report.Created++
+	}
+
+	return report, nil
+}
+
+func isOverridden(overrides []Entry, exDate time.Time) bool {
+	for _, entry := range overrides {
+		if entry.RecurrenceID.Equal(exDate) {
+			return true
+		}
+	}
+
+	return false
+}
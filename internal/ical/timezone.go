@@ -0,0 +1,123 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// transition смена смещения часового пояса
+type transition struct {
+	at         time.Time
+	fromOffset int
+	toOffset   int
+	name       string
+	dst        bool
+}
+
+// writeTimeZone записывает VTIMEZONE с правилами, действующими с года year.
+// Если в этом году пояс дважды переходит на летнее время и обратно, правила
+// повторяются ежегодно; иначе действует смещение, последнее в этом году.
+func writeTimeZone(lw *lineWriter, loc *time.Location, year int) {
+	lw.line("BEGIN:VTIMEZONE")
+	lw.line("TZID:" + loc.String())
+
+	transitions := findTransitions(loc, year)
+	if len(transitions) != 2 {
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		if len(transitions) > 0 {
+			start = transitions[len(transitions)-1].at
+		}
+		name, offset := start.Zone()
+		writeObservance(lw, transition{at: start, fromOffset: offset, toOffset: offset, name: name}, false)
+	} else {
+		for _, tr := range transitions {
+			writeObservance(lw, tr, true)
+		}
+	}
+
+	lw.line("END:VTIMEZONE")
+}
+
+func writeObservance(lw *lineWriter, tr transition, yearly bool) {
+	kind := "STANDARD"
+	if tr.dst {
+		kind = "DAYLIGHT"
+	}
+
+	// DTSTART задается местным временем до перехода
+	local := tr.at.In(time.FixedZone("", tr.fromOffset))
+
+	lw.line("BEGIN:" + kind)
+	lw.line("DTSTART:" + local.Format(dateTimeLayout))
+	lw.line("TZOFFSETFROM:" + formatOffset(tr.fromOffset))
+	lw.line("TZOFFSETTO:" + formatOffset(tr.toOffset))
+	if tr.name != "" {
+		lw.line("TZNAME:" + escapeText(tr.name))
+	}
+	if yearly {
+		lw.line(fmt.Sprintf("RRULE:FREQ=YEARLY;BYMONTH=%d;BYDAY=%s", local.Month(), weekdayOrdinal(local)))
+	}
+	lw.line("END:" + kind)
+}
+
+// findTransitions ищет смены смещения пояса за год с точностью до секунды
+func findTransitions(loc *time.Location, year int) []transition {
+	var result []transition
+
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := from.AddDate(1, 0, 0)
+	for day := from; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, before := day.Zone()
+		_, after := next.Zone()
+		if before == after {
+			continue
+		}
+
+		// Двоичный поиск первой секунды с новым смещением
+		lo, hi := day.Unix(), next.Unix()
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if _, offset := time.Unix(mid, 0).In(loc).Zone(); offset == before {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+
+		at := time.Unix(hi, 0).In(loc)
+		name, offset := at.Zone()
+		result = append(result, transition{at: at, fromOffset: before, toOffset: offset, name: name, dst: at.IsDST()})
+	}
+
+	return result
+}
+
+// weekdayOrdinal возвращает BYDAY для дня: номер недели в месяце и день недели.
+// Последняя неделя месяца обозначается -1, чтобы правило подходило для любого года.
+func weekdayOrdinal(t time.Time) string {
+	names := [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	length := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	n := (t.Day()-1)/7 + 1
+	if t.Day()+7 > length {
+		n = -1
+	}
+
+	return fmt.Sprintf("%d%s", n, names[t.Weekday()])
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+
+	offset := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if s := seconds % 60; s != 0 {
+		offset += fmt.Sprintf("%02d", s)
+	}
+
+	return offset
+}
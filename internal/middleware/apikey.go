@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Области, которые проверяет EnforceScopes
+const (
+	ScopeRead  = "events:read"
+	ScopeWrite = "events:write"
+)
+
+// APIKeySource проверяет ключи API, например apikey.Store
+type APIKeySource interface {
+	// Verify возвращает клиента ключа с его пользователем и областями,
+	// если ключ существует и не отозван, иначе false
+	Verify(token string) (Principal, bool)
+}
+
+// APIKeyAuth проверка ключей API в схеме ApiKey: Authorization: ApiKey <ключ>
+type APIKeyAuth struct {
+	Source APIKeySource
+}
+
+func (a APIKeyAuth) Scheme() string {
+	return "ApiKey"
+}
+
+func (a APIKeyAuth) Challenge() string {
+	return `ApiKey realm="wb-calendar"`
+}
+
+func (a APIKeyAuth) Authenticate(credentials string) (Principal, error) {
+	principal, ok := a.Source.Verify(credentials)
+	if !ok {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("invalid or revoked api key")
+	}
+
+	return principal, nil
+}
+
+// readMethods методы, которые только читают события, в том числе запросы CalDAV
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+	"REPORT":           true,
+}
+
+// EnforceScopes проверяет области клиента: чтение требует events:read,
+// остальные методы — events:write. Клиенты без ограничений областями
+// и запросы без аутентификации пропускаются.
+func EnforceScopes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		scope := ScopeWrite
+		if readMethods[c.Request.Method] {
+			scope = ScopeRead
+		}
+		if !principal.HasScope(scope) {
+			response.Error(c, pkg.ErrInsufficientScope.WithMessage("credentials do not have the "+scope+" scope"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAdmin пропускает только администратора: клиента без привязки
+// к пользователю и без ограничения областями, например вход по HTTP Basic.
+// Ключи API и токены пользователей получают 403.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c)
+		if !ok {
+			response.Error(c, pkg.ErrUnauthorized)
+			c.Abort()
+			return
+		}
+		if principal.UserID != 0 || principal.Scopes != nil {
+			response.Error(c, pkg.ErrAdminRequired)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// staticKeys источник ключей API для тестов
+type staticKeys map[string]Principal
+
+func (k staticKeys) Verify(token string) (Principal, bool) {
+	principal, ok := k[token]
+	return principal, ok
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Auth([]Authenticator{
+		BasicAuth{Source: StaticCredentials{User: "admin", Password: "secret"}, Realm: "wb-calendar"},
+		APIKeyAuth{Source: staticKeys{
+			"reader": {Name: "reader", UserID: 1, Scopes: []string{ScopeRead}},
+			"writer": {Name: "writer", UserID: 1, Scopes: []string{ScopeRead, ScopeWrite}},
+		}},
+	}), EnforceScopes())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/events", ok)
+	router.POST("/events", ok)
+	router.Handle("PROPFIND", "/caldav", ok)
+	router.GET("/admin", RequireAdmin(), ok)
+
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret"))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "read with read scope", method: "GET", path: "/events", authorization: "ApiKey reader", expectedStatus: http.StatusOK},
+		{name: "caldav read", method: "PROPFIND", path: "/caldav", authorization: "ApiKey reader", expectedStatus: http.StatusOK},
+		{name: "write without write scope", method: "POST", path: "/events", authorization: "ApiKey reader", expectedStatus: http.StatusForbidden},
+		{name: "write with write scope", method: "POST", path: "/events", authorization: "apikey writer", expectedStatus: http.StatusOK},
+		{name: "unknown key", method: "GET", path: "/events", authorization: "ApiKey other", expectedStatus: http.StatusUnauthorized},
+		{name: "basic is not limited", method: "POST", path: "/events", authorization: basic, expectedStatus: http.StatusOK},
+		{name: "admin with basic", method: "GET", path: "/admin", authorization: basic, expectedStatus: http.StatusOK},
+		{name: "admin with api key", method: "GET", path: "/admin", authorization: "ApiKey writer", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey ключ аутентифицированного клиента в gin.Context
+const principalKey = "principal"
+
+// Principal аутентифицированный клиент
+type Principal struct {
+	// Name имя клиента: логин, субъект токена или название ключа
+	Name string
+	// UserID пользователь календаря, от имени которого действует клиент.
+	// 0 у клиентов без привязки к пользователю, например при HTTP Basic.
+	UserID int
+	// Scopes области действия клиента, например у ключа API.
+	// nil означает, что клиент не ограничен областями.
+	Scopes []string
+}
+
+// HasScope проверяет, что клиенту разрешена область scope
+func (p Principal) HasScope(scope string) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator проверяет учетные данные одной схемы заголовка Authorization,
+// например Basic. Новые схемы подключаются к Auth без изменения middleware.
+type Authenticator interface {
+	// Scheme возвращает имя схемы, например "Basic"
+	Scheme() string
+	// Challenge возвращает значение WWW-Authenticate для ответа 401
+	Challenge() string
+	// Authenticate проверяет учетные данные credentials — часть заголовка после схемы.
+	// Неверные учетные данные возвращают pkg.ErrUnauthorized.
+	Authenticate(credentials string) (Principal, error)
+}
+
+// Auth требует аутентификации по одной из схем authenticators. Запросы
+// к путям exempt, например проверкам здоровья, пропускаются без проверки.
+// Аутентифицированный клиент доступен через PrincipalFrom.
+func Auth(authenticators []Authenticator, exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		principal, err := authenticate(c.GetHeader("Authorization"), authenticators)
+		if err != nil {
+			for _, authenticator := range authenticators {
+				c.Writer.Header().Add("WWW-Authenticate", authenticator.Challenge())
+			}
+			response.Error(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// authenticate выбирает проверку по схеме заголовка Authorization
+func authenticate(header string, authenticators []Authenticator) (Principal, error) {
+	if header == "" {
+		return Principal{}, pkg.ErrUnauthorized
+	}
+
+	scheme, credentials, _ := strings.Cut(header, " ")
+	for _, authenticator := range authenticators {
+		// Схема нечувствительна к регистру (RFC 9110)
+		if strings.EqualFold(scheme, authenticator.Scheme()) {
+			return authenticator.Authenticate(strings.TrimSpace(credentials))
+		}
+	}
+
+	return Principal{}, pkg.ErrUnauthorized.WithMessage("unsupported authorization scheme")
+}
+
+// PrincipalFrom возвращает клиента, аутентифицированного Auth
+func PrincipalFrom(c *gin.Context) (Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return Principal{}, false
+	}
+
+	principal, ok := value.(Principal)
+	return principal, ok
+}
+
+// CredentialSource проверяет логин и пароль. Позволяет брать учетные данные
+// не только из конфигурации, но и, например, из базы пользователей.
+type CredentialSource interface {
+	// Verify возвращает клиента, если пароль подходит, иначе false
+	Verify(user, password string) (Principal, bool)
+}
+
+// StaticCredentials единственная пара логина и пароля, например из config.HTTPServer
+type StaticCredentials struct {
+	User     string
+	Password string
+}
+
+// Verify сравнивает логин и пароль за постоянное время. Сравниваются хеши,
+// поэтому время не зависит и от длины значений.
+func (s StaticCredentials) Verify(user, password string) (Principal, bool) {
+	userHash, passwordHash := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(password))
+	wantUser, wantPassword := sha256.Sum256([]byte(s.User)), sha256.Sum256([]byte(s.Password))
+
+	userMatch := subtle.ConstantTimeCompare(userHash[:], wantUser[:])
+	passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], wantPassword[:])
+	if userMatch&passwordMatch != 1 {
+		return Principal{}, false
+	}
+
+	return Principal{Name: user}, true
+}
+
+// BasicAuth проверка HTTP Basic (RFC 7617) по источнику учетных данных
+type BasicAuth struct {
+	Source CredentialSource
+	Realm  string
+}
+
+func (b BasicAuth) Scheme() string {
+	return "Basic"
+}
+
+func (b BasicAuth) Challenge() string {
+	return `Basic realm="` + b.Realm + `", charset="UTF-8"`
+}
+
+func (b BasicAuth) Authenticate(credentials string) (Principal, error) {
+	// Разбор Authorization делегируем net/http, чтобы не повторять декодирование base64
+	r := http.Request{Header: http.Header{"Authorization": {"Basic " + credentials}}}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("malformed basic credentials")
+	}
+
+	principal, ok := b.Source.Verify(user, password)
+	if !ok {
+		return Principal{}, pkg.ErrUnauthorized
+	}
+
+	return principal, nil
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBasicAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Auth([]Authenticator{BasicAuth{
+		Source: StaticCredentials{User: "admin", Password: "secret"},
+		Realm:  "wb-calendar",
+	}}, "/healthz"))
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/events", func(c *gin.Context) {
+		principal, _ := PrincipalFrom(c)
+		c.String(http.StatusOK, principal.Name)
+	})
+
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		authorization  string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "valid credentials", path: "/events", authorization: basic("admin", "secret"), expectedStatus: http.StatusOK, expectedBody: "admin"},
+		{name: "scheme is case insensitive", path: "/events", authorization: "basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")), expectedStatus: http.StatusOK, expectedBody: "admin"},
+		{name: "wrong password", path: "/events", authorization: basic("admin", "secrets"), expectedStatus: http.StatusUnauthorized},
+		{name: "wrong user", path: "/events", authorization: basic("root", "secret"), expectedStatus: http.StatusUnauthorized},
+		{name: "empty password", path: "/events", authorization: basic("admin", ""), expectedStatus: http.StatusUnauthorized},
+		{name: "malformed credentials", path: "/events", authorization: "Basic !!!", expectedStatus: http.StatusUnauthorized},
+		{name: "other scheme", path: "/events", authorization: "Bearer token", expectedStatus: http.StatusUnauthorized},
+		{name: "no credentials", path: "/events", expectedStatus: http.StatusUnauthorized},
+		{name: "health check", path: "/healthz", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if want := `Basic realm="wb-calendar", charset="UTF-8"`; w.Code == http.StatusUnauthorized && challenge != want {
+				t.Errorf("expected WWW-Authenticate %q, got %q", want, challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"wb-calendar/pkg"
+	"wb-calendar/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader заголовок с ключом идемпотентности запроса
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength ограничивает длину ключа, чтобы клиент не раздувал хранилище
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize ограничивает тело запроса с ключом: оно читается в память целиком
+const maxIdempotentBodySize = 1 << 20
+
+// IdempotencyStore хранит в памяти ответы на запросы с ключом идемпотентности
+// в течение ttl. Ключ действует в пределах клиента, метода и пути запроса.
+type IdempotencyStore struct {
+	ttl       time.Duration
+	entries   map[string]*idempotencyEntry
+	nextSweep time.Time
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// idempotencyEntry сохраненный ответ. Пока запрос выполняется, done равно false.
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Idempotency повторяет сохраненный ответ для запросов с тем же Idempotency-Key
+// и тем же телом. Ключ с другим телом или ключ запроса, который еще выполняется,
+// отклоняются с 409 Conflict. Запросы без заголовка обрабатываются как обычно.
+func Idempotency(store *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			response.Error(c, pkg.Invalid(IdempotencyKeyHeader, "idempotency key is too long"))
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			response.Error(c, pkg.ErrTooLarge)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			response.Error(c, pkg.ErrInvalidBody)
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Пользователь из токена не передается в теле, поэтому разные клиенты
+		// с одинаковыми телом и ключом не должны получить ответы друг друга
+		scope := c.Request.Method + " " + c.Request.URL.Path + " " + key
+		if principal, ok := PrincipalFrom(c); ok {
+			scope = principal.Name + " " + strconv.Itoa(principal.UserID) + " " + scope
+		}
+		entry, err := store.begin(scope, sha256.Sum256(body))
+		if err != nil {
+			response.Error(c, err)
+			c.Abort()
+			return
+		}
+		if entry != nil {
+			for name, values := range entry.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(entry.status, entry.header.Get("Content-Type"), entry.body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			// После паники запрос можно повторить с тем же ключом
+			if r := recover(); r != nil {
+				store.forget(scope)
+				panic(r)
+			}
+		}()
+
+		c.Next()
+
+		// Ответ с ошибкой сервера не сохраняется, чтобы запрос можно было повторить
+		if recorder.Status() >= http.StatusInternalServerError {
+			store.forget(scope)
+			return
+		}
+		store.finish(scope, recorder.Status(), recorder.Header().Clone(), recorder.body.Bytes())
+	}
+}
+
+// begin регистрирует выполнение запроса с ключом scope. Для повтора
+// возвращает сохраненный ответ, для нового запроса — nil.
+func (s *IdempotencyStore) begin(scope string, fingerprint [sha256.Size]byte) (*idempotencyEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if entry, exists := s.entries[scope]; exists && now.Before(entry.expires) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, pkg.ErrIdempotencyKeyReused
+		case !entry.done:
+			return nil, pkg.ErrIdempotencyInProgress
+		default:
+			return entry, nil
+		}
+	}
+
+	s.entries[scope] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return nil, nil
+}
+
+// finish сохраняет ответ на запрос с ключом scope
+func (s *IdempotencyStore) finish(scope string, status int, header http.Header, body []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, exists := s.entries[scope]; exists {
+		entry.done = true
+		entry.status = status
+		entry.header = header
+		entry.body = body
+		entry.expires = s.now().Add(s.ttl)
+	}
+}
+
+// forget удаляет ключ scope
+func (s *IdempotencyStore) forget(scope string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, scope)
+}
+
+// sweep удаляет истекшие ключи не чаще раза в ttl. Вызывается под блокировкой.
+func (s *IdempotencyStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+
+	for scope, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, scope)
+		}
+	}
+	s.nextSweep = now.Add(s.ttl)
+}
+
+// responseRecorder копирует тело ответа, чтобы его можно было сохранить
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
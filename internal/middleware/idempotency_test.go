@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	now := time.Date(2025, 8, 11, 12, 0, 0, 0, time.UTC)
+	store := NewIdempotencyStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	body := sha256.Sum256([]byte(`{"title": "Retro"}`))
+	other := sha256.Sum256([]byte(`{"title": "Demo"}`))
+
+	if entry, err := store.begin("POST /create_event k", body); entry != nil || err != nil {
+		t.Fatalf("expected new request, got %v, %v", entry, err)
+	}
+	if _, err := store.begin("POST /create_event k", body); !errors.Is(err, pkg.ErrIdempotencyInProgress) {
+		t.Fatalf("expected ErrIdempotencyInProgress, got %v", err)
+	}
+
+	store.finish("POST /create_event k", http.StatusCreated, http.Header{}, []byte("{}"))
+
+	entry, err := store.begin("POST /create_event k", body)
+	if err != nil || entry == nil || entry.status != http.StatusCreated {
+		t.Fatalf("expected stored response, got %+v, %v", entry, err)
+	}
+	if _, err := store.begin("POST /create_event k", other); !errors.Is(err, pkg.ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	// После ttl ключ можно использовать заново, а истекшие ключи удаляются
+	now = now.Add(time.Hour)
+	if entry, err := store.begin("POST /create_event k", other); entry != nil || err != nil {
+		t.Fatalf("expected expired key to be reusable, got %v, %v", entry, err)
+	}
+	store.forget("POST /create_event k")
+	if len(store.entries) != 0 {
+		t.Fatalf("expected no stored keys, got %d", len(store.entries))
+	}
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/create_event", Idempotency(NewIdempotencyStore(time.Hour)), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name           string
+		size           int
+		expectedStatus int
+	}{
+		{name: "at limit", size: maxIdempotentBodySize, expectedStatus: http.StatusCreated},
+		{name: "over limit", size: maxIdempotentBodySize + 1, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/create_event", strings.NewReader(strings.Repeat("x", tt.size)))
+			req.Header.Set(IdempotencyKeyHeader, tt.name)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wb-calendar/pkg"
+)
+
+// JWTKey ключ проверки подписи токена: общий секрет для HS256
+// или открытый ключ RSA для RS256. ID сравнивается с kid из заголовка токена.
+type JWTKey struct {
+	ID        string
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+}
+
+// JWTAuth проверка JWT в схеме Bearer (RFC 6750). Поддерживаются подписи
+// HS256 и RS256. Пользователь берется из утверждения UserClaim, по умолчанию sub,
+// и доступен обработчикам как Principal.UserID.
+type JWTAuth struct {
+	Keys []JWTKey
+	// Issuer и Audience, если заданы, должны совпадать с iss и aud токена
+	Issuer    string
+	Audience  string
+	UserClaim string
+	// Leeway допустимое расхождение часов при проверке exp и nbf
+	Leeway time.Duration
+}
+
+func (j JWTAuth) Scheme() string {
+	return "Bearer"
+}
+
+func (j JWTAuth) Challenge() string {
+	return `Bearer realm="wb-calendar"`
+}
+
+// jwtHeader заголовок токена
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (j JWTAuth) Authenticate(credentials string) (Principal, error) {
+	parts := strings.Split(credentials, ".")
+	if len(parts) != 3 {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("malformed token")
+	}
+
+	// Алгоритм из заголовка сверяется с типом ключа, поэтому открытый
+	// ключ RS256 нельзя использовать как секрет HS256
+	if !j.verify(header, parts[0]+"."+parts[1], signature) {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("invalid token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("malformed token")
+	}
+	if err := j.checkClaims(claims, time.Now()); err != nil {
+		return Principal{}, err
+	}
+
+	claim := j.UserClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	userID, ok := claimUserID(claims[claim])
+	if !ok {
+		return Principal{}, pkg.ErrUnauthorized.WithMessage("token has no valid " + claim + " claim")
+	}
+
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		name = strconv.Itoa(userID)
+	}
+
+	return Principal{Name: name, UserID: userID}, nil
+}
+
+// verify проверяет подпись ключами, подходящими по алгоритму и kid
+func (j JWTAuth) verify(header jwtHeader, signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+
+	for _, key := range j.Keys {
+		if header.Kid != "" && key.ID != "" && key.ID != header.Kid {
+			continue
+		}
+
+		switch {
+		case header.Alg == "HS256" && key.Secret != nil:
+			mac := hmac.New(sha256.New, key.Secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(signature, mac.Sum(nil)) {
+				return true
+			}
+		case header.Alg == "RS256" && key.PublicKey != nil:
+			if rsa.VerifyPKCS1v15(key.PublicKey, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkClaims проверяет срок действия, издателя и получателя токена
+func (j JWTAuth) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return pkg.ErrUnauthorized.WithMessage("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(j.Leeway)) {
+		return pkg.ErrUnauthorized.WithMessage("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return pkg.ErrUnauthorized.WithMessage("token is not valid yet")
+	}
+
+	if j.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.Issuer {
+			return pkg.ErrUnauthorized.WithMessage("token issuer is not accepted")
+		}
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return pkg.ErrUnauthorized.WithMessage("token audience is not accepted")
+	}
+
+	return nil
+}
+
+// hasAudience проверяет aud, который может быть строкой или массивом строк
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// claimUserID разбирает идентификатор пользователя: число или строку с числом
+func claimUserID(value any) (int, bool) {
+	var userID int
+	switch value := value.(type) {
+	case float64:
+		userID = int(value)
+		if float64(userID) != value {
+			return 0, false
+		}
+	case string:
+		var err error
+		if userID, err = strconv.Atoi(value); err != nil {
+			return 0, false
+		}
+	}
+
+	return userID, userID > 0
+}
+
+// decodeSegment декодирует часть токена в base64url без выравнивания
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// LoadRSAPublicKey читает открытый ключ RSA из PEM-файла: PKIX, PKCS #1
+// или сертификат X.509
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+
+	return rsaKey, nil
+}
+
+// jwk ключ из набора JWKS (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS читает ключи подписи из локального файла JWKS. Поддерживаются
+// ключи RSA и oct; ключи шифрования (use=enc) и других типов пропускаются.
+func LoadJWKS(path string) ([]JWTKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys []JWTKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d: %w", path, i, err)
+		}
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signing keys", path)
+	}
+
+	return keys, nil
+}
+
+// parse переводит JWK в JWTKey. Ключи неподдерживаемых типов возвращают nil.
+func (k jwk) parse() (*JWTKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("invalid modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+
+		return &JWTKey{ID: k.Kid, PublicKey: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid secret")
+		}
+
+		return &JWTKey{ID: k.Kid, Secret: secret}, nil
+	}
+
+	return nil, nil
+}
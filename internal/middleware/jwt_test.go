@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signJWT подписывает токен секретом HS256 или ключом RS256
+func signJWT(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := segment(header) + "." + segment(claims)
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := JWTAuth{
+		Keys: []JWTKey{
+			{Secret: secret},
+			{ID: "rsa-1", PublicKey: &rsaKey.PublicKey},
+		},
+		Issuer:    "wb-auth",
+		Audience:  "wb-calendar",
+		UserClaim: "sub",
+		Leeway:    time.Minute,
+	}
+
+	now := time.Now()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"sub": "42", "iss": "wb-auth", "aud": "wb-calendar", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs256 := map[string]any{"alg": "HS256", "typ": "JWT"}
+	rs256 := map[string]any{"alg": "RS256", "typ": "JWT", "kid": "rsa-1"}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedUserID int
+		expectedError  string
+	}{
+		{name: "HS256", token: signJWT(t, hs256, claims(nil), secret), expectedUserID: 42},
+		{name: "RS256", token: signJWT(t, rs256, claims(nil), rsaKey), expectedUserID: 42},
+		{name: "numeric sub", token: signJWT(t, hs256, claims(map[string]any{"sub": 7}), secret), expectedUserID: 7},
+		{name: "audience list", token: signJWT(t, hs256, claims(map[string]any{"aud": []string{"other", "wb-calendar"}}), secret), expectedUserID: 42},
+		{name: "expired within leeway", token: signJWT(t, hs256, claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()}), secret), expectedUserID: 42},
+		{name: "expired", token: signJWT(t, hs256, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()}), secret), expectedError: "token has expired"},
+		{name: "no exp", token: signJWT(t, hs256, claims(map[string]any{"exp": nil}), secret), expectedError: "token has no exp claim"},
+		{name: "not valid yet", token: signJWT(t, hs256, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), secret), expectedError: "token is not valid yet"},
+		{name: "wrong issuer", token: signJWT(t, hs256, claims(map[string]any{"iss": "evil"}), secret), expectedError: "token issuer is not accepted"},
+		{name: "wrong audience", token: signJWT(t, hs256, claims(map[string]any{"aud": "other"}), secret), expectedError: "token audience is not accepted"},
+		{name: "no user", token: signJWT(t, hs256, claims(map[string]any{"sub": "alice"}), secret), expectedError: "token has no valid sub claim"},
+		{name: "wrong secret", token: signJWT(t, hs256, claims(nil), []byte("other")), expectedError: "invalid token signature"},
+		{name: "wrong RSA key", token: signJWT(t, rs256, claims(nil), otherKey), expectedError: "invalid token signature"},
+		{name: "unknown kid", token: signJWT(t, map[string]any{"alg": "RS256", "kid": "rsa-2"}, claims(nil), rsaKey), expectedError: "invalid token signature"},
+		{name: "alg none", token: signJWT(t, map[string]any{"alg": "none"}, claims(nil), nil), expectedError: "invalid token signature"},
+		{name: "malformed", token: "not-a-token", expectedError: "malformed token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := auth.Authenticate(tt.token)
+			if tt.expectedError != "" {
+				if err == nil || err.Error() != tt.expectedError {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if principal.UserID != tt.expectedUserID {
+				t.Errorf("expected user %d, got %d", tt.expectedUserID, principal.UserID)
+			}
+		})
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]any{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "oct", "kid": "hmac-1", "k": b64([]byte("secret"))},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "", "e": ""},
+		{"kty": "EC", "kid": "ec-1"},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	auth := JWTAuth{Keys: keys}
+	claims := map[string]any{"sub": "1", "exp": time.Now().Add(time.Hour).Unix()}
+	for _, token := range []string{
+		signJWT(t, map[string]any{"alg": "RS256", "kid": "rsa-1"}, claims, rsaKey),
+		signJWT(t, map[string]any{"alg": "HS256", "kid": "hmac-1"}, claims, []byte("secret")),
+	} {
+		if _, err := auth.Authenticate(token); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE events (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    date    TEXT    NOT NULL,
+    title   TEXT    NOT NULL
+);
+
+CREATE INDEX idx_events_user_date ON events (user_id, date);
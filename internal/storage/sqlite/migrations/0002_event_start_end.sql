@@ -0,0 +1,25 @@
+-- Событие получает начало, конец и признак "весь день".
+-- Существующие события с одной датой становятся событиями на весь день.
+CREATE TABLE events_new (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id  INTEGER NOT NULL,
+    start_at TEXT    NOT NULL,
+    end_at   TEXT    NOT NULL,
+    all_day  INTEGER NOT NULL DEFAULT 0,
+    title    TEXT    NOT NULL
+);
+
+INSERT INTO events_new (id, user_id, start_at, end_at, all_day, title)
+SELECT id, user_id, date, strftime('%Y-%m-%dT%H:%M:%S.000000000Z', date, '+1 day'), 1, title
+FROM events;
+
+-- Сохраняем счетчик AUTOINCREMENT, чтобы ID удаленных событий не переиспользовались
+UPDATE sqlite_sequence
+SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'events')
+WHERE name = 'events_new'
+  AND EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'events');
+
+DROP TABLE events;
+ALTER TABLE events_new RENAME TO events;
+
+CREATE INDEX idx_events_user_start ON events (user_id, start_at);
@@ -0,0 +1,7 @@
+-- Часовой пояс события и часовой пояс пользователя по умолчанию
+ALTER TABLE events ADD COLUMN time_zone TEXT NOT NULL DEFAULT 'UTC';
+
+CREATE TABLE users (
+    user_id   INTEGER PRIMARY KEY,
+    time_zone TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Правило повторения RFC 5545; пустая строка у обычных событий
+ALTER TABLE events ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_events_user_rrule ON events (user_id, rrule) WHERE rrule <> '';
@@ -0,0 +1,7 @@
+-- Отмененные вхождения серии (EXDATE) через запятую и ссылка измененного
+-- вхождения на серию (RECURRENCE-ID)
+ALTER TABLE events ADD COLUMN exdates TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN series_id INTEGER;
+ALTER TABLE events ADD COLUMN recurrence_id TEXT;
+
+CREATE INDEX idx_events_series ON events (series_id) WHERE series_id IS NOT NULL;
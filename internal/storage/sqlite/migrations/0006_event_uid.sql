@@ -0,0 +1,4 @@
+-- UID события из iCalendar, уникальный в пределах пользователя
+ALTER TABLE events ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX idx_events_user_uid ON events (user_id, uid) WHERE uid <> '';
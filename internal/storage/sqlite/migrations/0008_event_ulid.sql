@@ -0,0 +1,30 @@
+-- ID событий становятся строками: новые события получают ULID, существующие
+-- сохраняют прежние целые ID в десятичной записи, ссылки серий тоже
+CREATE TABLE events_new (
+    id            TEXT    PRIMARY KEY,
+    user_id       INTEGER NOT NULL,
+    start_at      TEXT    NOT NULL,
+    end_at        TEXT    NOT NULL,
+    all_day       INTEGER NOT NULL DEFAULT 0,
+    title         TEXT    NOT NULL,
+    time_zone     TEXT    NOT NULL DEFAULT 'UTC',
+    rrule         TEXT    NOT NULL DEFAULT '',
+    exdates       TEXT    NOT NULL DEFAULT '',
+    series_id     TEXT,
+    recurrence_id TEXT,
+    uid           TEXT    NOT NULL DEFAULT '',
+    version       INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO events_new (id, user_id, start_at, end_at, all_day, title, time_zone, rrule, exdates, series_id, recurrence_id, uid, version)
+SELECT CAST(id AS TEXT), user_id, start_at, end_at, all_day, title, time_zone, rrule, exdates,
+       CAST(series_id AS TEXT), recurrence_id, uid, version
+FROM events;
+
+DROP TABLE events;
+ALTER TABLE events_new RENAME TO events;
+
+CREATE INDEX idx_events_user_start ON events (user_id, start_at);
+CREATE INDEX idx_events_user_rrule ON events (user_id, rrule) WHERE rrule <> '';
+CREATE INDEX idx_events_series ON events (series_id) WHERE series_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_events_user_uid ON events (user_id, uid) WHERE uid <> '';
@@ -0,0 +1,3 @@
+-- Индекс по концу обычных событий: выборка за интервал ограничивается снизу
+-- end_at и не перебирает все прошедшие события пользователя
+CREATE INDEX idx_events_user_end ON events (user_id, end_at) WHERE rrule = '';
@@ -0,0 +1,572 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dateLayout формат хранения дат: фиксированная ширина и UTC,
+// чтобы строки сравнивались так же, как время
+const dateLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Repository хранилище событий во встроенной базе SQLite
+type Repository struct {
+	db *sql.DB
+}
+
+// Проверяем, что Repository реализует calendar.Repository
+var _ calendar.Repository = (*Repository)(nil)
+
+// Open открывает файл базы, создавая его при необходимости, и применяет миграции
+func Open(path string) (*Repository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("sqlite: create directory: %w", err)
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: open: %w", err)
+	}
+
+	// SQLite допускает одного писателя, общее соединение избавляет от SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Close закрывает базу
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// CreateEvent создает новое событие
+func (r *Repository) CreateEvent(event calendar.Event) (calendar.Event, error) {
+	return insertEvent(r.db, event)
+}
+
+// UpdateEvent обновляет время и название существующего события пользователя event.UserID.
+// Если event.Version не 0, событие должно иметь эту версию.
+func (r *Repository) UpdateEvent(event calendar.Event) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: update event: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := updateEvent(tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: update event: %w", err)
+	}
+
+	return nil
+}
+
+// PatchEvent в одной транзакции читает событие пользователя userID,
+// применяет патч и сохраняет результат
+func (r *Repository) PatchEvent(userID int, id calendar.EventID, patch calendar.EventPatch) (calendar.Event, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: patch event: %w", err)
+	}
+	defer tx.Rollback()
+
+	event, err := ownedEvent(tx, id, userID, 0)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+
+	patched, err := patch.Apply(event)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+	patched.Version++
+
+	if err := saveEvent(tx, event, patched); err != nil {
+		return calendar.Event{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: patch event: %w", err)
+	}
+
+	return patched, nil
+}
+
+// DeleteEvent удаляет событие пользователя userID, а для серии — и ее измененные
+// вхождения. Если version не 0, событие должно иметь эту версию.
+func (r *Repository) DeleteEvent(userID int, id calendar.EventID, version int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: delete event: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := deleteEvent(tx, id, userID, version); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: delete event: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyBatch выполняет операции по порядку в одной транзакции. В режиме
+// best effort каждая операция выполняется в своей точке сохранения,
+// и ошибка откатывает только ее.
+func (r *Repository) ApplyBatch(ops []calendar.BatchOp, atomic bool) ([]calendar.BatchResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: apply batch: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]calendar.BatchResult, len(ops))
+	for i, op := range ops {
+		if !atomic {
+			if _, err := tx.Exec(`SAVEPOINT batch_op`); err != nil {
+				return nil, fmt.Errorf("sqlite: apply batch: %w", err)
+			}
+		}
+
+		event, err := applyBatchOp(tx, op)
+		if err != nil && atomic {
+			return nil, &calendar.BatchError{Index: i, Err: err}
+		}
+		results[i] = calendar.BatchResult{Event: event, Err: err}
+
+		if !atomic {
+			if err != nil {
+				if _, err := tx.Exec(`ROLLBACK TO batch_op`); err != nil {
+					return nil, fmt.Errorf("sqlite: apply batch: %w", err)
+				}
+			}
+			if _, err := tx.Exec(`RELEASE batch_op`); err != nil {
+				return nil, fmt.Errorf("sqlite: apply batch: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite: apply batch: %w", err)
+	}
+
+	return results, nil
+}
+
+// UpdateSeries в одной транзакции заменяет правило повторения и отмененные
+// вхождения серии пользователя series.UserID и создает detached, если он задан
+func (r *Repository) UpdateSeries(series calendar.Event, detached *calendar.Event) (calendar.Event, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: update series: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := ownedEvent(tx, series.ID, series.UserID, series.Version); err != nil {
+		return calendar.Event{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE events SET rrule = ?, exdates = ?, version = version + 1 WHERE id = ?`,
+		series.RRule, formatDates(series.ExDates), series.ID); err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: update series: %w", err)
+	}
+
+	var created calendar.Event
+	if detached != nil {
+		if created, err = insertEvent(tx, *detached); err != nil {
+			return calendar.Event{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: update series: %w", err)
+	}
+
+	return created, nil
+}
+
+// ReplaceSeries в одной транзакции обновляет событие пользователя series.UserID
+// вместе с отмененными вхождениями и заменяет его измененные вхождения на overrides
+func (r *Repository) ReplaceSeries(series calendar.Event, overrides []calendar.Event) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: replace series: %w", err)
+	}
+	defer tx.Rollback()
+
+	event, err := ownedEvent(tx, series.ID, series.UserID, series.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := calendar.ApplyUpdate(event, series)
+	if err != nil {
+		return err
+	}
+	updated.ExDates = series.ExDates
+	updated.Version++
+
+	if err := saveEvent(tx, event, updated); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE series_id = ?`, series.ID); err != nil {
+		return fmt.Errorf("sqlite: delete overrides: %w", err)
+	}
+	for _, override := range overrides {
+		if _, err := insertEvent(tx, override); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: replace series: %w", err)
+	}
+
+	return nil
+}
+
+// GetEvent возвращает событие по ID
+func (r *Repository) GetEvent(id calendar.EventID) (calendar.Event, error) {
+	row := r.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return calendar.Event{}, pkg.ErrEventNotFound
+	}
+
+	return event, err
+}
+
+// GetEventByUID возвращает событие пользователя по UID
+func (r *Repository) GetEventByUID(userID int, uid string) (calendar.Event, error) {
+	if uid == "" {
+		return calendar.Event{}, pkg.ErrEventNotFound
+	}
+
+	row := r.db.QueryRow(`SELECT `+eventColumns+` FROM events WHERE user_id = ? AND uid = ?`, userID, uid)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return calendar.Event{}, pkg.ErrEventNotFound
+	}
+
+	return event, err
+}
+
+// GetOverrides возвращает измененные вхождения серии seriesID пользователя userID
+func (r *Repository) GetOverrides(userID int, seriesID calendar.EventID) ([]calendar.Event, error) {
+	rows, err := r.db.Query(`SELECT `+eventColumns+` FROM events WHERE series_id = ? AND user_id = ?
+		ORDER BY start_at, length(id), id`, seriesID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: list overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var result []calendar.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: list overrides: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetEventsInRange возвращает события пользователя, пересекающиеся с полуинтервалом [from, to),
+// и повторяющиеся серии, начавшиеся до to
+func (r *Repository) GetEventsInRange(userID int, from, to time.Time) ([]calendar.Event, error) {
+	rows, err := r.db.Query(rangeQuery,
+		userID, formatDate(from), formatDate(to), formatDate(from), userID, formatDate(to))
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: list events: %w", err)
+	}
+	defer rows.Close()
+
+	var result []calendar.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: list events: %w", err)
+	}
+
+	return result, nil
+}
+
+const eventColumns = `id, user_id, start_at, end_at, all_day, time_zone, title, rrule, exdates, series_id, recurrence_id, uid, version`
+
+// rangeQuery выбирает события пользователя, пересекающие [from, to).
+// Обычные события ищутся по индексу (user_id, end_at): пересекающее интервал
+// событие заканчивается не раньше from, а событие нулевой длительности
+// пересекается с интервалом, если начинается в нем. Серии проверяются все:
+// их вхождения могут попасть в любой интервал после начала.
+// Параметры: user_id, from, to, from, user_id, to.
+const rangeQuery = `SELECT ` + eventColumns + ` FROM (
+		SELECT ` + eventColumns + ` FROM events
+		WHERE user_id = ? AND rrule = '' AND end_at >= ? AND start_at < ?
+		  AND (end_at > ? OR end_at = start_at)
+		UNION ALL
+		SELECT ` + eventColumns + ` FROM events
+		WHERE user_id = ? AND rrule <> '' AND start_at < ?
+	)
+	ORDER BY start_at, length(id), id`
+
+// GetTimeZone возвращает часовой пояс пользователя по умолчанию
+func (r *Repository) GetTimeZone(userID int) (string, error) {
+	var timeZone string
+
+	err := r.db.QueryRow(`SELECT time_zone FROM users WHERE user_id = ?`, userID).Scan(&timeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("sqlite: get time zone: %w", err)
+	}
+
+	return timeZone, nil
+}
+
+// SetTimeZone задает часовой пояс пользователя по умолчанию
+func (r *Repository) SetTimeZone(userID int, timeZone string) error {
+	_, err := r.db.Exec(`INSERT INTO users (user_id, time_zone) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET time_zone = excluded.time_zone`, userID, timeZone)
+	if err != nil {
+		return fmt.Errorf("sqlite: set time zone: %w", err)
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// execer общий интерфейс *sql.DB и *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// applyBatchOp выполняет операцию пакета в транзакции
+func applyBatchOp(tx *sql.Tx, op calendar.BatchOp) (calendar.Event, error) {
+	switch op.Type {
+	case calendar.BatchCreate:
+		return insertEvent(tx, op.Event)
+	case calendar.BatchUpdate:
+		return updateEvent(tx, op.Event)
+	case calendar.BatchDelete:
+		return deleteEvent(tx, op.Event.ID, op.Event.UserID, op.Event.Version)
+	default:
+		return calendar.Event{}, pkg.Invalid("op", "op must be one of create, update, delete")
+	}
+}
+
+// ownedEvent читает в транзакции событие id пользователя userID версии version.
+// Событие другого пользователя считается отсутствующим. Нулевая версия не проверяется.
+func ownedEvent(tx *sql.Tx, id calendar.EventID, userID, version int) (calendar.Event, error) {
+	event, err := scanEvent(tx.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && event.UserID != userID) {
+		return calendar.Event{}, pkg.ErrEventNotFound
+	}
+	if err != nil {
+		return calendar.Event{}, err
+	}
+	if err := event.CheckVersion(version); err != nil {
+		return calendar.Event{}, err
+	}
+
+	return event, nil
+}
+
+// updateEvent обновляет в транзакции время и название события и возвращает результат
+func updateEvent(tx *sql.Tx, update calendar.Event) (calendar.Event, error) {
+	event, err := ownedEvent(tx, update.ID, update.UserID, update.Version)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+
+	updated, err := calendar.ApplyUpdate(event, update)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+	updated.Version++
+
+	if err := saveEvent(tx, event, updated); err != nil {
+		return calendar.Event{}, err
+	}
+
+	return updated, nil
+}
+
+// saveEvent сохраняет в транзакции измененное событие old. Если изменилось
+// расписание серии, удаляет ее измененные вхождения.
+func saveEvent(tx *sql.Tx, old, updated calendar.Event) error {
+	if _, err := tx.Exec(`UPDATE events SET start_at = ?, end_at = ?, all_day = ?, time_zone = ?, title = ?, rrule = ?, exdates = ?, version = ? WHERE id = ?`,
+		formatDate(updated.Start), formatDate(updated.End), updated.AllDay, updated.TimeZone, updated.Title, updated.RRule,
+		formatDates(updated.ExDates), updated.Version, updated.ID); err != nil {
+		return fmt.Errorf("sqlite: update event: %w", err)
+	}
+
+	if calendar.ScheduleChanged(old, updated) {
+		if _, err := tx.Exec(`DELETE FROM events WHERE series_id = ?`, updated.ID); err != nil {
+			return fmt.Errorf("sqlite: delete overrides: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteEvent удаляет в транзакции событие и измененные вхождения серии
+// и возвращает удаленное событие
+func deleteEvent(tx *sql.Tx, id calendar.EventID, userID, version int) (calendar.Event, error) {
+	event, err := ownedEvent(tx, id, userID, version)
+	if err != nil {
+		return calendar.Event{}, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, id); err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: delete event: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE series_id = ?`, id); err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: delete overrides: %w", err)
+	}
+
+	return event, nil
+}
+
+func insertEvent(db execer, event calendar.Event) (calendar.Event, error) {
+	var seriesID, recurrenceID any
+	if event.SeriesID != "" && event.OccurrenceStart != nil {
+		seriesID, recurrenceID = event.SeriesID, formatDate(*event.OccurrenceStart)
+	}
+
+	event.ID = calendar.NewEventID()
+	_, err := db.Exec(`INSERT INTO events (id, user_id, start_at, end_at, all_day, time_zone, title, rrule, exdates, series_id, recurrence_id, uid)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, event.UserID, formatDate(event.Start), formatDate(event.End), event.AllDay, event.TimeZone, event.Title,
+		event.RRule, formatDates(event.ExDates), seriesID, recurrenceID, event.UID)
+	if isUniqueViolation(err) {
+		return calendar.Event{}, pkg.ErrDuplicateUID
+	}
+	if err != nil {
+		return calendar.Event{}, fmt.Errorf("sqlite: insert event: %w", err)
+	}
+
+	event.Version = 1
+	return event, nil
+}
+
+func scanEvent(row scanner) (calendar.Event, error) {
+	var (
+		event               calendar.Event
+		start, end, exDates string
+		seriesID            sql.NullString
+		recurrenceID        sql.NullString
+	)
+
+	if err := row.Scan(&event.ID, &event.UserID, &start, &end, &event.AllDay, &event.TimeZone, &event.Title,
+		&event.RRule, &exDates, &seriesID, &recurrenceID, &event.UID, &event.Version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return calendar.Event{}, err
+		}
+		return calendar.Event{}, fmt.Errorf("sqlite: scan event: %w", err)
+	}
+
+	var err error
+	if event.Start, err = parseDate(start); err != nil {
+		return calendar.Event{}, err
+	}
+	if event.End, err = parseDate(end); err != nil {
+		return calendar.Event{}, err
+	}
+	if event.ExDates, err = parseDates(exDates); err != nil {
+		return calendar.Event{}, err
+	}
+	if seriesID.Valid && recurrenceID.Valid {
+		occurrence, err := parseDate(recurrenceID.String)
+		if err != nil {
+			return calendar.Event{}, err
+		}
+		event.SeriesID = calendar.EventID(seriesID.String)
+		event.OccurrenceStart = &occurrence
+	}
+
+	return event, nil
+}
+
+// isUniqueViolation проверяет, что запрос нарушил ограничение уникальности
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format(dateLayout)
+}
+
+func parseDate(value string) (time.Time, error) {
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sqlite: parse date %q: %w", value, err)
+	}
+
+	return t, nil
+}
+
+// formatDates записывает даты через запятую
+func formatDates(dates []time.Time) string {
+	values := make([]string, len(dates))
+	for i, t := range dates {
+		values[i] = formatDate(t)
+	}
+
+	return strings.Join(values, ",")
+}
+
+func parseDates(value string) ([]time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var dates []time.Time
+	for _, item := range strings.Split(value, ",") {
+		t, err := parseDate(item)
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, t)
+	}
+
+	return dates, nil
+}
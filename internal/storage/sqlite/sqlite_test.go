@@ -0,0 +1,581 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/pkg"
+)
+
+// newEvent создает событие без длительности
+func newEvent(userID int, start time.Time, title string) calendar.Event {
+	return calendar.Event{UserID: userID, Start: start, End: start, Title: title}
+}
+
+func openTestRepository(t *testing.T) *Repository {
+	t.Helper()
+
+	repo, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	return repo
+}
+
+func TestCreateAndGetEvent(t *testing.T) {
+	repo := openTestRepository(t)
+	date := time.Date(2023, 12, 25, 10, 30, 0, 0, time.UTC)
+
+	event, err := repo.CreateEvent(newEvent(1, date, "Christmas"))
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if event.ID == "" {
+		t.Fatal("expected event ID to be assigned")
+	}
+
+	got, err := repo.GetEvent(event.ID)
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if got.UserID != 1 || got.Title != "Christmas" || !got.Start.Equal(date) {
+		t.Fatalf("unexpected event: %+v", got)
+	}
+}
+
+func TestUpdateAndDeleteEvent(t *testing.T) {
+	repo := openTestRepository(t)
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	event, _ := repo.CreateEvent(newEvent(1, date, "Christmas"))
+
+	newDate := date.AddDate(0, 0, 1)
+	if err := repo.UpdateEvent(calendar.Event{ID: event.ID, UserID: 1, Start: newDate, End: newDate, Title: "Boxing Day"}); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+
+	got, _ := repo.GetEvent(event.ID)
+	if got.Title != "Boxing Day" || !got.Start.Equal(newDate) {
+		t.Fatalf("unexpected event after update: %+v", got)
+	}
+
+	if err := repo.DeleteEvent(1, event.ID, 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if _, err := repo.GetEvent(event.ID); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+}
+
+func TestPatchEvent(t *testing.T) {
+	repo := openTestRepository(t)
+	start := time.Date(2023, 12, 25, 10, 0, 0, 0, time.UTC)
+
+	event, _ := repo.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start.Add(time.Hour), Title: "Christmas", RRule: "FREQ=YEARLY"})
+
+	title := "Christmas dinner"
+	patched, err := repo.PatchEvent(1, event.ID, calendar.EventPatch{Title: &title})
+	if err != nil {
+		t.Fatalf("PatchEvent failed: %v", err)
+	}
+
+	got, _ := repo.GetEvent(event.ID)
+	if got.Title != title || !got.Start.Equal(start) || !got.End.Equal(start.Add(time.Hour)) || got.RRule != "FREQ=YEARLY" {
+		t.Fatalf("unexpected event after patch: %+v", got)
+	}
+	if patched.Title != got.Title {
+		t.Fatalf("PatchEvent returned %+v, stored %+v", patched, got)
+	}
+
+	if _, err := repo.PatchEvent(1, "999", calendar.EventPatch{Title: &title}); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+}
+
+func TestEventVersions(t *testing.T) {
+	repo := openTestRepository(t)
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	event, _ := repo.CreateEvent(newEvent(1, date, "Christmas"))
+	if event.Version != 1 {
+		t.Fatalf("expected version 1, got %d", event.Version)
+	}
+
+	if err := repo.UpdateEvent(calendar.Event{ID: event.ID, UserID: 1, Start: date, End: date, Title: "Boxing Day", Version: 1}); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+	if err := repo.UpdateEvent(calendar.Event{ID: event.ID, UserID: 1, Start: date, End: date, Title: "Stale", Version: 1}); !errors.Is(err, pkg.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if err := repo.DeleteEvent(1, event.ID, 1); !errors.Is(err, pkg.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+
+	got, _ := repo.GetEvent(event.ID)
+	if got.Version != 2 || got.Title != "Boxing Day" {
+		t.Fatalf("unexpected event: %+v", got)
+	}
+
+	if _, err := repo.UpdateSeries(calendar.Event{ID: event.ID, UserID: 1, RRule: "FREQ=DAILY", Version: 2}, nil); err != nil {
+		t.Fatalf("UpdateSeries failed: %v", err)
+	}
+	if err := repo.DeleteEvent(1, event.ID, 3); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	repo := openTestRepository(t)
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	existing, _ := repo.CreateEvent(newEvent(1, date, "Christmas"))
+
+	_, err := repo.ApplyBatch([]calendar.BatchOp{
+		{Type: calendar.BatchCreate, Event: newEvent(1, date, "Lost")},
+		{Type: calendar.BatchDelete, Event: calendar.Event{ID: existing.ID, UserID: 2}},
+	}, true)
+	var batchErr *calendar.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 || !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound at operation 1, got %v", err)
+	}
+	if events, _ := repo.GetEventsInRange(1, date, date.AddDate(0, 0, 1)); len(events) != 1 {
+		t.Fatalf("expected atomic batch to be rolled back, got %+v", events)
+	}
+
+	results, err := repo.ApplyBatch([]calendar.BatchOp{
+		{Type: calendar.BatchUpdate, Event: calendar.Event{ID: existing.ID, UserID: 1, Start: date, End: date, Title: "Boxing Day", Version: 1}},
+		{Type: calendar.BatchCreate, Event: newEvent(1, date, "Dinner")},
+		{Type: calendar.BatchDelete, Event: calendar.Event{ID: existing.ID, UserID: 1, Version: 1}},
+	}, false)
+	if err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	if results[0].Err != nil || results[0].Event.Version != 2 || results[1].Err != nil || !errors.Is(results[2].Err, pkg.ErrVersionMismatch) {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	got, _ := repo.GetEvent(existing.ID)
+	if got.Title != "Boxing Day" {
+		t.Fatalf("unexpected event after batch: %+v", got)
+	}
+	if events, _ := repo.GetEventsInRange(1, date, date.AddDate(0, 0, 1)); len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	repo := openTestRepository(t)
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	if err := repo.UpdateEvent(calendar.Event{ID: "999", UserID: 1, Start: date, End: date, Title: "Christmas"}); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound on update, got %v", err)
+	}
+	if err := repo.DeleteEvent(1, "999", 0); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound on delete, got %v", err)
+	}
+}
+
+func TestGetEventsInRange(t *testing.T) {
+	repo := openTestRepository(t)
+
+	repo.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 23, 59, 0, 0, time.UTC), "Christmas"))
+	repo.CreateEvent(newEvent(1, time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC), "Boxing Day"))
+	repo.CreateEvent(newEvent(2, time.Date(2023, 12, 25, 12, 0, 0, 0, time.UTC), "Other user"))
+
+	from := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+	events, err := repo.GetEventsInRange(1, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetEventsInRange failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Title != "Christmas" {
+		t.Fatalf("expected only 'Christmas', got %+v", events)
+	}
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	repo, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	created, _ := repo.CreateEvent(newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas"))
+	repo.Close()
+
+	repo, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer repo.Close()
+
+	var applied int
+	repo.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied)
+	migrations, _ := loadMigrations()
+	if applied != len(migrations) {
+		t.Fatalf("expected %d applied migrations, got %d", len(migrations), applied)
+	}
+
+	if _, err := repo.GetEvent(created.ID); err != nil {
+		t.Fatalf("expected event to survive reopen: %v", err)
+	}
+}
+
+func TestGetEventsInRangeOverlap(t *testing.T) {
+	repo := openTestRepository(t)
+
+	repo.CreateEvent(calendar.Event{
+		UserID: 1,
+		Start:  time.Date(2023, 12, 24, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2023, 12, 28, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+		Title:  "Conference",
+	})
+	repo.CreateEvent(calendar.Event{
+		UserID: 1,
+		Start:  time.Date(2023, 12, 25, 23, 0, 0, 0, time.UTC),
+		End:    time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC),
+		Title:  "Late call",
+	})
+
+	from := time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)
+	events, err := repo.GetEventsInRange(1, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetEventsInRange failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Title != "Conference" || !events[0].AllDay {
+		t.Fatalf("expected only the all-day conference, got %+v", events)
+	}
+}
+
+func TestGetEventsInRangeUsesIndex(t *testing.T) {
+	repo := openTestRepository(t)
+
+	from, to := formatDate(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), formatDate(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	rows, err := repo.db.Query(`EXPLAIN QUERY PLAN `+rangeQuery, 1, from, to, from, 1, to)
+	if err != nil {
+		t.Fatalf("EXPLAIN failed: %v", err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		rows.Scan(&id, &parent, &unused, &detail)
+		plan = append(plan, detail)
+	}
+	if !strings.Contains(strings.Join(plan, "; "), "idx_events_user_end") {
+		t.Fatalf("expected range query to use idx_events_user_end, got %v", plan)
+	}
+}
+
+func TestMigrateLegacyDateColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+
+	// Схема первой версии с единственной датой события
+	migrations, _ := loadMigrations()
+	db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`)
+	if err := applyMigration(db, migrations[0]); err != nil {
+		t.Fatalf("applyMigration failed: %v", err)
+	}
+	db.Exec(`INSERT INTO events (user_id, date, title) VALUES (1, '2023-12-25T00:00:00.000000000Z', 'Christmas')`)
+	db.Exec(`INSERT INTO events (user_id, date, title) VALUES (1, '2023-12-26T00:00:00.000000000Z', 'Deleted')`)
+	db.Exec(`DELETE FROM events WHERE id = 2`)
+	db.Close()
+
+	repo, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer repo.Close()
+
+	event, err := repo.GetEvent("1")
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if !event.AllDay || !event.End.Equal(time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected migrated all-day event, got %+v", event)
+	}
+
+	// ID удаленного до миграции события не переиспользуется
+	created, _ := repo.CreateEvent(newEvent(1, time.Date(2023, 12, 27, 0, 0, 0, 0, time.UTC), "New"))
+	if created.ID == "2" {
+		t.Fatal("expected deleted ID not to be reused after migration")
+	}
+}
+
+func TestMigrateIntegerIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+
+	// Схема с целыми ID: серия и ее измененное вхождение
+	migrations, _ := loadMigrations()
+	db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`)
+	for _, m := range migrations[:7] {
+		if err := applyMigration(db, m); err != nil {
+			t.Fatalf("applyMigration failed: %v", err)
+		}
+	}
+	db.Exec(`INSERT INTO events (id, user_id, start_at, end_at, title, rrule) VALUES
+		(9, 1, '2024-01-01T10:00:00.000000000Z', '2024-01-01T11:00:00.000000000Z', 'Stand-up', 'FREQ=DAILY')`)
+	db.Exec(`INSERT INTO events (id, user_id, start_at, end_at, title, series_id, recurrence_id) VALUES
+		(10, 1, '2024-01-02T12:00:00.000000000Z', '2024-01-02T13:00:00.000000000Z', 'Moved', 9, '2024-01-02T10:00:00.000000000Z')`)
+	db.Close()
+
+	repo, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer repo.Close()
+
+	override, err := repo.GetEvent("10")
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if override.SeriesID != "9" || override.OccurrenceStart == nil {
+		t.Fatalf("expected override of series 9, got %+v", override)
+	}
+
+	created, err := repo.CreateEvent(newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "New"))
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+	if _, err := calendar.ParseEventID(string(created.ID)); err != nil || len(created.ID) != 26 {
+		t.Fatalf("expected ULID for a new event, got %q", created.ID)
+	}
+
+	// Прежние ID идут раньше новых при равном начале
+	events, _ := repo.GetEventsInRange(1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	if len(events) != 3 || events[0].ID != "9" || events[1].ID != created.ID {
+		t.Fatalf("unexpected order after migration: %+v", events)
+	}
+
+	if err := repo.DeleteEvent(1, "9", 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if _, err := repo.GetEvent("10"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected override to be deleted with the series, got %v", err)
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	repo := openTestRepository(t)
+
+	if timeZone, err := repo.GetTimeZone(1); err != nil || timeZone != "" {
+		t.Fatalf("expected no time zone, got %q, %v", timeZone, err)
+	}
+
+	repo.SetTimeZone(1, "Europe/Moscow")
+	repo.SetTimeZone(1, "Asia/Vladivostok")
+
+	if timeZone, _ := repo.GetTimeZone(1); timeZone != "Asia/Vladivostok" {
+		t.Fatalf("expected Asia/Vladivostok, got %q", timeZone)
+	}
+
+	event := newEvent(1, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), "Christmas")
+	event.TimeZone = "Asia/Vladivostok"
+	created, _ := repo.CreateEvent(event)
+
+	got, _ := repo.GetEvent(created.ID)
+	if got.TimeZone != "Asia/Vladivostok" {
+		t.Fatalf("expected event time zone to be stored, got %q", got.TimeZone)
+	}
+}
+
+func TestRecurringSeries(t *testing.T) {
+	repo := openTestRepository(t)
+
+	series := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Stand-up")
+	series.RRule = "FREQ=WEEKLY;BYDAY=MO"
+	created, _ := repo.CreateEvent(series)
+	repo.CreateEvent(newEvent(1, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), "Lunch"))
+
+	// Серия начинается задолго до интервала, но должна попасть в выборку
+	events, err := repo.GetEventsInRange(1, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetEventsInRange failed: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != created.ID || events[0].RRule != series.RRule {
+		t.Fatalf("expected the series only, got %+v", events)
+	}
+
+	// Серия, начавшаяся после интервала, не возвращается
+	if events, _ := repo.GetEventsInRange(1, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)); len(events) != 0 {
+		t.Fatalf("expected no events before the series, got %+v", events)
+	}
+}
+
+func TestUpdateSeries(t *testing.T) {
+	repo := openTestRepository(t)
+
+	series := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Weekly")
+	series.RRule = "FREQ=WEEKLY"
+	series, _ = repo.CreateEvent(series)
+
+	occurrence := series.Start.AddDate(0, 0, 7)
+	series.RRule = "FREQ=WEEKLY;COUNT=5"
+	series.ExDates = []time.Time{occurrence}
+	override, err := repo.UpdateSeries(series, &calendar.Event{
+		UserID:          1,
+		Start:           occurrence.Add(time.Hour),
+		End:             occurrence.Add(time.Hour),
+		Title:           "Moved",
+		SeriesID:        series.ID,
+		OccurrenceStart: &occurrence,
+	})
+	if err != nil {
+		t.Fatalf("UpdateSeries failed: %v", err)
+	}
+
+	got, _ := repo.GetEvent(series.ID)
+	if got.RRule != "FREQ=WEEKLY;COUNT=5" || len(got.ExDates) != 1 || !got.ExDates[0].Equal(occurrence) {
+		t.Fatalf("unexpected series after update: %+v", got)
+	}
+	got, _ = repo.GetEvent(override.ID)
+	if got.SeriesID != series.ID || got.OccurrenceStart == nil || !got.OccurrenceStart.Equal(occurrence) {
+		t.Fatalf("unexpected override: %+v", got)
+	}
+
+	if _, err := repo.UpdateSeries(calendar.Event{ID: "999", UserID: 1}, nil); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+
+	// Удаление серии удаляет ее измененные вхождения
+	if err := repo.DeleteEvent(1, series.ID, 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if _, err := repo.GetEvent(override.ID); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected override to be deleted, got %v", err)
+	}
+}
+
+func TestUpdateSeriesSchedule(t *testing.T) {
+	repo := openTestRepository(t)
+
+	series := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Weekly")
+	series.RRule = "FREQ=WEEKLY"
+	series, _ = repo.CreateEvent(series)
+
+	occurrence := series.Start.AddDate(0, 0, 7)
+	series.ExDates = []time.Time{occurrence}
+	override, _ := repo.UpdateSeries(series, &calendar.Event{
+		UserID:          1,
+		Start:           occurrence.Add(time.Hour),
+		End:             occurrence.Add(time.Hour),
+		Title:           "Moved",
+		SeriesID:        series.ID,
+		OccurrenceStart: &occurrence,
+	})
+
+	// Измененное вхождение не может стать серией
+	repeating := override
+	repeating.RRule = "FREQ=DAILY"
+	if err := repo.UpdateEvent(repeating); !errors.Is(err, pkg.ErrInvalidRecurrence) {
+		t.Fatalf("expected ErrInvalidRecurrence, got %v", err)
+	}
+
+	// Серия без правила теряет отмененные и измененные вхождения
+	once := series
+	once.RRule = ""
+	once.Version = 0
+	if err := repo.UpdateEvent(once); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+	got, _ := repo.GetEvent(series.ID)
+	if got.RRule != "" || len(got.ExDates) != 0 {
+		t.Fatalf("unexpected event after clearing rule: %+v", got)
+	}
+	if _, err := repo.GetEvent(override.ID); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected override to be deleted, got %v", err)
+	}
+}
+
+func TestReplaceSeries(t *testing.T) {
+	repo := openTestRepository(t)
+
+	series := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Weekly")
+	series.RRule = "FREQ=WEEKLY"
+	series, _ = repo.CreateEvent(series)
+
+	first := series.Start.AddDate(0, 0, 7)
+	series.ExDates = []time.Time{first}
+	old, _ := repo.UpdateSeries(series, &calendar.Event{
+		UserID: 1, Start: first, End: first, Title: "Old", SeriesID: series.ID, OccurrenceStart: &first,
+	})
+
+	second := series.Start.AddDate(0, 0, 14)
+	replacement := calendar.Event{
+		ID: series.ID, UserID: 1, Start: series.Start, End: series.Start, Title: "Renamed", RRule: "FREQ=WEEKLY",
+		ExDates: []time.Time{second}, Version: 2,
+	}
+	moved := calendar.Event{UserID: 1, Start: second, End: second, Title: "Moved", SeriesID: series.ID, OccurrenceStart: &second}
+
+	// Устаревшая версия не меняет ничего
+	stale := replacement
+	stale.Version = 1
+	if err := repo.ReplaceSeries(stale, []calendar.Event{moved}); !errors.Is(err, pkg.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if overrides, _ := repo.GetOverrides(1, series.ID); len(overrides) != 1 || overrides[0].ID != old.ID {
+		t.Fatalf("expected old override to be kept, got %+v", overrides)
+	}
+
+	if err := repo.ReplaceSeries(replacement, []calendar.Event{moved}); err != nil {
+		t.Fatalf("ReplaceSeries failed: %v", err)
+	}
+	got, _ := repo.GetEvent(series.ID)
+	if got.Title != "Renamed" || len(got.ExDates) != 1 || !got.ExDates[0].Equal(second) || got.Version != 3 {
+		t.Fatalf("unexpected series after replace: %+v", got)
+	}
+	overrides, _ := repo.GetOverrides(1, series.ID)
+	if len(overrides) != 1 || overrides[0].Title != "Moved" || !overrides[0].OccurrenceStart.Equal(second) {
+		t.Fatalf("unexpected overrides after replace: %+v", overrides)
+	}
+	if overrides, _ := repo.GetOverrides(2, series.ID); len(overrides) != 0 {
+		t.Fatalf("expected no overrides for another user, got %+v", overrides)
+	}
+}
+
+func TestEventUID(t *testing.T) {
+	repo := openTestRepository(t)
+
+	event := newEvent(1, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), "Imported")
+	event.UID = "a@example.com"
+	created, err := repo.CreateEvent(event)
+	if err != nil {
+		t.Fatalf("CreateEvent failed: %v", err)
+	}
+
+	if _, err := repo.CreateEvent(event); !errors.Is(err, pkg.ErrDuplicateUID) {
+		t.Fatalf("expected ErrDuplicateUID, got %v", err)
+	}
+
+	// UID уникален только в пределах пользователя
+	event.UserID = 2
+	if _, err := repo.CreateEvent(event); err != nil {
+		t.Fatalf("expected the same UID for another user, got %v", err)
+	}
+
+	got, err := repo.GetEventByUID(1, "a@example.com")
+	if err != nil || got.ID != created.ID {
+		t.Fatalf("expected event by UID, got %+v, %v", got, err)
+	}
+	if _, err := repo.GetEventByUID(1, "missing"); !errors.Is(err, pkg.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+}
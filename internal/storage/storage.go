@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"wb-calendar/config"
+	"wb-calendar/internal/calendar"
+	"wb-calendar/internal/storage/sqlite"
+	"wb-calendar/internal/storage/wal"
+)
+
+const (
+	TypeMemory = "memory"
+	TypeWAL    = "wal"
+	TypeSQLite = "sqlite"
+)
+
+// New создает хранилище событий по типу, указанному в конфигурации.
+// Возвращенный io.Closer нужно закрыть при остановке сервера.
+func New(cfg config.Storage) (calendar.Repository, io.Closer, error) {
+	switch cfg.Type {
+	case "", TypeMemory:
+		return calendar.NewCalendar(), nopCloser{}, nil
+	case TypeWAL:
+		return newWAL(cfg.WAL)
+	case TypeSQLite:
+		repo, err := sqlite.Open(cfg.SQLite.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repo, repo, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+	}
+}
+
+// newWAL восстанавливает календарь из снимков и журнала и подключает журнал для новых изменений
+func newWAL(cfg config.WAL) (calendar.Repository, io.Closer, error) {
+	store, err := wal.OpenStore(cfg.Path, wal.Options{
+		Fsync:     cfg.Fsync,
+		BatchSize: cfg.BatchSize,
+		Interval:  cfg.Interval,
+	}, wal.SnapshotOptions{
+		Dir:      cfg.Snapshot.Dir,
+		Interval: cfg.Snapshot.Interval,
+		EveryN:   cfg.Snapshot.EveryN,
+		Retain:   cfg.Snapshot.Retain,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return store.Calendar(), store, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,92 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// headerSize размер заголовка кадра: длина данных и их CRC32
+const headerSize = 8
+
+// maxFrameSize ограничивает размер одного кадра журнала, чтобы мусор
+// в хвосте файла не приводил к попытке выделить гигабайты памяти
+const maxFrameSize = 16 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errTorn    = errors.New("wal: torn record")
+	errCorrupt = errors.New("wal: corrupted record in the middle of the log")
+)
+
+// encodeFrame упаковывает данные в кадр [длина uint32][crc32 uint32][данные]
+func encodeFrame(payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[headerSize:], payload)
+	return buf
+}
+
+// readFrame читает один кадр. Возвращает io.EOF, если файл закончился
+// ровно на границе кадра, и errTorn, если кадр оборван или поврежден.
+func readFrame(reader *bufio.Reader, limit uint32) ([]byte, error) {
+	var header [headerSize]byte
+
+	n, err := io.ReadFull(reader, header[:])
+	if errors.Is(err, io.EOF) && n == 0 {
+		return nil, io.EOF
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errTorn
+	}
+	if err != nil {
+		return nil, fmt.Errorf("wal: read: %w", err)
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if size > limit {
+		return nil, errTorn
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errTorn
+		}
+		return nil, fmt.Errorf("wal: read: %w", err)
+	}
+
+	if crc32.Checksum(payload, crcTable) != checksum {
+		// Сбой при записи может испортить только последний кадр,
+		// поврежденный кадр в середине файла — это потеря данных
+		if _, err := reader.Peek(1); err == nil {
+			return nil, errCorrupt
+		}
+		return nil, errTorn
+	}
+
+	return payload, nil
+}
+
+// syncDir синхронизирует каталог, чтобы создание или переименование
+// файла не потерялось при сбое
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("wal: open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("wal: fsync directory: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"wb-calendar/internal/calendar"
+)
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+)
+
+// snapshotPath возвращает путь к снимку, после которого журнал
+// воспроизводится начиная с сегмента seq
+func snapshotPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%016d%s", snapshotPrefix, seq, snapshotSuffix))
+}
+
+// writeSnapshot атомарно записывает снимок: сначала во временный файл,
+// затем переименовывает его, так что читатель видит либо старый набор
+// снимков, либо новый снимок целиком
+func writeSnapshot(dir string, seq uint64, state calendar.State) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("wal: encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, snapshotPrefix+"*.tmp")
+	if err != nil {
+		return fmt.Errorf("wal: create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encodeFrame(payload)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("wal: write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("wal: fsync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("wal: close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), snapshotPath(dir, seq)); err != nil {
+		return fmt.Errorf("wal: rename snapshot: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// readSnapshot читает снимок и проверяет его контрольную сумму
+func readSnapshot(path string) (calendar.State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return calendar.State{}, fmt.Errorf("wal: open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return calendar.State{}, fmt.Errorf("wal: stat snapshot: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	payload, err := readFrame(reader, uint32(min(info.Size(), maxSnapshotSize)))
+	if errors.Is(err, io.EOF) || errors.Is(err, errTorn) {
+		return calendar.State{}, fmt.Errorf("wal: snapshot %s is corrupted", path)
+	}
+	if err != nil {
+		return calendar.State{}, err
+	}
+	if _, err := reader.Peek(1); err == nil {
+		return calendar.State{}, fmt.Errorf("wal: snapshot %s has trailing data", path)
+	}
+
+	var state calendar.State
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return calendar.State{}, fmt.Errorf("wal: decode snapshot %s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+// maxSnapshotSize максимальный размер снимка, который помещается в кадр
+const maxSnapshotSize = 1<<32 - 1
+
+// listSnapshots возвращает отсортированные по возрастанию номера снимков в каталоге
+func listSnapshots(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("wal: list snapshots: %w", err)
+	}
+
+	var snapshots []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, seq)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	return snapshots, nil
+}
@@ -0,0 +1,210 @@
+package wal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+// SnapshotOptions задает расписание снимков и их хранение
+type SnapshotOptions struct {
+	// Dir каталог для файлов снимков
+	Dir string
+	// Interval период между снимками, 0 отключает снимки по времени
+	Interval time.Duration
+	// EveryN число изменений, после которого делается снимок, 0 отключает
+	EveryN int
+	// Retain сколько последних снимков хранить на случай повреждения новейшего
+	Retain int
+}
+
+// Store хранилище событий на диске: календарь в памяти, журнал изменений
+// и периодические снимки, после которых журнал сокращается
+type Store struct {
+	calendar  *calendar.Calendar
+	log       *Log
+	opts      SnapshotOptions
+	mutations atomic.Int64
+	trigger   chan struct{}
+	snapMutex sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// OpenStore восстанавливает календарь из новейшего целого снимка и хвоста
+// журнала после него. Если новейший снимок поврежден, используется более старый.
+func OpenStore(path string, logOpts Options, snapOpts SnapshotOptions) (*Store, error) {
+	if snapOpts.Retain < 1 {
+		snapOpts.Retain = 1
+	}
+	if err := os.MkdirAll(snapOpts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: create snapshot directory: %w", err)
+	}
+
+	l, err := Open(path, logOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		calendar: calendar.NewCalendar(),
+		log:      l,
+		opts:     snapOpts,
+		trigger:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	from, err := s.restore()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := l.ReplayFrom(from, s.calendar.Apply); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	s.calendar.SetJournal(s)
+	go s.loop()
+
+	return s, nil
+}
+
+// Calendar возвращает восстановленный календарь
+func (s *Store) Calendar() *calendar.Calendar {
+	return s.calendar
+}
+
+// Append записывает изменение в журнал и считает изменения для снимка по числу
+func (s *Store) Append(rec calendar.Record) error {
+	if err := s.log.Append(rec); err != nil {
+		return err
+	}
+
+	n := s.mutations.Add(1)
+	if s.opts.EveryN > 0 && n >= int64(s.opts.EveryN) {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Snapshot делает снимок календаря и удаляет сегменты журнала и старые снимки,
+// которые больше не нужны для восстановления
+func (s *Store) Snapshot() error {
+	s.snapMutex.Lock()
+	defer s.snapMutex.Unlock()
+
+	var (
+		seq   uint64
+		state calendar.State
+	)
+
+	err := s.calendar.Checkpoint(func(st calendar.State) error {
+		var err error
+		seq, err = s.log.Rotate()
+		state = st
+		s.mutations.Store(0)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(s.opts.Dir, seq, state); err != nil {
+		return err
+	}
+
+	return s.compact()
+}
+
+// Close останавливает снимки по расписанию и закрывает журнал
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+
+	return s.log.Close()
+}
+
+// restore загружает новейший целый снимок и возвращает сегмент, с которого
+// нужно воспроизводить журнал
+func (s *Store) restore() (uint64, error) {
+	snapshots, err := listSnapshots(s.opts.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		state, err := readSnapshot(snapshotPath(s.opts.Dir, snapshots[i]))
+		if err != nil {
+			log.Printf("wal: skipping snapshot: %v", err)
+			continue
+		}
+
+		s.calendar.Restore(state)
+		return snapshots[i], nil
+	}
+
+	return firstSegment, nil
+}
+
+// compact оставляет opts.Retain последних снимков и сегменты журнала,
+// нужные для восстановления из самого старого из них
+func (s *Store) compact() error {
+	snapshots, err := listSnapshots(s.opts.Dir)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	if len(snapshots) > s.opts.Retain {
+		for _, seq := range snapshots[:len(snapshots)-s.opts.Retain] {
+			if err := os.Remove(snapshotPath(s.opts.Dir, seq)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("wal: remove snapshot: %w", err)
+			}
+		}
+		snapshots = snapshots[len(snapshots)-s.opts.Retain:]
+	}
+
+	return s.log.RemoveBefore(snapshots[0])
+}
+
+func (s *Store) loop() {
+	defer close(s.done)
+
+	var tick <-chan time.Time
+	if s.opts.Interval > 0 {
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-s.trigger:
+		case <-s.stop:
+			return
+		}
+
+		// Без изменений новый снимок совпал бы с предыдущим
+		if s.mutations.Load() == 0 {
+			continue
+		}
+
+		if err := s.Snapshot(); err != nil {
+			log.Printf("wal: snapshot failed: %v", err)
+		}
+	}
+}
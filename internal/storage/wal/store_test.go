@@ -0,0 +1,157 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+func openStore(t *testing.T, dir string, snapOpts SnapshotOptions) *Store {
+	t.Helper()
+
+	snapOpts.Dir = filepath.Join(dir, "snapshots")
+	store, err := OpenStore(filepath.Join(dir, "events.wal"), Options{Fsync: FsyncAlways}, snapOpts)
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+
+	return store
+}
+
+func TestStoreRestoresSnapshotAndTail(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	store := openStore(t, dir, SnapshotOptions{Retain: 2})
+	cal := store.Calendar()
+	first, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+	second, _ := cal.CreateEvent(newEvent(1, date, "Dinner"))
+
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Изменения после снимка попадают только в хвост журнала
+	cal.UpdateEvent(calendar.Event{ID: first.ID, UserID: 1, Start: date, End: date, Title: "Boxing Day"})
+	cal.DeleteEvent(1, second.ID, 0)
+	store.Close()
+
+	store = openStore(t, dir, SnapshotOptions{Retain: 2})
+	defer store.Close()
+	cal = store.Calendar()
+
+	event, err := cal.GetEvent(first.ID)
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if event.Title != "Boxing Day" {
+		t.Fatalf("expected title 'Boxing Day', got %s", event.Title)
+	}
+	if _, err := cal.GetEvent(second.ID); err == nil {
+		t.Fatal("expected deleted event to stay deleted")
+	}
+
+	third, _ := cal.CreateEvent(newEvent(1, date, "New Year"))
+	if third.ID.Compare(second.ID) <= 0 {
+		t.Fatalf("expected next ID after %s, got %s", second.ID, third.ID)
+	}
+}
+
+func TestStoreCompactsLogAndSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	store := openStore(t, dir, SnapshotOptions{Retain: 2})
+	defer store.Close()
+
+	for i := 0; i < 4; i++ {
+		store.Calendar().CreateEvent(newEvent(1, date, "Event"))
+		if err := store.Snapshot(); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+	}
+
+	snapshots, _ := listSnapshots(filepath.Join(dir, "snapshots"))
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 retained snapshots, got %d", len(snapshots))
+	}
+
+	segments, _ := listSegments(filepath.Join(dir, "events.wal"))
+	if segments[0] != snapshots[0] {
+		t.Fatalf("expected log to start at oldest snapshot %d, got %d", snapshots[0], segments[0])
+	}
+}
+
+func TestStoreFallsBackToOlderSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	store := openStore(t, dir, SnapshotOptions{Retain: 2})
+	cal := store.Calendar()
+	cal.CreateEvent(newEvent(1, date, "Christmas"))
+	store.Snapshot()
+	cal.CreateEvent(newEvent(1, date, "Boxing Day"))
+	store.Snapshot()
+	cal.CreateEvent(newEvent(1, date, "New Year"))
+	store.Close()
+
+	snapshots, _ := listSnapshots(filepath.Join(dir, "snapshots"))
+	newest := snapshotPath(filepath.Join(dir, "snapshots"), snapshots[len(snapshots)-1])
+	data, _ := os.ReadFile(newest)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(newest, data, 0o644)
+
+	store = openStore(t, dir, SnapshotOptions{Retain: 2})
+	defer store.Close()
+
+	events, _ := store.Calendar().GetEventsInRange(1, date, date.AddDate(0, 0, 1))
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events restored from older snapshot, got %d", len(events))
+	}
+}
+
+func TestStoreSnapshotsAfterEveryN(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	store := openStore(t, dir, SnapshotOptions{EveryN: 3, Retain: 1})
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		store.Calendar().CreateEvent(newEvent(1, date, "Event"))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		snapshots, _ := listSnapshots(filepath.Join(dir, "snapshots"))
+		if len(snapshots) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("expected snapshot after 3 mutations")
+}
+
+func TestOpenMigratesSingleFileLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	event, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+	log.Close()
+
+	// Журнал предыдущей версии — один файл без номера сегмента
+	if err := os.Rename(segmentPath(path, firstSegment), path); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+
+	if _, err := cal.GetEvent(event.ID); err != nil {
+		t.Fatalf("expected event from migrated log: %v", err)
+	}
+}
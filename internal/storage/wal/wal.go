@@ -0,0 +1,430 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+// Политики синхронизации журнала с диском
+const (
+	// FsyncAlways вызывает fsync после каждой записи
+	FsyncAlways = "always"
+	// FsyncBatch вызывает fsync после каждых BatchSize записей
+	FsyncBatch = "batch"
+	// FsyncInterval вызывает fsync в фоне раз в Interval
+	FsyncInterval = "interval"
+)
+
+// firstSegment номер первого сегмента нового журнала
+const firstSegment uint64 = 1
+
+// Options задает политику синхронизации журнала
+type Options struct {
+	Fsync     string
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Log журнал изменений календаря, в который записи только дописываются.
+// Журнал разбит на сегменты <path>.<номер>; запись идет в последний сегмент,
+// а старые сегменты удаляются после того, как их покроет снимок.
+// Формат записи: [длина uint32][crc32 uint32][JSON calendar.Record].
+type Log struct {
+	path     string
+	segments []uint64
+	file     segmentFile
+	// size длина текущего сегмента без несостоявшихся записей
+	size    int64
+	opts    Options
+	pending int
+	// failed ошибка, после которой хвост сегмента не удалось откатить.
+	// Журнал с ней больше не принимает записи.
+	failed error
+	mutex  sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// segmentFile файл сегмента. В тестах подменяется, чтобы имитировать сбои записи.
+type segmentFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// Open открывает или создает журнал по указанному пути.
+// До первой записи журнал нужно прочитать через Replay,
+// чтобы отбросить оборванную последнюю запись.
+func Open(path string, opts Options) (*Log, error) {
+	switch opts.Fsync {
+	case "", FsyncAlways:
+		opts.Fsync = FsyncAlways
+	case FsyncBatch:
+		if opts.BatchSize <= 0 {
+			return nil, fmt.Errorf("wal: batch size must be positive, got %d", opts.BatchSize)
+		}
+	case FsyncInterval:
+		if opts.Interval <= 0 {
+			return nil, fmt.Errorf("wal: fsync interval must be positive, got %s", opts.Interval)
+		}
+	default:
+		return nil, fmt.Errorf("wal: unknown fsync policy %q", opts.Fsync)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("wal: create directory: %w", err)
+	}
+
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Журнал без сегментов, записанный предыдущей версией, становится первым сегментом
+	if len(segments) == 0 {
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, segmentPath(path, firstSegment)); err != nil {
+				return nil, fmt.Errorf("wal: migrate log: %w", err)
+			}
+		}
+		segments = []uint64{firstSegment}
+	}
+
+	l := &Log{path: path, segments: segments, opts: opts}
+
+	file, err := l.openSegment(segments[len(segments)-1])
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("wal: stat segment: %w", err)
+	}
+	l.file, l.size = file, info.Size()
+
+	if opts.Fsync == FsyncInterval {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.syncLoop()
+	}
+
+	return l, nil
+}
+
+// Replay последовательно передает в fn все записи журнала
+func (l *Log) Replay(fn func(calendar.Record) error) error {
+	return l.ReplayFrom(firstSegment, fn)
+}
+
+// ReplayFrom передает в fn записи всех сегментов, начиная с from.
+// Оборванная или поврежденная запись в конце последнего сегмента (результат
+// сбоя во время записи) отбрасывается, а сегмент усекается до последней
+// целой записи.
+func (l *Log) ReplayFrom(from uint64, fn func(calendar.Record) error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	expected := from
+	for _, seq := range l.segments {
+		if seq < from {
+			continue
+		}
+		if seq != expected {
+			return fmt.Errorf("wal: segment %d is missing", expected)
+		}
+		expected++
+
+		if err := l.replaySegment(seq, fn); err != nil {
+			return err
+		}
+	}
+
+	if expected == from {
+		return fmt.Errorf("wal: segment %d is missing", from)
+	}
+
+	return nil
+}
+
+// Append дописывает запись в журнал и синхронизирует его согласно политике.
+// Если запись или fsync не удались, кадр отрезается, чтобы при восстановлении
+// не применилось изменение, о сбое которого уже сообщили. Если отрезать
+// не удалось, журнал больше не принимает записи.
+func (l *Log) Append(rec calendar.Record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("wal: encode record: %w", err)
+	}
+	frame := encodeFrame(payload)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.failed != nil {
+		return fmt.Errorf("wal: log is unusable after a failed write: %w", l.failed)
+	}
+
+	if _, err := l.file.Write(frame); err != nil {
+		return l.rollback(fmt.Errorf("wal: write: %w", err))
+	}
+	l.pending++
+
+	switch l.opts.Fsync {
+	case FsyncAlways:
+		err = l.sync()
+	case FsyncBatch:
+		if l.pending >= l.opts.BatchSize {
+			err = l.sync()
+		}
+	}
+	if err != nil {
+		return l.rollback(err)
+	}
+
+	l.size += int64(len(frame))
+	return nil
+}
+
+// rollback отрезает несостоявшуюся запись после ошибки err и возвращает err.
+// Вызывается под блокировкой.
+func (l *Log) rollback(err error) error {
+	if truncErr := l.truncate(l.size); truncErr != nil {
+		l.failed = errors.Join(err, truncErr)
+		return l.failed
+	}
+
+	l.pending = 0
+	return err
+}
+
+// Rotate завершает текущий сегмент и начинает новый. Возвращает номер
+// нового сегмента: все записи, сделанные после Rotate, попадут в него.
+func (l *Log) Rotate() (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	seq := l.segments[len(l.segments)-1] + 1
+
+	file, err := l.openSegment(seq)
+	if err != nil {
+		return 0, err
+	}
+
+	// Завершенный сегмент должен быть на диске до того, как на него сошлется снимок
+	if err := l.file.Sync(); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("wal: fsync: %w", err)
+	}
+	l.file.Close()
+
+	l.file = file
+	l.size = 0
+	l.pending = 0
+	l.segments = append(l.segments, seq)
+
+	return seq, nil
+}
+
+// RemoveBefore удаляет сегменты с номером меньше seq. Текущий сегмент не удаляется.
+func (l *Log) RemoveBefore(seq uint64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current := l.segments[len(l.segments)-1]
+	kept := l.segments[:0]
+
+	var firstErr error
+	for _, s := range l.segments {
+		if s >= seq || s == current {
+			kept = append(kept, s)
+			continue
+		}
+		if err := os.Remove(segmentPath(l.path, s)); err != nil && !os.IsNotExist(err) {
+			kept = append(kept, s)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("wal: remove segment: %w", err)
+			}
+		}
+	}
+	l.segments = kept
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return syncDir(filepath.Dir(l.path))
+}
+
+// Close синхронизирует несохраненные записи и закрывает файл
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// replaySegment читает один сегмент. Вызывается под блокировкой.
+func (l *Log) replaySegment(seq uint64, fn func(calendar.Record) error) error {
+	current := seq == l.segments[len(l.segments)-1]
+
+	var file segmentFile = l.file
+	if !current {
+		f, err := os.Open(segmentPath(l.path, seq))
+		if err != nil {
+			return fmt.Errorf("wal: open segment: %w", err)
+		}
+		defer f.Close()
+		file = f
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("wal: seek: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		payload, err := readFrame(reader, maxFrameSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		// Завершенные сегменты синхронизируются при ротации и оборваться не могут
+		if errors.Is(err, errTorn) && current {
+			return l.truncate(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("wal: segment %d at offset %d: %w", seq, offset, err)
+		}
+
+		var rec calendar.Record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			// CRC совпал, значит данные записаны целиком, но не разбираются
+			return fmt.Errorf("wal: decode record in segment %d at offset %d: %w", seq, offset, err)
+		}
+
+		if err := fn(rec); err != nil {
+			return fmt.Errorf("wal: apply record in segment %d at offset %d: %w", seq, offset, err)
+		}
+
+		offset += int64(headerSize + len(payload))
+	}
+}
+
+// openSegment открывает сегмент на дозапись, создавая его при необходимости
+func (l *Log) openSegment(seq uint64) (*os.File, error) {
+	path := segmentPath(l.path, seq)
+
+	_, statErr := os.Stat(path)
+	created := os.IsNotExist(statErr)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: open segment: %w", err)
+	}
+
+	// Новый файл должен пережить сбой вместе с записью в каталоге
+	if created {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// sync вызывает fsync, если есть несинхронизированные записи. Вызывается под блокировкой.
+func (l *Log) sync() error {
+	if l.pending == 0 {
+		return nil
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("wal: fsync: %w", err)
+	}
+
+	l.pending = 0
+	return nil
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mutex.Lock()
+			// Ошибка повторится и будет возвращена из Close
+			_ = l.sync()
+			l.mutex.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// truncate отрезает хвост текущего сегмента после offset. Вызывается под блокировкой.
+func (l *Log) truncate(offset int64) error {
+	if err := l.file.Truncate(offset); err != nil {
+		return fmt.Errorf("wal: truncate torn tail: %w", err)
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("wal: fsync: %w", err)
+	}
+
+	l.size = offset
+	return nil
+}
+
+// segmentPath возвращает путь к сегменту журнала
+func segmentPath(path string, seq uint64) string {
+	return fmt.Sprintf("%s.%016d", path, seq)
+}
+
+// listSegments возвращает отсортированные номера существующих сегментов
+func listSegments(path string) ([]uint64, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("wal: list segments: %w", err)
+	}
+
+	var segments []uint64
+	for _, match := range matches {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(match, path+"."), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
@@ -0,0 +1,339 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wb-calendar/internal/calendar"
+)
+
+// newEvent создает событие без длительности
+func newEvent(userID int, start time.Time, title string) calendar.Event {
+	return calendar.Event{UserID: userID, Start: start, End: start, Title: title}
+}
+
+func openCalendar(t *testing.T, path string, opts Options) (*calendar.Calendar, *Log) {
+	t.Helper()
+
+	log, err := Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	cal := calendar.NewCalendar()
+	if err := log.Replay(cal.Apply); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	cal.SetJournal(log)
+
+	return cal, log
+}
+
+func TestReplayRestoresEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	first, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+	second, _ := cal.CreateEvent(newEvent(1, date, "Dinner"))
+	if err := cal.UpdateEvent(calendar.Event{ID: first.ID, UserID: 1, Start: date.AddDate(0, 0, 1), End: date.AddDate(0, 0, 1), Title: "Boxing Day"}); err != nil {
+		t.Fatalf("UpdateEvent failed: %v", err)
+	}
+	if err := cal.DeleteEvent(1, second.ID, 0); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+
+	event, err := cal.GetEvent(first.ID)
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if event.Title != "Boxing Day" || !event.Start.Equal(date.AddDate(0, 0, 1)) {
+		t.Fatalf("unexpected event after replay: %+v", event)
+	}
+	if _, err := cal.GetEvent(second.ID); err == nil {
+		t.Fatal("expected deleted event to stay deleted after replay")
+	}
+
+	// Новые ID после перезапуска продолжают возрастать
+	third, _ := cal.CreateEvent(newEvent(1, date, "After restart"))
+	if third.ID.Compare(second.ID) <= 0 {
+		t.Fatalf("expected next ID after %s, got %s", second.ID, third.ID)
+	}
+}
+
+func TestReplayTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	first, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+	torn, _ := cal.CreateEvent(newEvent(1, date, "Boxing Day"))
+	log.Close()
+
+	segment := segmentPath(path, firstSegment)
+	info, _ := os.Stat(segment)
+	// Имитируем сбой посреди записи второго события
+	if err := os.Truncate(segment, info.Size()-5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	if _, err := cal.GetEvent(first.ID); err != nil {
+		t.Fatalf("expected first event to survive: %v", err)
+	}
+	if _, err := cal.GetEvent(torn.ID); err == nil {
+		t.Fatal("expected torn event to be dropped")
+	}
+
+	// После усечения новые записи должны читаться
+	created, _ := cal.CreateEvent(newEvent(1, date, "New Year"))
+	log.Close()
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+
+	event, err := cal.GetEvent(created.ID)
+	if err != nil {
+		t.Fatalf("expected event written after truncation: %v", err)
+	}
+	if event.Title != "New Year" {
+		t.Fatalf("expected title 'New Year', got %s", event.Title)
+	}
+}
+
+// failingFile сегмент, запись, fsync и усечение которого завершаются ошибкой
+// по заданным флагам. Оборванная запись дописывает половину кадра,
+// fsync сбоит один раз.
+type failingFile struct {
+	segmentFile
+	failWrite    bool
+	failSync     bool
+	failTruncate bool
+}
+
+func (f *failingFile) Write(data []byte) (int, error) {
+	if f.failWrite {
+		n, _ := f.segmentFile.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	return f.segmentFile.Write(data)
+}
+
+func (f *failingFile) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errors.New("fsync failed")
+	}
+	return f.segmentFile.Sync()
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("truncate failed")
+	}
+	return f.segmentFile.Truncate(size)
+}
+
+func TestAppendRollsBackFailedWrite(t *testing.T) {
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		file failingFile
+	}{
+		{name: "short write", file: failingFile{failWrite: true}},
+		{name: "failed fsync", file: failingFile{failSync: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "events.wal")
+			cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+			first, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+
+			failing := tt.file
+			failing.segmentFile = log.file
+			log.file = &failing
+			if _, err := cal.CreateEvent(newEvent(1, date, "Lost")); err == nil {
+				t.Fatal("expected append to fail")
+			}
+
+			// После сбоя журнал продолжает принимать записи
+			log.file = failing.segmentFile
+			last, err := cal.CreateEvent(newEvent(1, date, "New Year"))
+			if err != nil {
+				t.Fatalf("CreateEvent after failure failed: %v", err)
+			}
+			log.Close()
+
+			cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+			defer log.Close()
+			events, _ := cal.GetEventsInRange(1, date, date.AddDate(0, 0, 1))
+			if len(events) != 2 || events[0].ID != first.ID || events[1].ID != last.ID {
+				t.Fatalf("expected only acknowledged events after replay, got %+v", events)
+			}
+		})
+	}
+
+	// Если хвост не удалось отрезать, журнал отказывает во всех следующих записях
+	path := filepath.Join(t.TempDir(), "events.wal")
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+	failing := &failingFile{segmentFile: log.file, failWrite: true, failTruncate: true}
+	log.file = failing
+	if _, err := cal.CreateEvent(newEvent(1, date, "Lost")); err == nil {
+		t.Fatal("expected append to fail")
+	}
+	log.file = failing.segmentFile
+	if _, err := cal.CreateEvent(newEvent(1, date, "After")); err == nil {
+		t.Fatal("expected log to reject appends after a failed rollback")
+	}
+}
+
+func TestReplayRejectsCorruptionInTheMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	cal.CreateEvent(newEvent(1, date, "Christmas"))
+	cal.CreateEvent(newEvent(1, date, "Boxing Day"))
+	log.Close()
+
+	segment := segmentPath(path, firstSegment)
+	data, _ := os.ReadFile(segment)
+	data[headerSize+2] ^= 0xff
+	os.WriteFile(segment, data, 0o644)
+
+	log, err := Open(path, Options{Fsync: FsyncAlways})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer log.Close()
+
+	if err := log.Replay(calendar.NewCalendar().Apply); err == nil {
+		t.Fatal("expected error for corrupted record in the middle of the log")
+	}
+}
+
+func TestOpenValidatesOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+
+	tests := []Options{
+		{Fsync: "sometimes"},
+		{Fsync: FsyncBatch},
+		{Fsync: FsyncInterval},
+	}
+
+	for _, opts := range tests {
+		if _, err := Open(path, opts); err == nil {
+			t.Errorf("expected error for options %+v", opts)
+		}
+	}
+}
+
+func TestBatchAndIntervalPolicies(t *testing.T) {
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	for _, opts := range []Options{
+		{Fsync: FsyncBatch, BatchSize: 2},
+		{Fsync: FsyncInterval, Interval: 10 * time.Millisecond},
+	} {
+		t.Run(opts.Fsync, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "events.wal")
+
+			cal, log := openCalendar(t, path, opts)
+			for i := 0; i < 5; i++ {
+				cal.CreateEvent(newEvent(1, date, "Event"))
+			}
+			if err := log.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			cal, log = openCalendar(t, path, opts)
+			defer log.Close()
+
+			events, _ := cal.GetEventsInRange(1, date, date.AddDate(0, 0, 1))
+			if len(events) != 5 {
+				t.Fatalf("expected 5 events after replay, got %d", len(events))
+			}
+		})
+	}
+}
+
+func TestReplayRestoresBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	date := time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	existing, _ := cal.CreateEvent(newEvent(1, date, "Christmas"))
+	if _, err := cal.ApplyBatch([]calendar.BatchOp{
+		{Type: calendar.BatchCreate, Event: newEvent(1, date, "Dinner")},
+		{Type: calendar.BatchDelete, Event: calendar.Event{ID: existing.ID, UserID: 1}},
+	}, true); err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+
+	// Отмененный пакет не попадает в журнал
+	if _, err := cal.ApplyBatch([]calendar.BatchOp{
+		{Type: calendar.BatchCreate, Event: newEvent(1, date, "Lost")},
+		{Type: calendar.BatchDelete, Event: calendar.Event{ID: existing.ID, UserID: 1}},
+	}, true); err == nil {
+		t.Fatal("expected batch to fail")
+	}
+	log.Close()
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+
+	if _, err := cal.GetEvent(existing.ID); err == nil {
+		t.Fatal("expected deleted event to stay deleted after replay")
+	}
+	events, _ := cal.GetEventsInRange(1, date, date.AddDate(0, 0, 1))
+	if len(events) != 1 || events[0].Title != "Dinner" {
+		t.Fatalf("expected only the batch event after replay, got %+v", events)
+	}
+}
+
+func TestReplayRestoresSeriesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	cal, log := openCalendar(t, path, Options{Fsync: FsyncAlways})
+	series, _ := cal.CreateEvent(calendar.Event{UserID: 1, Start: start, End: start, Title: "Weekly", RRule: "FREQ=WEEKLY"})
+	occurrence := start.AddDate(0, 0, 7)
+	series.ExDates = []time.Time{occurrence}
+	override, err := cal.UpdateSeries(series, &calendar.Event{UserID: 1, Start: occurrence.Add(time.Hour), End: occurrence.Add(time.Hour), Title: "Moved", SeriesID: series.ID, OccurrenceStart: &occurrence})
+	if err != nil {
+		t.Fatalf("UpdateSeries failed: %v", err)
+	}
+	log.Close()
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+
+	got, _ := cal.GetEvent(series.ID)
+	if len(got.ExDates) != 1 || !got.ExDates[0].Equal(occurrence) {
+		t.Fatalf("expected exception after replay, got %+v", got)
+	}
+	if got, err := cal.GetEvent(override.ID); err != nil || got.SeriesID != series.ID {
+		t.Fatalf("expected override after replay, got %+v, %v", got, err)
+	}
+
+	// Удаление серии записывается одной записью вместе с вхождениями
+	cal.DeleteEvent(1, series.ID, 0)
+	log.Close()
+
+	cal, log = openCalendar(t, path, Options{Fsync: FsyncAlways})
+	defer log.Close()
+	if _, err := cal.GetEvent(override.ID); err == nil {
+		t.Fatal("expected override to stay deleted after replay")
+	}
+}
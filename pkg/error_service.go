@@ -1,8 +1,132 @@
 package pkg
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Error ошибка с устойчивым кодом для клиентов API. Status — HTTP-статус ответа,
+// Field — поле запроса, к которому относится ошибка, если оно известно.
+// Ошибки с одинаковым кодом считаются равными для errors.Is, поэтому
+// копии с уточненным полем или сообщением совпадают с исходной ошибкой.
+type Error struct {
+	Code    string
+	Message string
+	Field   string
+	Status  int
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is сравнивает ошибки по коду
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// ForField возвращает копию ошибки, относящуюся к полю field
+func (e *Error) ForField(field string) *Error {
+	c := *e
+	c.Field = field
+	return &c
+}
+
+// WithMessage возвращает копию ошибки с другим сообщением
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
 
 var (
-	ErrEventNotFound = errors.New("event not found")
-	ErrInvalidDate   = errors.New("invalid date format")
+	ErrEventNotFound      = &Error{Code: "event_not_found", Message: "event not found", Status: http.StatusNotFound}
+	ErrInvalidDate        = &Error{Code: "invalid_date", Message: "invalid date format", Field: "date", Status: http.StatusUnprocessableEntity}
+	ErrInvalidRange       = &Error{Code: "invalid_range", Message: "invalid date range", Field: "to", Status: http.StatusUnprocessableEntity}
+	ErrEndBeforeStart     = &Error{Code: "end_before_start", Message: "end must not be before start", Field: "end", Status: http.StatusUnprocessableEntity}
+	ErrInvalidTimeZone    = &Error{Code: "invalid_time_zone", Message: "invalid time zone, expected IANA name like Europe/Moscow", Field: "time_zone", Status: http.StatusUnprocessableEntity}
+	ErrInvalidRecurrence  = &Error{Code: "invalid_recurrence", Message: "invalid recurrence rule", Field: "rrule", Status: http.StatusUnprocessableEntity}
+	ErrInvalidScope       = &Error{Code: "invalid_scope", Message: "scope must be one of all, this, following", Field: "scope", Status: http.StatusUnprocessableEntity}
+	ErrOccurrenceNotFound = &Error{Code: "occurrence_not_found", Message: "occurrence not found", Field: "recurrence_id", Status: http.StatusNotFound}
+	ErrDuplicateUID       = &Error{Code: "duplicate_uid", Message: "event with this uid already exists", Field: "uid", Status: http.StatusConflict}
+	ErrVersionMismatch    = &Error{Code: "version_mismatch", Message: "event has been modified, version does not match", Status: http.StatusPreconditionFailed}
+
+	// Ошибки аутентификации
+	ErrUnauthorized = &Error{Code: "unauthorized", Message: "authentication required", Status: http.StatusUnauthorized}
+	ErrForbidden    = &Error{Code: "forbidden", Message: "user_id does not match the authenticated user", Field: "user_id", Status: http.StatusForbidden}
+
+	// Ошибки ключей API
+	ErrInsufficientScope = &Error{Code: "insufficient_scope", Message: "credentials do not have the required scope", Status: http.StatusForbidden}
+	ErrAdminRequired     = &Error{Code: "admin_required", Message: "administrator credentials are required", Status: http.StatusForbidden}
+	ErrAPIKeyNotFound    = &Error{Code: "api_key_not_found", Message: "api key not found", Status: http.StatusNotFound}
+
+	// Ошибки повторных запросов с Idempotency-Key
+	ErrIdempotencyKeyReused  = &Error{Code: "idempotency_key_reused", Message: "idempotency key was already used with a different request", Field: "Idempotency-Key", Status: http.StatusConflict}
+	ErrIdempotencyInProgress = &Error{Code: "idempotency_key_in_progress", Message: "request with this idempotency key is still in progress", Field: "Idempotency-Key", Status: http.StatusConflict}
+
+	// Ошибки проверки запроса
+	ErrInvalidBody  = &Error{Code: "invalid_body", Message: "invalid request body", Status: http.StatusBadRequest}
+	ErrRequired     = &Error{Code: "required", Message: "field is required", Status: http.StatusUnprocessableEntity}
+	ErrInvalidValue = &Error{Code: "invalid_value", Message: "invalid value", Status: http.StatusUnprocessableEntity}
+	ErrValidation   = &Error{Code: "validation_failed", Message: "request validation failed", Status: http.StatusUnprocessableEntity}
+	ErrTooLarge     = &Error{Code: "too_large", Message: "request body is too large", Status: http.StatusRequestEntityTooLarge}
+
+	ErrUnsupportedMediaType = &Error{Code: "unsupported_media_type", Message: "unsupported content type", Status: http.StatusUnsupportedMediaType}
+
+	ErrInternal = &Error{Code: "internal_error", Message: "internal server error", Status: http.StatusInternalServerError}
 )
+
+// Required возвращает ошибку отсутствующего обязательного поля
+func Required(field, message string) *Error {
+	return ErrRequired.ForField(field).WithMessage(message)
+}
+
+// Invalid возвращает ошибку неверного значения поля
+func Invalid(field, message string) *Error {
+	return ErrInvalidValue.ForField(field).WithMessage(message)
+}
+
+// ValidationError собирает ошибки всех неверных полей запроса,
+// чтобы клиент получил их одним ответом
+type ValidationError struct {
+	Errors []*Error
+}
+
+// Add добавляет ошибку. Ошибки без кода считаются неверным значением,
+// вложенные ValidationError разворачиваются.
+func (v *ValidationError) Add(err error) {
+	var nested *ValidationError
+	if errors.As(err, &nested) {
+		v.Errors = append(v.Errors, nested.Errors...)
+		return
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		e = ErrInvalidValue
+	}
+	v.Errors = append(v.Errors, e.WithMessage(err.Error()))
+}
+
+// Err возвращает v, если есть ошибки, иначе nil
+func (v *ValidationError) Err() error {
+	if len(v.Errors) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *ValidationError) Error() string {
+	messages := make([]string, 0, len(v.Errors))
+	for _, e := range v.Errors {
+		messages = append(messages, e.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is позволяет проверять ошибку через errors.Is(err, ErrValidation)
+func (v *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
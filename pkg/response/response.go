@@ -1,19 +1,95 @@
 package response
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"wb-calendar/pkg"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ProblemContentType тип ответа об ошибке по RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Problem тело ответа об ошибке по RFC 7807. Code — устойчивый код ошибки,
+// Errors перечисляет ошибки отдельных полей запроса.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError ошибка отдельного поля запроса
+type FieldError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
 func JSONResult(ctx *gin.Context, data interface{}) {
 	ctx.JSON(http.StatusOK, gin.H{
 		"result": data,
 	})
 }
 
-func JSONError(ctx *gin.Context, status int, msg string) {
+// JSONResultStatus отвечает результатом с заданным статусом, например 201 Created
+func JSONResultStatus(ctx *gin.Context, status int, data interface{}) {
 	ctx.JSON(status, gin.H{
-		"error": msg,
+		"result": data,
 	})
 }
+
+// Error отвечает на ошибку в формате application/problem+json. Статус и код
+// берутся из pkg.Error в цепочке err; прочие ошибки считаются внутренними:
+// клиент получает общий ответ, а сама ошибка пишется в лог вместе с запросом.
+func Error(ctx *gin.Context, err error) {
+	problem := NewProblem(err, ctx.Request.URL.Path)
+	if problem.Status == http.StatusInternalServerError {
+		log.Printf("internal error: %s %s: %v", ctx.Request.Method, ctx.Request.URL.Path, err)
+	}
+	ctx.Header("Content-Type", ProblemContentType)
+	ctx.JSON(problem.Status, problem)
+}
+
+// NewProblem строит тело ответа об ошибке для запроса к instance
+func NewProblem(err error, instance string) Problem {
+	problem := Problem{Type: "about:blank", Instance: instance}
+
+	var validation *pkg.ValidationError
+	var e *pkg.Error
+	if !errors.As(err, &validation) && errors.As(err, &e) && e.Status == pkg.ErrValidation.Status {
+		// Одиночная ошибка проверки отдается так же, как список ошибок полей
+		validation = &pkg.ValidationError{}
+		validation.Add(err)
+		err = validation
+	}
+
+	switch {
+	case errors.As(err, &validation):
+		problem.Code = pkg.ErrValidation.Code
+		problem.Status = pkg.ErrValidation.Status
+		problem.Detail = validation.Error()
+		for _, fieldErr := range validation.Errors {
+			problem.Errors = append(problem.Errors, FieldError{Code: fieldErr.Code, Field: fieldErr.Field, Message: fieldErr.Message})
+		}
+	case errors.As(err, &e):
+		problem.Code = e.Code
+		problem.Status = e.Status
+		problem.Detail = err.Error()
+		if e.Field != "" {
+			problem.Errors = []FieldError{{Code: e.Code, Field: e.Field, Message: err.Error()}}
+		}
+	default:
+		problem.Code = pkg.ErrInternal.Code
+		problem.Status = pkg.ErrInternal.Status
+		problem.Detail = pkg.ErrInternal.Message
+	}
+	problem.Title = http.StatusText(problem.Status)
+
+	return problem
+}